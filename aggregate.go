@@ -0,0 +1,192 @@
+package scratchdb
+
+import (
+	"fmt"
+	"strconv"
+)
+
+type AggregateFunc uint32
+
+const (
+	AggregateCount AggregateFunc = iota + 1
+	AggregateMin
+	AggregateMax
+	AggregateSum
+	AggregateAvg
+)
+
+var aggregateFuncNames = map[AggregateFunc]string{
+	AggregateCount: "count",
+	AggregateMin:   "min",
+	AggregateMax:   "max",
+	AggregateSum:   "sum",
+	AggregateAvg:   "avg",
+}
+
+func (f AggregateFunc) String() string {
+	return aggregateFuncNames[f]
+}
+
+// parseAggregateFunc returns the function with the name, ok is false when there is none
+func parseAggregateFunc(name string) (f AggregateFunc, ok bool) {
+	for f, fname := range aggregateFuncNames {
+		if name == fname {
+			return f, true
+		}
+	}
+	return 0, false
+}
+
+// SelectColumn is a column of the select list: a column of the table, or an
+// aggregate over the rows of a group when Func is set, e.g. count(*) or max(id)
+type SelectColumn struct {
+	// Func is the aggregate function, 0 for a column of the table
+	Func AggregateFunc
+	// Column is the column read, empty for count(*)
+	Column string
+}
+
+// String returns the column as written in a select, it names its result column
+func (c SelectColumn) String() string {
+	if c.Func == 0 {
+		return c.Column
+	}
+	column := c.Column
+	if column == "" {
+		column = "*"
+	}
+	return fmt.Sprintf("%s(%s)", c.Func, column)
+}
+
+// aggregator computes an aggregate from the rows of a group as they are scanned
+type aggregator struct {
+	SelectColumn
+	// column is the position of the column in the row, -1 for count(*)
+	column int
+	// typ is the type of the column
+	typ   ColumnType
+	count uint64
+	// sum is the sum of an int or integer column, integers are added in two's
+	// complement and read back as an int64
+	sum     uint64
+	sumReal float64
+	// value is the smallest or largest value so far, nil before the first row
+	value interface{}
+}
+
+// bindAggregate resolves the column of the aggregate, sum and avg need a
+// number column: int, integer or real
+func bindAggregate(schema *Schema, agg SelectColumn) (*aggregator, error) {
+	a := &aggregator{SelectColumn: agg, column: -1}
+	if agg.Column == "" {
+		return a, nil
+	}
+	column, ok := schema.columnIndex(agg.Column)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchColumn, agg.Column)
+	}
+	typ := schema.Columns[column].Type
+	if (agg.Func == AggregateSum || agg.Func == AggregateAvg) && !typ.isNumber() {
+		return nil, fmt.Errorf("%w: %s needs an int, integer or real column", ErrSyntax, agg)
+	}
+	a.column, a.typ = column, typ
+	return a, nil
+}
+
+// reset returns an aggregator of the same aggregate without rows
+func (a *aggregator) reset() *aggregator {
+	return &aggregator{SelectColumn: a.SelectColumn, column: a.column, typ: a.typ}
+}
+
+// add aggregates the row, the aggregates of a column skip its NULL values
+func (a *aggregator) add(row Row) {
+	if a.column < 0 {
+		a.count++
+		return
+	}
+	value := row[a.column]
+	if value == nil {
+		return
+	}
+	a.count++
+	switch a.Func {
+	case AggregateSum, AggregateAvg:
+		switch v := value.(type) {
+		case uint32:
+			a.sum += uint64(v)
+		case int64:
+			a.sum += uint64(v)
+		case float64:
+			a.sumReal += v
+		}
+	case AggregateMin:
+		if a.value == nil || compareValues(value, a.value) < 0 {
+			a.value = value
+		}
+	case AggregateMax:
+		if a.value == nil || compareValues(value, a.value) > 0 {
+			a.value = value
+		}
+	}
+}
+
+// result returns the value of the aggregate: a uint64 for count, a uint64,
+// int64 or float64 for the sum of an int, integer or real column, a float64
+// for avg and a value of the column for min and max. Only count has a value
+// without rows or with only NULL values, the others are nil.
+func (a *aggregator) result() interface{} {
+	switch a.Func {
+	case AggregateCount:
+		return a.count
+	case AggregateSum:
+		if a.count == 0 {
+			return nil
+		}
+		switch a.typ {
+		case ColumnTypeInteger:
+			return int64(a.sum)
+		case ColumnTypeReal:
+			return a.sumReal
+		}
+		return a.sum
+	case AggregateAvg:
+		if a.count == 0 {
+			return nil
+		}
+		switch a.typ {
+		case ColumnTypeInteger:
+			return float64(int64(a.sum)) / float64(a.count)
+		case ColumnTypeReal:
+			return a.sumReal / float64(a.count)
+		}
+		return float64(a.sum) / float64(a.count)
+	default:
+		return a.value
+	}
+}
+
+// resultColumn returns the column of the aggregate in the result set
+func (a *aggregator) resultColumn(schema *Schema) Column {
+	col := Column{Name: a.String(), Type: ColumnTypeInt, Size: 8}
+	switch {
+	case a.Func == AggregateAvg:
+		col.Type = ColumnTypeReal
+	case a.Func == AggregateSum && a.typ != ColumnTypeInt:
+		col.Type = a.typ
+	case a.Func == AggregateMin || a.Func == AggregateMax:
+		col.Type, col.Size = schema.Columns[a.column].Type, schema.Columns[a.column].Size
+	}
+	return col
+}
+
+// parseResult converts the value of a having condition to the type of the result
+func (a *aggregator) parseResult(schema *Schema, value string) (interface{}, error) {
+	if a.Func == AggregateCount || a.Func == AggregateSum && a.typ == ColumnTypeInt {
+		v, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s %s", ErrSyntax, a, value)
+		}
+		return v, nil
+	}
+	return parseValue(a.resultColumn(schema), value)
+}
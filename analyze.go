@@ -0,0 +1,92 @@
+package scratchdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+)
+
+// tableStats are the statistics analyze gathers on a table
+type tableStats struct {
+	rows uint64
+}
+
+// indexStats are the statistics analyze gathers on an index. distinct is the
+// number of distinct values of the column, NULL counting as one, an equality
+// on the column is estimated to match rows/distinct entries.
+type indexStats struct {
+	distinct uint64
+}
+
+// executeAnalyze gathers the statistics of the table the statement names, or
+// of every table, and stores them in the catalog for the planner
+func executeAnalyze(ctx context.Context, stmt *Statement, pager *Pager) error {
+	tables, err := readCatalog(pager)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, table := range tables {
+		if stmt.Table != "" && table.schema.Name != stmt.Table {
+			continue
+		}
+		found = true
+		if err := analyzeTable(ctx, table); err != nil {
+			return err
+		}
+	}
+	if stmt.Table != "" && !found {
+		return fmt.Errorf("%w: %s", ErrNoSuchTable, stmt.Table)
+	}
+	return writeCatalog(pager, tables)
+}
+
+// analyzeTable counts the rows of the table and the distinct values of each
+// of its indexes. The keys of an index come in the order of their values and
+// hold the whole value, so a value is distinct from the one of the previous
+// key when their encoded values differ.
+func analyzeTable(ctx context.Context, table *Table) error {
+	var rows uint64
+	if err := scanKeys(ctx, table, func(key []byte) { rows++ }); err != nil {
+		return err
+	}
+	table.stats = &tableStats{rows: rows}
+
+	for _, idx := range table.indexes {
+		var distinct uint64
+		var last []byte
+		err := scanKeys(ctx, idx.tree, func(key []byte) {
+			if value := keyValue(key); distinct == 0 || !bytes.Equal(value, last) {
+				distinct++
+				last = value
+			}
+		})
+		if err != nil {
+			return err
+		}
+		idx.stats = &indexStats{distinct: distinct}
+	}
+	return nil
+}
+
+// scanKeys calls visit with every key of the tree in order
+func scanKeys(ctx context.Context, tree *Table, visit func(key []byte)) error {
+	c, err := tableSeek(tree, nil)
+	if err != nil {
+		return err
+	}
+	for !c.End() {
+		if ctx.Err() != nil {
+			return ErrCancelled
+		}
+		key, err := c.key()
+		if err != nil {
+			return err
+		}
+		visit(key)
+		if err := c.Advance(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package scratchdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// archiveSuffix ends the names of the archived logs, which start with the
+// LSN of their last commit padded to sort in order
+const archiveSuffix = ".wal"
+
+func archiveName(lsn uint64) string {
+	return fmt.Sprintf("%020d%s", lsn, archiveSuffix)
+}
+
+// archiveLog copies the committed frames of the log to the archive directory
+// before a checkpoint empties it. It must be called with mu held.
+func (p *Pager) archiveLog() error {
+	header := make([]byte, p.pageSize)
+	ok, err := p.wal.readPage(headerPageNum, header)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("archive log: no commit in the log")
+	}
+	lsn, _ := headerCommit(header)
+
+	path := filepath.Join(p.archiveDir, archiveName(lsn))
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("archive log: %w", err)
+	}
+	_, err = io.Copy(file, io.NewSectionReader(p.wal.file, 0, p.wal.size))
+	if err == nil {
+		err = syncFile(file)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("archive log: %w", err)
+	}
+	if err := syncDir(p.archiveDir); err != nil {
+		return err
+	}
+	p.log.Infof("archive the log up to commit %d to %s", lsn, path)
+	return nil
+}
+
+// RestoreOptions configure Restore, without ToLSN and ToTime every archived
+// commit is replayed
+type RestoreOptions struct {
+	// ArchiveDir is the Options.ArchiveDir the logs were archived to
+	ArchiveDir string
+	// ToLSN stops the replay after the commit with this LSN
+	ToLSN uint64
+	// ToTime stops the replay after the last commit at or before it
+	ToTime time.Time
+	// Logger receives the replayed commits, nothing is logged when it is nil
+	Logger *Logger
+}
+
+// RestoreResult is the last commit a restored database holds, the number of
+// commits replayed onto the backup and of archived logs read
+type RestoreResult struct {
+	LSN      uint64
+	Time     time.Time
+	Commits  uint64
+	Segments int
+}
+
+// Restore writes a database file at path, which must not exist, from the
+// backup at backupPath and the logs archived after it. The commits are
+// replayed in LSN order up to the one requested by opts, so the database is
+// restored as it was at that point. It fails when a commit is missing, like
+// after a vacuum, whose copy is never archived: a backup taken after it is
+// needed to restore past it.
+func Restore(backupPath, path string, opts RestoreOptions) (RestoreResult, error) {
+	var res RestoreResult
+	if _, err := os.Stat(walPath(path)); err == nil {
+		return res, fmt.Errorf("restore: %s exists, it would be replayed over the database", walPath(path))
+	}
+	if err := copyFile(backupPath, path); err != nil {
+		return res, fmt.Errorf("restore: %w", err)
+	}
+	if err := restore(path, opts, &res); err != nil {
+		os.Remove(path)
+		return res, fmt.Errorf("restore: %w", err)
+	}
+	return res, nil
+}
+
+func restore(path string, opts RestoreOptions, res *RestoreResult) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	pageSize, err := databasePageSize(file, stat.Size(), "", 0)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, pageSize)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return fmt.Errorf("read header: %w", err)
+	}
+	if !validPageChecksum(header) {
+		return fmt.Errorf("%w: page %d", ErrChecksum, headerPageNum)
+	}
+	res.LSN, res.Time = headerCommit(header)
+	if opts.ToLSN != 0 && opts.ToLSN < res.LSN {
+		return fmt.Errorf("the backup is at commit %d, past %d", res.LSN, opts.ToLSN)
+	}
+	opts.Logger.Infof("restore %s from commit %d of %s", path, res.LSN, res.Time.Format(time.RFC3339Nano))
+
+	segments, err := archivedLogs(opts.ArchiveDir, res.LSN)
+	if err != nil {
+		return err
+	}
+	done := false
+	for _, segment := range segments {
+		if done {
+			break
+		}
+		err := readCommits(segment, pageSize, func(pages map[uint32][]byte, numPages uint32) (bool, error) {
+			page, ok := pages[headerPageNum]
+			if !ok {
+				return false, fmt.Errorf("%s: commit without header", segment)
+			}
+			lsn, t := headerCommit(page)
+			switch {
+			case lsn <= res.LSN:
+				return true, nil
+			case opts.ToLSN != 0 && lsn > opts.ToLSN, !opts.ToTime.IsZero() && t.After(opts.ToTime):
+				done = true
+				return false, nil
+			case lsn != res.LSN+1:
+				return false, fmt.Errorf("commit %d is missing from the archive, the next one is %d", res.LSN+1, lsn)
+			}
+
+			for pageNum, page := range pages {
+				if _, err := file.WriteAt(page, int64(pageNum)*int64(pageSize)); err != nil {
+					return false, fmt.Errorf("write page %d: %w", pageNum, err)
+				}
+			}
+			if err := file.Truncate(int64(numPages) * int64(pageSize)); err != nil {
+				return false, err
+			}
+			res.LSN, res.Time = lsn, t
+			res.Commits++
+			opts.Logger.Debugf("replay commit %d of %s, %d pages", lsn, t.Format(time.RFC3339Nano), len(pages))
+			return true, nil
+		})
+		if err != nil {
+			return err
+		}
+		res.Segments++
+	}
+	if opts.ToLSN != 0 && res.LSN < opts.ToLSN {
+		return fmt.Errorf("the archive ends at commit %d, before %d", res.LSN, opts.ToLSN)
+	}
+	if err := syncFile(file); err != nil {
+		return fmt.Errorf("sync db file: %w", err)
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// archivedLogs returns the paths of the logs in dir holding commits after
+// lsn, in order
+func archivedLogs(dir string, lsn uint64) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, archiveSuffix) {
+			continue
+		}
+		last, err := strconv.ParseUint(strings.TrimSuffix(name, archiveSuffix), 10, 64)
+		if err != nil || last <= lsn {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, name))
+	}
+	// the names are padded, so they sort by LSN
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// readCommits calls fn with the pages and the number of pages of each commit
+// of the log at path in order, until fn returns false. Frames after the last
+// commit are ignored, like on replay.
+func readCommits(path string, pageSize uint32, fn func(pages map[uint32][]byte, numPages uint32) (bool, error)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	header := make([]byte, WALHeaderSize)
+	if _, err := file.ReadAt(header, 0); err != nil || binary.BigEndian.Uint32(header) != WALMagic {
+		return fmt.Errorf("%s: not a scratchdb wal file", path)
+	}
+	if walPageSize := binary.BigEndian.Uint32(header[4:]); walPageSize != pageSize {
+		return fmt.Errorf("%s: wal page size %d does not match %d", path, walPageSize, pageSize)
+	}
+
+	frameSize := int64(WALFrameHeaderSize) + int64(pageSize)
+	pages := map[uint32][]byte{}
+	for offset := int64(WALHeaderSize); ; offset += frameSize {
+		frame := make([]byte, frameSize)
+		if _, err := file.ReadAt(frame, offset); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		pageNum, commitNumPages, ok := decodeFrameHeader(frame)
+		if !ok {
+			return fmt.Errorf("%s: bad frame checksum at offset %d", path, offset)
+		}
+		pages[pageNum] = frame[WALFrameHeaderSize:]
+		if commitNumPages == 0 {
+			continue
+		}
+		if more, err := fn(pages, commitNumPages); err != nil || !more {
+			return err
+		}
+		pages = map[uint32][]byte{}
+	}
+}
+
+// copyFile copies the file at src to a new file at dst and fsyncs it
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = syncFile(out)
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
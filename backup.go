@@ -0,0 +1,70 @@
+package scratchdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Backup writes a copy of the database to a new file at path, which must not
+// exist. The copy holds the committed pages as of the start of the backup:
+// statements changing the database wait for it, and the changes of an open
+// transaction are left out. The copy has no log and opens like any database.
+func (db *DB) Backup(path string) error {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	if err := db.pager.backup(path); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	return nil
+}
+
+// backup writes the committed image of every page to a new file at path
+func (p *Pager) backup(path string) error {
+	if _, err := os.Stat(walPath(path)); err == nil {
+		return fmt.Errorf("%s exists, it would be replayed over the copy", walPath(path))
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err := p.copyPages(file); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// copyPages writes the committed pages to file and fsyncs it. The pages are
+// read one at a time, so the flusher may checkpoint in between, which moves
+// them from the log to the database file but doesn't change them.
+func (p *Pager) copyPages(file *os.File) error {
+	page := make([]byte, p.pageSize)
+	for pageNum := uint32(0); pageNum < p.committedNumPages; pageNum++ {
+		p.mu.Lock()
+		stored, err := p.readStoredPage(pageNum, page)
+		p.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if !stored {
+			return fmt.Errorf("page %d is missing", pageNum)
+		}
+		if !validPageChecksum(page) {
+			return fmt.Errorf("%w: page %d", ErrChecksum, pageNum)
+		}
+		if _, err := file.WriteAt(page, int64(pageNum)*int64(p.pageSize)); err != nil {
+			return fmt.Errorf("write page %d: %w", pageNum, err)
+		}
+	}
+	if err := syncFile(file); err != nil {
+		return fmt.Errorf("sync backup: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,1055 @@
+package scratchdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type NodeType uint8
+
+const (
+	NodeInternal NodeType = iota
+	NodeLeaf
+)
+
+// invalidPageNum marks an internal node without a right child, it only exists while splitting
+const invalidPageNum = ^uint32(0)
+
+// common node header layout. Keys are compared as bytes, every key of a tree
+// has the same size, kept in each node so the node can find its cells.
+const (
+	NodeTypeSize         uint32 = 1
+	NodeTypeOffset       uint32 = 0
+	IsRootSize           uint32 = 1
+	IsRootOffset                = NodeTypeSize
+	ParentPointerSize    uint32 = 4
+	ParentPointerOffset         = IsRootOffset + IsRootSize
+	KeySizeSize          uint32 = 2
+	KeySizeOffset               = ParentPointerOffset + ParentPointerSize
+	CommonNodeHeaderSize        = NodeTypeSize + IsRootSize + ParentPointerSize + KeySizeSize
+)
+
+// TableKeySize is the size of the keys of a table, the primary key in 8 bytes
+// big endian so the keys sort like the numbers
+const TableKeySize uint32 = 8
+
+// A leaf is a slotted page. The header is followed by an array of cell
+// pointers in key order, each the offset of its cell within the page. The
+// cells are written from the end of the page towards the pointers, the cell
+// content starts at the lowest cell. A cell is a key, the size of its record
+// and the record, the serialized row.
+//
+// A deleted cell leaves a hole in the cell content, its bytes are counted as
+// fragmented. When a cell doesn't fit between the pointers and the cell
+// content but fits with the fragmented bytes, the cells are compacted to the
+// end of the page first.
+
+// leaf node header layout
+const (
+	LeafNodeNumCellsSize   uint32 = 4
+	LeafNodeNumCellsOffset        = CommonNodeHeaderSize
+	LeafNodeNextLeafSize   uint32 = 4
+	LeafNodeNextLeafOffset        = LeafNodeNumCellsOffset + LeafNodeNumCellsSize
+	// the cell content start is the offset of the lowest cell in the page
+	LeafNodeCellContentStartSize   uint32 = 4
+	LeafNodeCellContentStartOffset        = LeafNodeNextLeafOffset + LeafNodeNextLeafSize
+	// the fragmented bytes are the holes left by deleted cells in the cell content
+	LeafNodeFragmentedBytesSize   uint32 = 4
+	LeafNodeFragmentedBytesOffset        = LeafNodeCellContentStartOffset + LeafNodeCellContentStartSize
+	LeafNodeHeaderSize                   = LeafNodeFragmentedBytesOffset + LeafNodeFragmentedBytesSize
+)
+
+// leaf node body layout, the pointers are offsets within a page of at most
+// MaxPageSize bytes and records are smaller than a page, so both fit 2 bytes.
+// The record size follows the key.
+const (
+	LeafNodeCellPointerSize uint32 = 2
+	LeafNodeRecordSizeSize  uint32 = 2
+	// LeafNodeCellHeaderSize is the size of a cell of a table without its record
+	LeafNodeCellHeaderSize = TableKeySize + LeafNodeRecordSizeSize
+)
+
+// LeafNodeSpaceForCells returns the space for cells and their pointers in a
+// leaf of a page of pageSize
+func LeafNodeSpaceForCells(pageSize uint32) uint32 {
+	return PageUsableSize(pageSize) - LeafNodeHeaderSize
+}
+
+// LeafNodeMaxRecordSize returns the largest record a leaf of a page of
+// pageSize holds. A leaf holds at least two of them, so a full leaf and a new
+// cell can always be split in two leaves.
+func LeafNodeMaxRecordSize(pageSize uint32) uint32 {
+	return LeafNodeSpaceForCells(pageSize)/2 - LeafNodeCellPointerSize - LeafNodeCellHeaderSize
+}
+
+// LeafNodeMaxCells returns the number of cells with records of recordSize a
+// leaf of a table holds, leaves with smaller records hold more
+func LeafNodeMaxCells(pageSize, recordSize uint32) uint32 {
+	return LeafNodeSpaceForCells(pageSize) / leafCellSpace(TableKeySize, recordSize)
+}
+
+// leafCellSpace returns the space a cell with a key of keySize and a record
+// of recordSize takes in a leaf, with its pointer
+func leafCellSpace(keySize, recordSize uint32) uint32 {
+	return LeafNodeCellPointerSize + keySize + LeafNodeRecordSizeSize + recordSize
+}
+
+// internal node header layout
+const (
+	InternalNodeNumKeysSize      uint32 = 4
+	InternalNodeNumKeysOffset           = CommonNodeHeaderSize
+	InternalNodeRightChildSize   uint32 = 4
+	InternalNodeRightChildOffset        = InternalNodeNumKeysOffset + InternalNodeNumKeysSize
+	InternalNodeHeaderSize              = CommonNodeHeaderSize + InternalNodeNumKeysSize + InternalNodeRightChildSize
+)
+
+// internal node body layout, each cell is a child page number followed by
+// the max key of that child
+const (
+	InternalNodeChildSize uint32 = 4
+	// InternalNodeCellSize is the size of a cell of a table
+	InternalNodeCellSize = InternalNodeChildSize + TableKeySize
+)
+
+// InternalNodeMaxKeys returns the number of keys of keySize an internal node
+// of a page of pageSize holds
+func InternalNodeMaxKeys(pageSize, keySize uint32) uint32 {
+	return (PageUsableSize(pageSize) - InternalNodeHeaderSize) / (InternalNodeChildSize + keySize)
+}
+
+// node is a page interpreted as a B+tree node, without the checksum trailer
+type node []byte
+
+// pageSize returns the size of the page holding the node
+func (n node) pageSize() uint32 {
+	return uint32(len(n)) + PageChecksumSize
+}
+
+func (n node) nodeType() NodeType {
+	return NodeType(n[NodeTypeOffset])
+}
+
+func (n node) setNodeType(nodeType NodeType) {
+	n[NodeTypeOffset] = byte(nodeType)
+}
+
+func (n node) isRoot() bool {
+	return n[IsRootOffset] == 1
+}
+
+func (n node) setRoot(isRoot bool) {
+	n[IsRootOffset] = 0
+	if isRoot {
+		n[IsRootOffset] = 1
+	}
+}
+
+func (n node) parent() uint32 {
+	return binary.BigEndian.Uint32(n[ParentPointerOffset:])
+}
+
+func (n node) setParent(pageNum uint32) {
+	binary.BigEndian.PutUint32(n[ParentPointerOffset:], pageNum)
+}
+
+func (n node) keySize() uint32 {
+	return uint32(binary.BigEndian.Uint16(n[KeySizeOffset:]))
+}
+
+func (n node) setKeySize(keySize uint32) {
+	binary.BigEndian.PutUint16(n[KeySizeOffset:], uint16(keySize))
+}
+
+func (n node) leafNumCells() uint32 {
+	return binary.BigEndian.Uint32(n[LeafNodeNumCellsOffset:])
+}
+
+func (n node) setLeafNumCells(numCells uint32) {
+	binary.BigEndian.PutUint32(n[LeafNodeNumCellsOffset:], numCells)
+}
+
+// leafNextLeaf returns the page number of the right sibling, 0 means there is none
+func (n node) leafNextLeaf() uint32 {
+	return binary.BigEndian.Uint32(n[LeafNodeNextLeafOffset:])
+}
+
+func (n node) setLeafNextLeaf(pageNum uint32) {
+	binary.BigEndian.PutUint32(n[LeafNodeNextLeafOffset:], pageNum)
+}
+
+func (n node) leafCellContentStart() uint32 {
+	return binary.BigEndian.Uint32(n[LeafNodeCellContentStartOffset:])
+}
+
+func (n node) setLeafCellContentStart(offset uint32) {
+	binary.BigEndian.PutUint32(n[LeafNodeCellContentStartOffset:], offset)
+}
+
+func (n node) leafFragmentedBytes() uint32 {
+	return binary.BigEndian.Uint32(n[LeafNodeFragmentedBytesOffset:])
+}
+
+func (n node) setLeafFragmentedBytes(size uint32) {
+	binary.BigEndian.PutUint32(n[LeafNodeFragmentedBytesOffset:], size)
+}
+
+func (n node) internalMaxKeys() uint32 {
+	return InternalNodeMaxKeys(n.pageSize(), n.keySize())
+}
+
+// leafCellPointer returns the offset of the cell within the page
+func (n node) leafCellPointer(cellNum uint32) uint32 {
+	return uint32(binary.BigEndian.Uint16(n[LeafNodeHeaderSize+cellNum*LeafNodeCellPointerSize:]))
+}
+
+func (n node) setLeafCellPointer(cellNum uint32, offset uint32) {
+	binary.BigEndian.PutUint16(n[LeafNodeHeaderSize+cellNum*LeafNodeCellPointerSize:], uint16(offset))
+}
+
+// leafCellPointersEnd returns the offset past the cell pointers
+func (n node) leafCellPointersEnd() uint32 {
+	return LeafNodeHeaderSize + n.leafNumCells()*LeafNodeCellPointerSize
+}
+
+// leafCell returns the key, record size and record of the cell
+func (n node) leafCell(cellNum uint32) []byte {
+	offset := n.leafCellPointer(cellNum)
+	keySize := n.keySize()
+	recordSize := uint32(binary.BigEndian.Uint16(n[offset+keySize:]))
+	return n[offset : offset+keySize+LeafNodeRecordSizeSize+recordSize]
+}
+
+// leafKey returns the key of the cell, it is only valid until the leaf changes
+func (n node) leafKey(cellNum uint32) []byte {
+	offset := n.leafCellPointer(cellNum)
+	return n[offset : offset+n.keySize()]
+}
+
+// leafRecord returns the serialized row of the cell
+func (n node) leafRecord(cellNum uint32) []byte {
+	return n.leafCell(cellNum)[n.keySize()+LeafNodeRecordSizeSize:]
+}
+
+// newLeafCell returns a cell with the key and record
+func newLeafCell(key, record []byte) []byte {
+	keySize := uint32(len(key))
+	cell := make([]byte, keySize+LeafNodeRecordSizeSize+uint32(len(record)))
+	copy(cell, key)
+	binary.BigEndian.PutUint16(cell[keySize:], uint16(len(record)))
+	copy(cell[keySize+LeafNodeRecordSizeSize:], record)
+	return cell
+}
+
+// leafFreeSpace returns the space left for cells and their pointers,
+// counting the fragmented bytes compacting the cells would reclaim
+func (n node) leafFreeSpace() uint32 {
+	return n.leafCellContentStart() - n.leafCellPointersEnd() + n.leafFragmentedBytes()
+}
+
+// leafInsertCell inserts a cell with the key and record at cellNum, the leaf
+// must have the space for it. The cells are compacted first when the free
+// space between the pointers and the cell content is too small.
+func (n node) leafInsertCell(cellNum uint32, key []byte, record []byte) {
+	cell := newLeafCell(key, record)
+	cellSize := uint32(len(cell))
+	if n.leafCellContentStart()-n.leafCellPointersEnd() < cellSize+LeafNodeCellPointerSize {
+		n.leafCompact()
+	}
+
+	offset := n.leafCellContentStart() - cellSize
+	copy(n[offset:], cell)
+	n.setLeafCellContentStart(offset)
+
+	// make room for the new pointer
+	numCells := n.leafNumCells()
+	pointers := n[LeafNodeHeaderSize:]
+	copy(pointers[(cellNum+1)*LeafNodeCellPointerSize:(numCells+1)*LeafNodeCellPointerSize], pointers[cellNum*LeafNodeCellPointerSize:numCells*LeafNodeCellPointerSize])
+	n.setLeafNumCells(numCells + 1)
+	n.setLeafCellPointer(cellNum, offset)
+}
+
+// leafRemoveCell removes the cell and its pointer, its bytes become
+// fragmented unless it is the lowest cell
+func (n node) leafRemoveCell(cellNum uint32) {
+	offset := n.leafCellPointer(cellNum)
+	cellSize := uint32(len(n.leafCell(cellNum)))
+	if offset == n.leafCellContentStart() {
+		n.setLeafCellContentStart(offset + cellSize)
+	} else {
+		n.setLeafFragmentedBytes(n.leafFragmentedBytes() + cellSize)
+	}
+
+	numCells := n.leafNumCells()
+	pointers := n[LeafNodeHeaderSize:]
+	copy(pointers[cellNum*LeafNodeCellPointerSize:], pointers[(cellNum+1)*LeafNodeCellPointerSize:numCells*LeafNodeCellPointerSize])
+	n.setLeafNumCells(numCells - 1)
+}
+
+// leafCells returns copies of the cells of the leaf in key order
+func (n node) leafCells() [][]byte {
+	numCells := n.leafNumCells()
+	cells := make([][]byte, numCells)
+	for i := uint32(0); i < numCells; i++ {
+		cells[i] = append([]byte{}, n.leafCell(i)...)
+	}
+	return cells
+}
+
+// leafSetCells replaces the cells of the leaf, packing them at the end of the page
+func (n node) leafSetCells(cells [][]byte) {
+	offset := uint32(len(n))
+	for i, cell := range cells {
+		offset -= uint32(len(cell))
+		copy(n[offset:], cell)
+		n.setLeafCellPointer(uint32(i), offset)
+	}
+	n.setLeafNumCells(uint32(len(cells)))
+	n.setLeafCellContentStart(offset)
+	n.setLeafFragmentedBytes(0)
+}
+
+// leafCompact moves the cells to the end of the page, turning the
+// fragmented bytes into free space
+func (n node) leafCompact() {
+	n.leafSetCells(n.leafCells())
+}
+
+func (n node) internalNumKeys() uint32 {
+	return binary.BigEndian.Uint32(n[InternalNodeNumKeysOffset:])
+}
+
+func (n node) setInternalNumKeys(numKeys uint32) {
+	binary.BigEndian.PutUint32(n[InternalNodeNumKeysOffset:], numKeys)
+}
+
+func (n node) internalRightChild() uint32 {
+	return binary.BigEndian.Uint32(n[InternalNodeRightChildOffset:])
+}
+
+func (n node) setInternalRightChild(pageNum uint32) {
+	binary.BigEndian.PutUint32(n[InternalNodeRightChildOffset:], pageNum)
+}
+
+func (n node) internalCell(cellNum uint32) []byte {
+	cellSize := InternalNodeChildSize + n.keySize()
+	offset := InternalNodeHeaderSize + cellNum*cellSize
+	return n[offset : offset+cellSize]
+}
+
+// internalChild returns the page number of the child, childNum == numKeys is the right child
+func (n node) internalChild(childNum uint32) uint32 {
+	numKeys := n.internalNumKeys()
+	if childNum > numKeys {
+		panic(fmt.Sprintf("tried to access child_num %d > num_keys %d", childNum, numKeys))
+	}
+	if childNum == numKeys {
+		return n.internalRightChild()
+	}
+	return binary.BigEndian.Uint32(n.internalCell(childNum))
+}
+
+func (n node) setInternalChild(childNum uint32, pageNum uint32) {
+	if childNum == n.internalNumKeys() {
+		n.setInternalRightChild(pageNum)
+		return
+	}
+	binary.BigEndian.PutUint32(n.internalCell(childNum), pageNum)
+}
+
+// internalKey returns the key of the cell, it is only valid until the node changes
+func (n node) internalKey(keyNum uint32) []byte {
+	return n.internalCell(keyNum)[InternalNodeChildSize:]
+}
+
+func (n node) setInternalKey(keyNum uint32, key []byte) {
+	copy(n.internalCell(keyNum)[InternalNodeChildSize:], key)
+}
+
+func initializeLeafNode(n node, keySize uint32) {
+	n.setNodeType(NodeLeaf)
+	n.setRoot(false)
+	n.setKeySize(keySize)
+	n.setLeafNumCells(0)
+	n.setLeafNextLeaf(0)
+	n.setLeafCellContentStart(uint32(len(n)))
+	n.setLeafFragmentedBytes(0)
+}
+
+func initializeInternalNode(n node, keySize uint32) {
+	n.setNodeType(NodeInternal)
+	n.setRoot(false)
+	n.setKeySize(keySize)
+	n.setInternalNumKeys(0)
+	// 0 is a valid page number, so it can't be used as the empty right child
+	n.setInternalRightChild(invalidPageNum)
+}
+
+// getNodeMaxKey returns a copy of the largest key stored under the node, nil
+// for an empty leaf
+func getNodeMaxKey(pager *Pager, n node) ([]byte, error) {
+	if n.nodeType() == NodeLeaf {
+		numCells := n.leafNumCells()
+		if numCells == 0 {
+			return nil, nil
+		}
+		return append([]byte(nil), n.leafKey(numCells-1)...), nil
+	}
+
+	rightChild, err := pager.getNode(n.internalRightChild())
+	if err != nil {
+		return nil, err
+	}
+	return getNodeMaxKey(pager, rightChild)
+}
+
+// leafNodeFind returns the position of key in the leaf, or the position
+// where it should be inserted
+func leafNodeFind(table *Table, pageNum uint32, key []byte) (*Cursor, error) {
+	n, err := table.pager.getNode(pageNum)
+	if err != nil {
+		return nil, err
+	}
+
+	// binary search
+	minIndex, onePastMaxIndex := uint32(0), n.leafNumCells()
+	for minIndex != onePastMaxIndex {
+		index := (minIndex + onePastMaxIndex) / 2
+		cmp := bytes.Compare(key, n.leafKey(index))
+		if cmp == 0 {
+			return &Cursor{table: table, pageNum: pageNum, cellNum: index}, nil
+		}
+		if cmp < 0 {
+			onePastMaxIndex = index
+		} else {
+			minIndex = index + 1
+		}
+	}
+
+	return &Cursor{table: table, pageNum: pageNum, cellNum: minIndex}, nil
+}
+
+// internalNodeFindChild returns the index of the child which should contain the key
+func internalNodeFindChild(n node, key []byte) uint32 {
+	// binary search
+	minIndex, maxIndex := uint32(0), n.internalNumKeys() // there is one more child than key
+	for minIndex != maxIndex {
+		index := (minIndex + maxIndex) / 2
+		keyToRight := n.internalKey(index)
+		if bytes.Compare(keyToRight, key) >= 0 {
+			maxIndex = index
+		} else {
+			minIndex = index + 1
+		}
+	}
+	return minIndex
+}
+
+func internalNodeFind(table *Table, pageNum uint32, key []byte) (*Cursor, error) {
+	n, err := table.pager.getNode(pageNum)
+	if err != nil {
+		return nil, err
+	}
+
+	childNum := n.internalChild(internalNodeFindChild(n, key))
+	child, err := table.pager.getNode(childNum)
+	if err != nil {
+		return nil, err
+	}
+
+	switch child.nodeType() {
+	case NodeLeaf:
+		return leafNodeFind(table, childNum, key)
+	default:
+		return internalNodeFind(table, childNum, key)
+	}
+}
+
+// leafNodeInsert inserts the row with the key at the cursor, the leaf is
+// split when the cell doesn't fit
+func leafNodeInsert(c *Cursor, key []byte, row Row) error {
+	n, err := c.table.pager.getDirtyNode(c.pageNum)
+	if err != nil {
+		return err
+	}
+
+	record := serializeRow(&c.table.schema, row)
+	if n.leafFreeSpace() < leafCellSpace(n.keySize(), uint32(len(record))) {
+		return leafNodeSplitAndInsert(c, key, record)
+	}
+	n.leafInsertCell(c.cellNum, key, record)
+	return nil
+}
+
+// leafNodeReplace replaces the row of the cell the cursor points to, keeping
+// its key. The leaf is split when the new row doesn't fit.
+func leafNodeReplace(c *Cursor, row Row) error {
+	n, err := c.table.pager.getDirtyNode(c.pageNum)
+	if err != nil {
+		return err
+	}
+	key := append([]byte(nil), n.leafKey(c.cellNum)...)
+	n.leafRemoveCell(c.cellNum)
+	return leafNodeInsert(c, key, row)
+}
+
+// leafNodeSplitAndInsert creates a new node and moves the upper cells over,
+// so both nodes hold about half the bytes, or only the new cell when it is
+// appended to the rightmost leaf. The new cell is inserted in one of
+// the two nodes and the parent is updated or a new root is created.
+func leafNodeSplitAndInsert(c *Cursor, key []byte, record []byte) error {
+	pager := c.table.pager
+	if err := c.table.checkCapacity(); err != nil {
+		return err
+	}
+
+	oldNode, err := pager.getDirtyNode(c.pageNum)
+	if err != nil {
+		return err
+	}
+	oldMax, err := getNodeMaxKey(pager, oldNode)
+	if err != nil {
+		return err
+	}
+
+	newPageNum, err := pager.allocatePage()
+	if err != nil {
+		return err
+	}
+	newNode, err := pager.getDirtyNode(newPageNum)
+	if err != nil {
+		return err
+	}
+	initializeLeafNode(newNode, oldNode.keySize())
+	newNode.setParent(oldNode.parent())
+	newNode.setLeafNextLeaf(oldNode.leafNextLeaf())
+	oldNode.setLeafNextLeaf(newPageNum)
+
+	// all existing cells plus the new cell are divided between the old (left)
+	// and new (right) nodes
+	cells := oldNode.leafCells()
+	cells = append(cells[:c.cellNum], append([][]byte{newLeafCell(key, record)}, cells[c.cellNum:]...)...)
+
+	split, ok := leafSplitPoint(cells, LeafNodeSpaceForCells(oldNode.pageSize()))
+	if !ok {
+		return fmt.Errorf("leaf %d can't be split", c.pageNum)
+	}
+	// appending to the rightmost leaf, like sequential keys or a vacuum do,
+	// leaves it full and starts the new leaf with only the new cell
+	if int(c.cellNum) == len(cells)-1 && newNode.leafNextLeaf() == 0 {
+		split = len(cells) - 1
+	}
+	oldNode.leafSetCells(cells[:split])
+	newNode.leafSetCells(cells[split:])
+
+	if oldNode.isRoot() {
+		return createNewRoot(c.table, newPageNum)
+	}
+
+	parentPageNum := oldNode.parent()
+	newMax, err := getNodeMaxKey(pager, oldNode)
+	if err != nil {
+		return err
+	}
+	parent, err := pager.getDirtyNode(parentPageNum)
+	if err != nil {
+		return err
+	}
+	updateInternalNodeKey(parent, oldMax, newMax)
+	return internalNodeInsert(c.table, parentPageNum, newPageNum)
+}
+
+// createNewRoot handles splitting the root. The old root is copied to a new
+// page and becomes the left child, the root page is re-initialized as an
+// internal node pointing to the two children, so the root stays at its page.
+func createNewRoot(table *Table, rightChildPageNum uint32) error {
+	pager := table.pager
+	root, err := pager.getDirtyNode(table.rootPageNum)
+	if err != nil {
+		return err
+	}
+	rightChild, err := pager.getDirtyNode(rightChildPageNum)
+	if err != nil {
+		return err
+	}
+	leftChildPageNum, err := pager.allocatePage()
+	if err != nil {
+		return err
+	}
+	leftChild, err := pager.getDirtyNode(leftChildPageNum)
+	if err != nil {
+		return err
+	}
+
+	if root.nodeType() == NodeInternal {
+		initializeInternalNode(rightChild, root.keySize())
+		initializeInternalNode(leftChild, root.keySize())
+	}
+
+	// left child has data copied from old root
+	copy(leftChild, root)
+	leftChild.setRoot(false)
+
+	if leftChild.nodeType() == NodeInternal {
+		for i := uint32(0); i <= leftChild.internalNumKeys(); i++ {
+			child, err := pager.getDirtyNode(leftChild.internalChild(i))
+			if err != nil {
+				return err
+			}
+			child.setParent(leftChildPageNum)
+		}
+	}
+
+	// root node is a new internal node with one key and two children
+	leftChildMaxKey, err := getNodeMaxKey(pager, leftChild)
+	if err != nil {
+		return err
+	}
+	initializeInternalNode(root, root.keySize())
+	root.setRoot(true)
+	root.setInternalNumKeys(1)
+	root.setInternalChild(0, leftChildPageNum)
+	root.setInternalKey(0, leftChildMaxKey)
+	root.setInternalRightChild(rightChildPageNum)
+	leftChild.setParent(table.rootPageNum)
+	rightChild.setParent(table.rootPageNum)
+	return nil
+}
+
+func updateInternalNodeKey(n node, oldKey []byte, newKey []byte) {
+	oldChildIndex := internalNodeFindChild(n, oldKey)
+	if oldChildIndex < n.internalNumKeys() {
+		n.setInternalKey(oldChildIndex, newKey)
+	}
+}
+
+// internalNodeInsert adds a new child/key pair to the parent that corresponds to child
+func internalNodeInsert(table *Table, parentPageNum uint32, childPageNum uint32) error {
+	pager := table.pager
+	parent, err := pager.getDirtyNode(parentPageNum)
+	if err != nil {
+		return err
+	}
+	child, err := pager.getNode(childPageNum)
+	if err != nil {
+		return err
+	}
+	childMaxKey, err := getNodeMaxKey(pager, child)
+	if err != nil {
+		return err
+	}
+	index := internalNodeFindChild(parent, childMaxKey)
+
+	originalNumKeys := parent.internalNumKeys()
+	if originalNumKeys >= parent.internalMaxKeys() {
+		return internalNodeSplitAndInsert(table, parentPageNum, childPageNum)
+	}
+
+	rightChildPageNum := parent.internalRightChild()
+	// an internal node with a right child of invalidPageNum is empty
+	if rightChildPageNum == invalidPageNum {
+		parent.setInternalRightChild(childPageNum)
+		return nil
+	}
+
+	rightChild, err := pager.getNode(rightChildPageNum)
+	if err != nil {
+		return err
+	}
+	rightChildMaxKey, err := getNodeMaxKey(pager, rightChild)
+	if err != nil {
+		return err
+	}
+
+	// if we are already at the max number of cells for a node, we cannot
+	// increment before splitting, incrementing without inserting a new
+	// key/child pair and immediately calling internalNodeSplitAndInsert has
+	// the effect of creating a new key at (max_cells + 1) with an
+	// uninitialized value
+	parent.setInternalNumKeys(originalNumKeys + 1)
+
+	if bytes.Compare(childMaxKey, rightChildMaxKey) > 0 {
+		// replace right child
+		parent.setInternalChild(originalNumKeys, rightChildPageNum)
+		parent.setInternalKey(originalNumKeys, rightChildMaxKey)
+		parent.setInternalRightChild(childPageNum)
+		return nil
+	}
+
+	// make room for the new cell
+	for i := originalNumKeys; i > index; i-- {
+		copy(parent.internalCell(i), parent.internalCell(i-1))
+	}
+	parent.setInternalChild(index, childPageNum)
+	parent.setInternalKey(index, childMaxKey)
+	return nil
+}
+
+// internalNodeSplitAndInsert splits a full internal node in two, moving the
+// upper half of its children to a new node, and inserts child into the half
+// that should contain it
+func internalNodeSplitAndInsert(table *Table, parentPageNum uint32, childPageNum uint32) error {
+	pager := table.pager
+	oldPageNum := parentPageNum
+	oldNode, err := pager.getDirtyNode(parentPageNum)
+	if err != nil {
+		return err
+	}
+	oldMax, err := getNodeMaxKey(pager, oldNode)
+	if err != nil {
+		return err
+	}
+
+	child, err := pager.getDirtyNode(childPageNum)
+	if err != nil {
+		return err
+	}
+	childMax, err := getNodeMaxKey(pager, child)
+	if err != nil {
+		return err
+	}
+
+	newPageNum, err := pager.allocatePage()
+	if err != nil {
+		return err
+	}
+
+	// declaring our two nodes, when splitting the root the old node moves to a new page
+	var parent, newNode node
+	splittingRoot := oldNode.isRoot()
+	if splittingRoot {
+		if err := createNewRoot(table, newPageNum); err != nil {
+			return err
+		}
+		parent, err = pager.getDirtyNode(table.rootPageNum)
+		if err != nil {
+			return err
+		}
+		// the old node is now the left child of the new root
+		oldPageNum = parent.internalChild(0)
+		oldNode, err = pager.getDirtyNode(oldPageNum)
+		if err != nil {
+			return err
+		}
+	} else {
+		parent, err = pager.getDirtyNode(oldNode.parent())
+		if err != nil {
+			return err
+		}
+		newNode, err = pager.getDirtyNode(newPageNum)
+		if err != nil {
+			return err
+		}
+		initializeInternalNode(newNode, oldNode.keySize())
+	}
+
+	// first put the right child into the new node and set the right child of the old node to invalid
+	curPageNum := oldNode.internalRightChild()
+	cur, err := pager.getDirtyNode(curPageNum)
+	if err != nil {
+		return err
+	}
+	if err := internalNodeInsert(table, newPageNum, curPageNum); err != nil {
+		return err
+	}
+	cur.setParent(newPageNum)
+	oldNode.setInternalRightChild(invalidPageNum)
+
+	// for each key until you get to the middle key, move the key and the child to the new node
+	maxKeys := oldNode.internalMaxKeys()
+	for i := maxKeys - 1; i > maxKeys/2; i-- {
+		curPageNum = oldNode.internalChild(i)
+		cur, err = pager.getDirtyNode(curPageNum)
+		if err != nil {
+			return err
+		}
+		if err := internalNodeInsert(table, newPageNum, curPageNum); err != nil {
+			return err
+		}
+		cur.setParent(newPageNum)
+		oldNode.setInternalNumKeys(oldNode.internalNumKeys() - 1)
+	}
+
+	// set child before middle key, which is now the highest key, to be node's
+	// right child, and decrement number of keys
+	oldNumKeys := oldNode.internalNumKeys()
+	oldNode.setInternalRightChild(oldNode.internalChild(oldNumKeys - 1))
+	oldNode.setInternalNumKeys(oldNumKeys - 1)
+
+	// determine which of the two nodes after the split should contain the
+	// child to be inserted, and insert the child
+	maxAfterSplit, err := getNodeMaxKey(pager, oldNode)
+	if err != nil {
+		return err
+	}
+	destinationPageNum := newPageNum
+	if bytes.Compare(childMax, maxAfterSplit) < 0 {
+		destinationPageNum = oldPageNum
+	}
+	if err := internalNodeInsert(table, destinationPageNum, childPageNum); err != nil {
+		return err
+	}
+	child.setParent(destinationPageNum)
+
+	newOldMax, err := getNodeMaxKey(pager, oldNode)
+	if err != nil {
+		return err
+	}
+	updateInternalNodeKey(parent, oldMax, newOldMax)
+
+	if !splittingRoot {
+		// a split of the parent moves the new node under its own parent
+		newNode.setParent(oldNode.parent())
+		if err := internalNodeInsert(table, oldNode.parent(), newPageNum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// leafNodeDelete removes the cell the cursor points to. A leaf left without
+// cells is unlinked from the tree and its page freed, so only the root can be
+// an empty leaf.
+func leafNodeDelete(c *Cursor) error {
+	pager := c.table.pager
+	n, err := pager.getDirtyNode(c.pageNum)
+	if err != nil {
+		return err
+	}
+
+	numCells := n.leafNumCells()
+	n.leafRemoveCell(c.cellNum)
+	if numCells > 1 || n.isRoot() {
+		return nil
+	}
+
+	prevPageNum, ok, err := previousLeaf(c.table, c.pageNum)
+	if err != nil {
+		return err
+	}
+	if ok {
+		prev, err := pager.getDirtyNode(prevPageNum)
+		if err != nil {
+			return err
+		}
+		prev.setLeafNextLeaf(n.leafNextLeaf())
+	}
+	if err := internalNodeRemove(c.table, n.parent(), c.pageNum); err != nil {
+		return err
+	}
+	return pager.freePage(c.pageNum)
+}
+
+// leafSplitPoint returns the number of cells going to the left leaf of a
+// split, so the bytes of the cells are divided as evenly as possible with
+// both halves fitting in space. ok is false when no split fits.
+func leafSplitPoint(cells [][]byte, space uint32) (split int, ok bool) {
+	total := uint32(0)
+	for _, cell := range cells {
+		total += LeafNodeCellPointerSize + uint32(len(cell))
+	}
+
+	best := total
+	left := uint32(0)
+	for i := 1; i < len(cells); i++ {
+		left += LeafNodeCellPointerSize + uint32(len(cells[i-1]))
+		right := total - left
+		if left > space || right > space {
+			continue
+		}
+		diff := left - right
+		if right > left {
+			diff = right - left
+		}
+		if diff < best {
+			best, split, ok = diff, i, true
+		}
+	}
+	return split, ok
+}
+
+// childIndex returns the position of the child within the internal node,
+// numKeys is the right child
+func childIndex(n node, childPageNum uint32) uint32 {
+	numKeys := n.internalNumKeys()
+	for i := uint32(0); i < numKeys; i++ {
+		if n.internalChild(i) == childPageNum {
+			return i
+		}
+	}
+	return numKeys
+}
+
+// previousLeaf returns the page number of the leaf left of the node, ok is
+// false for the leftmost leaf
+func previousLeaf(table *Table, pageNum uint32) (prevPageNum uint32, ok bool, err error) {
+	pager := table.pager
+	for {
+		n, err := pager.getNode(pageNum)
+		if err != nil {
+			return 0, false, err
+		}
+		if n.isRoot() {
+			return 0, false, nil
+		}
+
+		parent, err := pager.getNode(n.parent())
+		if err != nil {
+			return 0, false, err
+		}
+		index := childIndex(parent, pageNum)
+		if index == 0 {
+			// leftmost child, look further up the tree
+			pageNum = n.parent()
+			continue
+		}
+
+		// the rightmost leaf of the left sibling
+		prevPageNum = parent.internalChild(index - 1)
+		for {
+			prev, err := pager.getNode(prevPageNum)
+			if err != nil {
+				return 0, false, err
+			}
+			if prev.nodeType() == NodeLeaf {
+				return prevPageNum, true, nil
+			}
+			prevPageNum = prev.internalRightChild()
+		}
+	}
+}
+
+// internalNodeRemove removes the child from the internal node. A node left
+// without children is removed from its own parent and freed, and a root left
+// with a single child is replaced by that child. The removed child is freed
+// by the caller.
+func internalNodeRemove(table *Table, parentPageNum uint32, childPageNum uint32) error {
+	pager := table.pager
+	parent, err := pager.getDirtyNode(parentPageNum)
+	if err != nil {
+		return err
+	}
+
+	numKeys := parent.internalNumKeys()
+	if numKeys == 0 {
+		// the child was the only one left
+		if parent.isRoot() {
+			initializeLeafNode(parent, parent.keySize())
+			parent.setRoot(true)
+			return nil
+		}
+		if err := internalNodeRemove(table, parent.parent(), parentPageNum); err != nil {
+			return err
+		}
+		return pager.freePage(parentPageNum)
+	}
+
+	// keys are upper bounds of their child, so the remaining keys stay valid
+	// even when the removed child held the largest keys
+	index := childIndex(parent, childPageNum)
+	if index == numKeys {
+		parent.setInternalRightChild(parent.internalChild(numKeys - 1))
+	} else {
+		for i := index; i+1 < numKeys; i++ {
+			copy(parent.internalCell(i), parent.internalCell(i+1))
+		}
+	}
+	parent.setInternalNumKeys(numKeys - 1)
+
+	if parent.isRoot() && numKeys == 1 {
+		return collapseRoot(table)
+	}
+	return nil
+}
+
+// collapseRoot copies the only child of the root into the root page and
+// frees the child's page, making the tree one level shorter
+func collapseRoot(table *Table) error {
+	pager := table.pager
+	root, err := pager.getDirtyNode(table.rootPageNum)
+	if err != nil {
+		return err
+	}
+	childPageNum := root.internalRightChild()
+	child, err := pager.getNode(childPageNum)
+	if err != nil {
+		return err
+	}
+
+	copy(root, child)
+	root.setRoot(true)
+	if err := pager.freePage(childPageNum); err != nil {
+		return err
+	}
+	if root.nodeType() == NodeLeaf {
+		return nil
+	}
+
+	for i := uint32(0); i <= root.internalNumKeys(); i++ {
+		grandchild, err := pager.getDirtyNode(root.internalChild(i))
+		if err != nil {
+			return err
+		}
+		grandchild.setParent(table.rootPageNum)
+	}
+	return nil
+}
+
+// PrintTree writes the nodes of the table's B+tree indented by depth, with
+// their type, number of keys and keys. An empty name is the default table.
+func (db *DB) PrintTree(wr io.Writer, tableName string) error {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	var table *Table
+	var err error
+	if tableName == "" {
+		table, err = defaultTable(db.pager, false)
+	} else {
+		table, err = findTable(db.pager, tableName)
+	}
+	if err != nil || table == nil {
+		return err
+	}
+	return printTree(wr, db.pager, table.rootPageNum, 0)
+}
+
+func printTree(wr io.Writer, pager *Pager, pageNum uint32, depth int) error {
+	n, err := pager.getNode(pageNum)
+	if err != nil {
+		return err
+	}
+	indent := strings.Repeat("  ", depth)
+
+	if n.nodeType() == NodeLeaf {
+		numCells := n.leafNumCells()
+		fmt.Fprintf(wr, "%s- leaf (size %d)\n", indent, numCells)
+		for i := uint32(0); i < numCells; i++ {
+			fmt.Fprintf(wr, "%s  - %s\n", indent, formatKey(n.leafKey(i)))
+		}
+		return nil
+	}
+
+	numKeys := n.internalNumKeys()
+	fmt.Fprintf(wr, "%s- internal (size %d)\n", indent, numKeys)
+	for i := uint32(0); i < numKeys; i++ {
+		if err := printTree(wr, pager, n.internalChild(i), depth+1); err != nil {
+			return err
+		}
+		fmt.Fprintf(wr, "%s  - key %s\n", indent, formatKey(n.internalKey(i)))
+	}
+	return printTree(wr, pager, n.internalRightChild(), depth+1)
+}
+
+// formatKey returns the key as printTree writes it, the primary key of a
+// table key and the bytes in hex of an index key
+func formatKey(key []byte) string {
+	if uint32(len(key)) == TableKeySize {
+		return strconv.FormatUint(binary.BigEndian.Uint64(key), 10)
+	}
+	return hex.EncodeToString(key)
+}
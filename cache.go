@@ -0,0 +1,63 @@
+package scratchdb
+
+import "container/list"
+
+// DefaultCacheSize is the number of pages cached when Options.CacheSize is not set
+const DefaultCacheSize = 2000
+
+// pageCache is a least recently used cache of pages
+type pageCache struct {
+	capacity int
+	pages    map[uint32]*list.Element
+	// lru orders the pages from the most to the least recently used
+	lru *list.List
+}
+
+type cachedPage struct {
+	pageNum uint32
+	page    []byte
+}
+
+func newPageCache(capacity int) *pageCache {
+	return &pageCache{
+		capacity: capacity,
+		pages:    map[uint32]*list.Element{},
+		lru:      list.New(),
+	}
+}
+
+// get returns the cached page or nil, and marks it as the most recently used
+func (c *pageCache) get(pageNum uint32) []byte {
+	elem, ok := c.pages[pageNum]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*cachedPage).page
+}
+
+func (c *pageCache) put(pageNum uint32, page []byte) {
+	c.pages[pageNum] = c.lru.PushFront(&cachedPage{pageNum: pageNum, page: page})
+}
+
+func (c *pageCache) remove(pageNum uint32) {
+	if elem, ok := c.pages[pageNum]; ok {
+		c.lru.Remove(elem)
+		delete(c.pages, pageNum)
+	}
+}
+
+// evict drops the least recently used pages until the cache is back to its
+// capacity, pinned pages are kept even when that leaves the cache over capacity
+func (c *pageCache) evict(pinned func(pageNum uint32) bool) {
+	elem := c.lru.Back()
+	for len(c.pages) > c.capacity && elem != nil {
+		prev := elem.Prev()
+		pageNum := elem.Value.(*cachedPage).pageNum
+		if !pinned(pageNum) {
+			c.lru.Remove(elem)
+			delete(c.pages, pageNum)
+		}
+		elem = prev
+	}
+}
@@ -0,0 +1,331 @@
+package scratchdb
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// catalogPageNum is the page listing the tables of the database, the catalog
+// follows the file header.
+//
+// The catalog holds the number of tables followed by each table's root page
+// number, name, number of columns and columns. A column is its name, type (1
+// byte), size (4 bytes) and flags (1 byte). The tables are followed by the number of indexes
+// and each index's root page number, name, table name and column name. The
+// statistics of analyze come last: the number of analyzed tables, and for each
+// its name, number of rows (8 bytes), and number of analyzed indexes followed
+// by each index's name and number of distinct values (8 bytes). Names are
+// prefixed by their length in one byte. Catalogs written before statistics
+// end with zeros, which read as no analyzed table.
+const catalogPageNum uint32 = 0
+
+// columnFlagNotNull, columnFlagNotEmpty and columnFlagCheckEmail are set in
+// the flags of a column with the constraint
+const (
+	columnFlagNotNull byte = 1 << iota
+	columnFlagNotEmpty
+	columnFlagCheckEmail
+)
+
+// maxNameLength is the longest table, column or index name the catalog can store
+const maxNameLength = 255
+
+// readCatalog returns the tables of the database with their indexes
+func readCatalog(pager *Pager) ([]*Table, error) {
+	page, err := pager.getPage(catalogPageNum)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &catalogReader{page: page[FileHeaderSize:], ok: true}
+	numTables := r.uint32()
+	var tables []*Table
+	for i := uint32(0); i < numTables && r.ok; i++ {
+		table := &Table{pager: pager}
+		table.rootPageNum = r.uint32()
+		table.schema.Name = r.string()
+		numColumns := r.uint16()
+		for j := uint16(0); j < numColumns && r.ok; j++ {
+			col := Column{Name: r.string()}
+			col.Type = ColumnType(r.uint8())
+			col.Size = r.uint32()
+			flags := r.uint8()
+			col.NotNull = flags&columnFlagNotNull != 0
+			col.NotEmpty = flags&columnFlagNotEmpty != 0
+			col.CheckEmail = flags&columnFlagCheckEmail != 0
+			table.schema.Columns = append(table.schema.Columns, col)
+		}
+		tables = append(tables, table)
+	}
+
+	numIndexes := r.uint32()
+	for i := uint32(0); i < numIndexes && r.ok; i++ {
+		rootPageNum := r.uint32()
+		def := Index{Name: r.string(), Table: r.string(), Column: r.string()}
+		table, column := catalogColumn(tables, def.Table, def.Column)
+		if table == nil {
+			r.ok = false
+			break
+		}
+		table.indexes = append(table.indexes, newIndex(pager, def, &table.schema, column, rootPageNum))
+	}
+
+	numStats := r.uint32()
+	for i := uint32(0); i < numStats && r.ok; i++ {
+		name := r.string()
+		stats := &tableStats{rows: r.uint64()}
+		numIndexStats := r.uint16()
+		distinct := make(map[string]uint64, numIndexStats)
+		for j := uint16(0); j < numIndexStats && r.ok; j++ {
+			indexName := r.string()
+			distinct[indexName] = r.uint64()
+		}
+		for _, table := range tables {
+			if table.schema.Name != name {
+				continue
+			}
+			table.stats = stats
+			for _, idx := range table.indexes {
+				if n, ok := distinct[idx.Name]; ok {
+					idx.stats = &indexStats{distinct: n}
+				}
+			}
+		}
+	}
+	if !r.ok {
+		return nil, fmt.Errorf("catalog page is corrupt")
+	}
+	return tables, nil
+}
+
+// catalogColumn returns the table and position of the indexed column, the
+// table is nil when either does not exist
+func catalogColumn(tables []*Table, tableName, columnName string) (*Table, int) {
+	for _, table := range tables {
+		if table.schema.Name != tableName {
+			continue
+		}
+		if column, ok := table.schema.columnIndex(columnName); ok {
+			return table, column
+		}
+	}
+	return nil, 0
+}
+
+// writeCatalog replaces the catalog with the tables and their indexes
+func writeCatalog(pager *Pager, tables []*Table) error {
+	buf := make([]byte, 4, PageUsableSize(pager.pageSize)-FileHeaderSize)
+	binary.BigEndian.PutUint32(buf, uint32(len(tables)))
+	for _, table := range tables {
+		buf = appendUint32(buf, table.rootPageNum)
+		buf = appendString(buf, table.schema.Name)
+		buf = appendUint16(buf, uint16(len(table.schema.Columns)))
+		for _, col := range table.schema.Columns {
+			buf = appendString(buf, col.Name)
+			buf = append(buf, byte(col.Type))
+			buf = appendUint32(buf, col.Size)
+			var flags byte
+			if col.NotNull {
+				flags |= columnFlagNotNull
+			}
+			if col.NotEmpty {
+				flags |= columnFlagNotEmpty
+			}
+			if col.CheckEmail {
+				flags |= columnFlagCheckEmail
+			}
+			buf = append(buf, flags)
+		}
+	}
+
+	var indexes []*index
+	for _, table := range tables {
+		indexes = append(indexes, table.indexes...)
+	}
+	buf = appendUint32(buf, uint32(len(indexes)))
+	for _, idx := range indexes {
+		buf = appendUint32(buf, idx.tree.rootPageNum)
+		buf = appendString(buf, idx.Name)
+		buf = appendString(buf, idx.Table)
+		buf = appendString(buf, idx.Column)
+	}
+
+	var analyzed []*Table
+	for _, table := range tables {
+		if table.stats != nil {
+			analyzed = append(analyzed, table)
+		}
+	}
+	buf = appendUint32(buf, uint32(len(analyzed)))
+	for _, table := range analyzed {
+		buf = appendString(buf, table.schema.Name)
+		buf = appendUint64(buf, table.stats.rows)
+		var indexes []*index
+		for _, idx := range table.indexes {
+			if idx.stats != nil {
+				indexes = append(indexes, idx)
+			}
+		}
+		buf = appendUint16(buf, uint16(len(indexes)))
+		for _, idx := range indexes {
+			buf = appendString(buf, idx.Name)
+			buf = appendUint64(buf, idx.stats.distinct)
+		}
+	}
+	if len(buf) > int(PageUsableSize(pager.pageSize)-FileHeaderSize) {
+		return fmt.Errorf("catalog page is full")
+	}
+
+	page, err := pager.getDirtyNode(catalogPageNum)
+	if err != nil {
+		return err
+	}
+	catalog := page[FileHeaderSize:]
+	copy(catalog, buf)
+	for i := len(buf); i < len(catalog); i++ {
+		catalog[i] = 0
+	}
+	return nil
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	buf = append(buf, 0, 0)
+	binary.BigEndian.PutUint16(buf[len(buf)-2:], v)
+	return buf
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	buf = append(buf, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(buf[len(buf)-4:], v)
+	return buf
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	buf = append(buf, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(buf[len(buf)-8:], v)
+	return buf
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = append(buf, byte(len(s)))
+	return append(buf, s...)
+}
+
+// catalogReader decodes the catalog page, ok turns false once a read goes
+// past the end of the page
+type catalogReader struct {
+	page   []byte
+	offset int
+	ok     bool
+}
+
+func (r *catalogReader) next(n int) []byte {
+	if !r.ok || r.offset+n > len(r.page) {
+		r.ok = false
+		return make([]byte, n)
+	}
+	b := r.page[r.offset : r.offset+n]
+	r.offset += n
+	return b
+}
+
+func (r *catalogReader) uint8() uint8 {
+	return r.next(1)[0]
+}
+
+func (r *catalogReader) uint16() uint16 {
+	return binary.BigEndian.Uint16(r.next(2))
+}
+
+func (r *catalogReader) uint32() uint32 {
+	return binary.BigEndian.Uint32(r.next(4))
+}
+
+func (r *catalogReader) uint64() uint64 {
+	return binary.BigEndian.Uint64(r.next(8))
+}
+
+func (r *catalogReader) string() string {
+	return string(r.next(int(r.uint8())))
+}
+
+// createTable adds a table with the schema to the catalog and allocates its root page
+func createTable(pager *Pager, schema Schema) (*Table, error) {
+	if err := schema.validate(pager.pageSize); err != nil {
+		return nil, err
+	}
+
+	tables, err := readCatalog(pager)
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range tables {
+		if table.schema.Name == schema.Name {
+			return nil, ErrTableExists
+		}
+	}
+
+	rootPageNum, err := pager.allocatePage()
+	if err != nil {
+		return nil, err
+	}
+	table := &Table{schema: schema, rootPageNum: rootPageNum, pager: pager}
+	root, err := pager.getDirtyNode(table.rootPageNum)
+	if err != nil {
+		return nil, err
+	}
+	initializeLeafNode(root, TableKeySize)
+	root.setRoot(true)
+
+	if err := writeCatalog(pager, append(tables, table)); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+// findTable returns the table with the name, or ErrNoSuchTable
+func findTable(pager *Pager, name string) (*Table, error) {
+	tables, err := readCatalog(pager)
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range tables {
+		if table.schema.Name == name {
+			return table, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNoSuchTable, name)
+}
+
+// defaultTable returns the table statements without a table name run against,
+// the first table created. When there is no table it is created with the
+// default schema if create is true, otherwise it is nil.
+func defaultTable(pager *Pager, create bool) (*Table, error) {
+	tables, err := readCatalog(pager)
+	if err != nil {
+		return nil, err
+	}
+	if len(tables) > 0 {
+		return tables[0], nil
+	}
+	if !create {
+		return nil, nil
+	}
+	return createTable(pager, defaultSchema())
+}
+
+// Tables returns the schemas of the tables in the order they were created
+func (db *DB) Tables() ([]Schema, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	tables, err := readCatalog(db.pager)
+	if err != nil {
+		return nil, err
+	}
+	schemas := make([]Schema, 0, len(tables))
+	for _, table := range tables {
+		schemas = append(schemas, table.schema)
+	}
+	return schemas, nil
+}
@@ -0,0 +1,42 @@
+package scratchdb
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// Every page ends with a trailer holding the crc32 checksum of the rest of
+// the page. It is set when the page is committed and checked when the page
+// is read from the log or the database file, so torn writes and bit rot are
+// reported instead of being read as nodes. Nodes never see the trailer.
+const (
+	PageChecksumSize uint32 = 4
+)
+
+// PageUsableSize returns the space of a page of pageSize left for the node,
+// before the checksum trailer
+func PageUsableSize(pageSize uint32) uint32 {
+	return pageSize - PageChecksumSize
+}
+
+// setPageChecksum writes the checksum of the page to its trailer
+func setPageChecksum(page []byte) {
+	usable := len(page) - int(PageChecksumSize)
+	binary.BigEndian.PutUint32(page[usable:], crc32.ChecksumIEEE(page[:usable]))
+}
+
+// validPageChecksum reports whether the trailer of the page matches its checksum
+func validPageChecksum(page []byte) bool {
+	usable := len(page) - int(PageChecksumSize)
+	return binary.BigEndian.Uint32(page[usable:]) == crc32.ChecksumIEEE(page[:usable])
+}
+
+// Verify reads every page of the database from the log or the file,
+// bypassing the cache, and checks its checksum. It returns the number of
+// pages checked and the numbers of the corrupt ones in order. Changes not
+// committed yet are not checked.
+func (db *DB) Verify() (pages uint32, corrupt []uint32, err error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	return db.pager.verify()
+}
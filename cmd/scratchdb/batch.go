@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/fahmifan/scratchdb"
+)
+
+// runBatch executes the statements of script without prompts, printing the
+// selected rows in the output mode. Statements are separated by newlines or `;`, a line starting
+// with `.` is a meta command. It stops at the first failing statement and
+// returns its error.
+func runBatch(wr io.Writer, script io.Reader, settings *Settings, db *scratchdb.DB, interrupts <-chan os.Signal) error {
+	scanner := bufio.NewScanner(script)
+	scanner.Buffer(nil, 1<<20)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if line[0] == '.' {
+			switch doMetaCommand(wr, line, settings, db, interrupts) {
+			case MetaCommandAbort:
+				return nil
+			case MetaCommandSyntaxError:
+				return fmt.Errorf("line %d: syntax error (%s)", lineNum, line)
+			case MetaCommandUnrecognizedCommand:
+				return fmt.Errorf("line %d: unrecognized command %s", lineNum, unrecognizedMetaCommand(line))
+			}
+			continue
+		}
+
+		for _, in := range splitStatements(line) {
+			in = strings.TrimSpace(in)
+			if in == "" {
+				continue
+			}
+
+			ctx, cancel := statementContext(settings)
+			stop := cancelOnInterrupt(interrupts, cancel)
+			rs, err := db.QueryResult(ctx, in)
+			stop()
+			cancel()
+			if err != nil {
+				return fmt.Errorf("line %d: %w (%s)", lineNum, err, in)
+			}
+			if err := printResult(wr, settings.Mode, rs); err != nil {
+				return err
+			}
+			// keep the output to the rows unless the timings are asked for
+			if settings.Timer {
+				printSummary(wr, settings, rs)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// splitStatements splits line at the `;` outside of quoted strings
+func splitStatements(line string) []string {
+	var stmts []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0 && c == '\\':
+			i++ // skip the escaped character
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ';':
+			stmts = append(stmts, line[start:i])
+			start = i + 1
+		}
+	}
+	return append(stmts, line[start:])
+}
+
+// isTerminal reports whether r is a terminal rather than a pipe, a file or
+// any other reader
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok {
+		return false
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/fahmifan/scratchdb"
+)
+
+// benchResult is what a phase of the benchmark measured: the latency of each
+// of its statements and the bytes of the pages they changed
+type benchResult struct {
+	name      string
+	latencies []time.Duration
+	total     time.Duration
+	written   uint64
+}
+
+// runBench inserts rows in a new db file, then looks them up and scans them,
+// and reports the throughput and latency of each
+func runBench(args []string, wr, errWr io.Writer) error {
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.SetOutput(errWr)
+	rows := fs.Int("rows", 10000, "insert `n` rows, one statement each, in random key order")
+	lookups := fs.Int("lookups", 10000, "select `n` rows by key")
+	scans := fs.Int("scans", 10, "select all the rows `n` times")
+	pageSize := fs.Uint("page-size", 0, fmt.Sprintf("page size of the db file, a power of two from %d to %d (default %d)", scratchdb.MinPageSize, scratchdb.MaxPageSize, scratchdb.DefaultPageSize))
+	fs.Usage = func() {
+		Printfln(fs.Output(), "Usage: scratchdb bench [flags] [dbfile]\n\nMeasures inserts, lookups by key and scans on dbfile, which must not exist\nand is kept afterwards, or on a temporary file.\n\nFlags:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		err := fmt.Errorf("expected at most one db file, got %d arguments", fs.NArg())
+		Printfln(fs.Output(), "%v", err)
+		fs.Usage()
+		return err
+	}
+	if *rows < 1 || *lookups < 0 || *scans < 0 {
+		err := errors.New("--rows must be at least 1, --lookups and --scans at least 0")
+		Printfln(fs.Output(), "%v", err)
+		return err
+	}
+
+	var path, name string
+	if fs.NArg() == 1 {
+		path, name = fs.Arg(0), fs.Arg(0)
+		if _, err := os.Stat(path); err == nil {
+			err := fmt.Errorf("%s exists, bench writes a new db file", path)
+			Printfln(errWr, "Error: %v", err)
+			return err
+		}
+	} else {
+		dir, err := os.MkdirTemp("", "scratchdb-bench")
+		if err != nil {
+			Printfln(errWr, "Error: %v", err)
+			return err
+		}
+		defer os.RemoveAll(dir)
+		path, name = filepath.Join(dir, "bench.db"), "a temporary db file"
+	}
+
+	db, err := scratchdb.OpenWithOptions(path, scratchdb.Options{PageSize: uint32(*pageSize)})
+	if err != nil {
+		Printfln(errWr, "Error: %v", err)
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	results, err := bench(ctx, db, *rows, *lookups, *scans)
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		Printfln(errWr, "Error: %v", err)
+		return err
+	}
+
+	var size int64
+	if stat, err := os.Stat(path); err == nil {
+		size = stat.Size()
+	}
+	Printfln(wr, "%s in %s, %d byte pages, %d bytes once closed", plural(uint64(*rows), "row"), name, db.PageSize(), size)
+	Printfln(wr, "%-8s %8s %12s %12s %12s %14s", "phase", "ops", "ops/sec", "p50", "p99", "bytes written")
+	for _, res := range results {
+		if len(res.latencies) == 0 {
+			continue
+		}
+		sort.Slice(res.latencies, func(i, j int) bool { return res.latencies[i] < res.latencies[j] })
+		opsPerSec := float64(len(res.latencies)) / res.total.Seconds()
+		Printfln(wr, "%-8s %8d %12.1f %12s %12s %14d", res.name, len(res.latencies), opsPerSec,
+			percentile(res.latencies, 50), percentile(res.latencies, 99), res.written)
+	}
+	return nil
+}
+
+// bench runs the phases on db: rows inserts into a new table, with the keys
+// shuffled so the tree splits everywhere, lookups selects of a random key and
+// scans selects of the whole table. The same seed is used every run, so runs
+// are comparable.
+func bench(ctx context.Context, db *scratchdb.DB, rows, lookups, scans int) ([]benchResult, error) {
+	if err := db.ExecContext(ctx, "create table bench (id int, name text(32), email text(255))"); err != nil {
+		return nil, err
+	}
+	random := rand.New(rand.NewSource(1))
+	pageSize := uint64(db.PageSize())
+
+	insert := benchResult{name: "insert"}
+	for _, i := range random.Perm(rows) {
+		key := i + 1
+		sql := fmt.Sprintf("insert into bench %d user%d user%d@example.com", key, key, key)
+		rs, err := timeStatement(ctx, db, sql, &insert)
+		if err != nil {
+			return nil, err
+		}
+		insert.written += rs.PagesWritten * pageSize
+	}
+
+	lookup := benchResult{name: "lookup"}
+	for i := 0; i < lookups; i++ {
+		key := random.Intn(rows) + 1
+		rs, err := timeStatement(ctx, db, fmt.Sprintf("select from bench where id = %d", key), &lookup)
+		if err != nil {
+			return nil, err
+		}
+		if len(rs.Rows) != 1 {
+			return nil, fmt.Errorf("lookup of key %d selected %s", key, plural(uint64(len(rs.Rows)), "row"))
+		}
+	}
+
+	scan := benchResult{name: "scan"}
+	for i := 0; i < scans; i++ {
+		rs, err := timeStatement(ctx, db, "select from bench", &scan)
+		if err != nil {
+			return nil, err
+		}
+		if len(rs.Rows) != rows {
+			return nil, fmt.Errorf("scan selected %s, expected %d", plural(uint64(len(rs.Rows)), "row"), rows)
+		}
+	}
+	return []benchResult{insert, lookup, scan}, nil
+}
+
+// timeStatement runs the statement and adds its latency to res
+func timeStatement(ctx context.Context, db *scratchdb.DB, sql string, res *benchResult) (*scratchdb.ResultSet, error) {
+	start := time.Now()
+	rs, err := db.QueryResult(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("%w (%s)", err, sql)
+	}
+	latency := time.Since(start)
+	res.latencies = append(res.latencies, latency)
+	res.total += latency
+	return rs, nil
+}
+
+// percentile returns the latency p percent of the sorted latencies are at or
+// below
+func percentile(sorted []time.Duration, p int) time.Duration {
+	return sorted[(len(sorted)-1)*p/100]
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/fahmifan/scratchdb"
+)
+
+// OutputMode is how selected rows are printed, changed with .mode
+type OutputMode uint32
+
+const (
+	// OutputModeTable aligns the rows in columns under a header
+	OutputModeTable OutputMode = iota + 1
+	// OutputModeJSON prints a JSON object per row keyed by column name
+	OutputModeJSON
+	// OutputModeCSV prints a header line and a line per row
+	OutputModeCSV
+)
+
+var outputModeNames = map[OutputMode]string{
+	OutputModeTable: "table",
+	OutputModeJSON:  "json",
+	OutputModeCSV:   "csv",
+}
+
+func (m OutputMode) String() string {
+	return outputModeNames[m]
+}
+
+func parseOutputMode(name string) (OutputMode, bool) {
+	for mode, modeName := range outputModeNames {
+		if name == modeName {
+			return mode, true
+		}
+	}
+	return 0, false
+}
+
+// printResult prints the selected rows in the output mode, nothing is printed
+// when no rows were selected
+func printResult(wr io.Writer, mode OutputMode, rs *scratchdb.ResultSet) error {
+	if len(rs.Rows) == 0 {
+		return nil
+	}
+
+	switch mode {
+	case OutputModeJSON:
+		return printJSON(wr, rs)
+	case OutputModeCSV:
+		return printCSV(wr, rs)
+	default:
+		printTable(wr, rs)
+		return nil
+	}
+}
+
+// printSummary prints what the statement did, e.g. "2 rows selected", and
+// how long it took when the timer is on
+func printSummary(wr io.Writer, settings *Settings, rs *scratchdb.ResultSet) {
+	var summary string
+	switch rs.Kind {
+	case scratchdb.StatementKindSelect:
+		summary = plural(uint64(len(rs.Rows)), "row") + " selected"
+	case scratchdb.StatementKindInsert, scratchdb.StatementKindInsertRandom:
+		summary = plural(rs.RowsAffected, "row") + " inserted"
+	case scratchdb.StatementKindUpdate:
+		summary = plural(rs.RowsAffected, "row") + " updated"
+	case scratchdb.StatementKindDelete:
+		summary = plural(rs.RowsAffected, "row") + " deleted"
+	default:
+		summary = "Executed"
+	}
+	if settings.Timer {
+		summary += fmt.Sprintf(" (%s, %s read, %s written)", rs.Duration, plural(rs.PagesRead, "page"), plural(rs.PagesWritten, "page"))
+	}
+	Printfln(wr, "%s", summary)
+}
+
+func plural(n uint64, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// formatValue returns the value as printed in a table, a missing value is
+// NULL, a blob is in hex like it is written in statements and a timestamp in
+// RFC 3339
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return "0x" + hex.EncodeToString(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(value)
+}
+
+// isNumber reports whether the values of the column type are numbers, they
+// are aligned to the right
+func isNumber(t scratchdb.ColumnType) bool {
+	return t == scratchdb.ColumnTypeInt || t == scratchdb.ColumnTypeInteger || t == scratchdb.ColumnTypeReal
+}
+
+// printTable prints the rows in columns as wide as their longest value,
+// numbers are aligned to the right and text to the left
+func printTable(wr io.Writer, rs *scratchdb.ResultSet) {
+	cells := make([][]string, len(rs.Rows))
+	widths := make([]int, len(rs.Columns))
+	for i, col := range rs.Columns {
+		widths[i] = len(col.Name)
+	}
+	for i, row := range rs.Rows {
+		cells[i] = make([]string, len(row))
+		for j, value := range row {
+			cells[i][j] = formatValue(value)
+			if len(cells[i][j]) > widths[j] {
+				widths[j] = len(cells[i][j])
+			}
+		}
+	}
+
+	line := func(values []string, header bool) {
+		fields := make([]string, len(values))
+		for i, value := range values {
+			if !header && isNumber(rs.Columns[i].Type) {
+				fields[i] = fmt.Sprintf("%*s", widths[i], value)
+			} else {
+				fields[i] = fmt.Sprintf("%-*s", widths[i], value)
+			}
+		}
+		Printfln(wr, "%s", strings.TrimRight(strings.Join(fields, " | "), " "))
+	}
+
+	names := make([]string, len(rs.Columns))
+	dashes := make([]string, len(rs.Columns))
+	for i, col := range rs.Columns {
+		names[i] = col.Name
+		dashes[i] = strings.Repeat("-", widths[i])
+	}
+	line(names, true)
+	Printfln(wr, "%s", strings.Join(dashes, "-+-"))
+	for _, row := range cells {
+		line(row, false)
+	}
+}
+
+// printJSON prints a JSON object per line, the keys are in column order
+func printJSON(wr io.Writer, rs *scratchdb.ResultSet) error {
+	w := newJSONWriter(wr, rs.Columns)
+	for _, row := range rs.Rows {
+		if err := w.writeRow(row); err != nil {
+			return err
+		}
+	}
+	return w.flush()
+}
+
+// printCSV prints the column names and the rows as CSV, a missing value is an empty field
+func printCSV(wr io.Writer, rs *scratchdb.ResultSet) error {
+	w, err := newCSVWriter(wr, rs.Columns)
+	if err != nil {
+		return err
+	}
+	for _, row := range rs.Rows {
+		if err := w.writeRow(row); err != nil {
+			return err
+		}
+	}
+	return w.flush()
+}
+
+// newRowWriter returns the writer of the rows in the output mode, json or csv
+func newRowWriter(wr io.Writer, mode OutputMode, columns []scratchdb.Column) (rowWriter, error) {
+	if mode == OutputModeJSON {
+		return newJSONWriter(wr, columns), nil
+	}
+	return newCSVWriter(wr, columns)
+}
+
+// rowWriter writes rows one at a time, so they can be streamed without
+// holding them all
+type rowWriter interface {
+	writeRow(row scratchdb.Row) error
+	// flush writes anything buffered, it must be called after the last row
+	flush() error
+}
+
+// jsonWriter writes a JSON object per row and line, the keys are in column order
+type jsonWriter struct {
+	wr      io.Writer
+	columns []scratchdb.Column
+	buf     bytes.Buffer
+	enc     *json.Encoder
+}
+
+func newJSONWriter(wr io.Writer, columns []scratchdb.Column) *jsonWriter {
+	w := &jsonWriter{wr: wr, columns: columns}
+	w.enc = json.NewEncoder(&w.buf)
+	w.enc.SetEscapeHTML(false)
+	return w
+}
+
+func (w *jsonWriter) writeRow(row scratchdb.Row) error {
+	w.buf.Reset()
+	w.buf.WriteByte('{')
+	for i, value := range row {
+		if i > 0 {
+			w.buf.WriteByte(',')
+		}
+		// Encode ends each value with a newline, overwrite it with the separator
+		if err := w.enc.Encode(w.columns[i].Name); err != nil {
+			return err
+		}
+		w.buf.Truncate(w.buf.Len() - 1)
+		w.buf.WriteByte(':')
+		if err := w.enc.Encode(value); err != nil {
+			return err
+		}
+		w.buf.Truncate(w.buf.Len() - 1)
+	}
+	w.buf.WriteString("}\n")
+	_, err := w.wr.Write(w.buf.Bytes())
+	return err
+}
+
+func (w *jsonWriter) flush() error {
+	return nil
+}
+
+// csvWriter writes a line per row after a header line of the column names
+type csvWriter struct {
+	w      *csv.Writer
+	record []string
+}
+
+func newCSVWriter(wr io.Writer, columns []scratchdb.Column) (*csvWriter, error) {
+	w := &csvWriter{w: csv.NewWriter(wr), record: make([]string, len(columns))}
+	for i, col := range columns {
+		w.record[i] = col.Name
+	}
+	if err := w.w.Write(w.record); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *csvWriter) writeRow(row scratchdb.Row) error {
+	for i, value := range row {
+		w.record[i] = ""
+		if value != nil {
+			w.record[i] = formatValue(value)
+		}
+	}
+	return w.w.Write(w.record)
+}
+
+func (w *csvWriter) flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/fahmifan/scratchdb"
+)
+
+// errInterrupted is returned by readLine when Ctrl-C is pressed at the prompt
+var errInterrupted = errors.New("interrupted")
+
+// lineReader reads the lines typed at the repl prompt
+type lineReader interface {
+	// readLine prints the prompt and returns the line typed after it, without
+	// its newline
+	readLine(prompt string) (string, error)
+	// addHistory adds a line to the history the up arrow and Ctrl-R recall
+	addHistory(line string)
+}
+
+// newLineReader returns a line editor when in and wr are the terminal and it
+// can be put in raw mode, otherwise a reader of plain lines. The history of
+// the editor is kept in historyFile in the home directory.
+func newLineReader(in io.Reader, wr io.Writer, interrupts <-chan os.Signal, db *scratchdb.DB) lineReader {
+	inFile, inOK := in.(*os.File)
+	outFile, outOK := wr.(*os.File)
+	if inOK && outOK && isTerminal(outFile) {
+		if restore, err := makeRaw(inFile); err == nil {
+			restore()
+			var path string
+			if home, err := os.UserHomeDir(); err == nil {
+				path = filepath.Join(home, historyFile)
+			}
+			return &lineEditor{
+				file:     inFile,
+				in:       bufio.NewReader(inFile),
+				wr:       wr,
+				history:  loadHistory(path),
+				complete: completer(db),
+			}
+		}
+	}
+	return &plainReader{wr: wr, lines: readLines(bufio.NewReader(in)), interrupts: interrupts}
+}
+
+// plainReader reads the lines of a reader that is not a terminal, or of a
+// terminal without raw mode, the terminal echoes and edits them
+type plainReader struct {
+	wr         io.Writer
+	lines      <-chan inputLine
+	interrupts <-chan os.Signal
+}
+
+func (r *plainReader) readLine(prompt string) (string, error) {
+	Print(r.wr, prompt)
+	select {
+	case <-r.interrupts:
+		return "", errInterrupted
+	case line, ok := <-r.lines:
+		if !ok {
+			return "", io.EOF
+		}
+		if line.err != nil {
+			return "", line.err
+		}
+		return strings.TrimSuffix(strings.TrimSuffix(line.text, "\n"), "\r"), nil
+	}
+}
+
+func (r *plainReader) addHistory(line string) {}
+
+// historyFile is the file in the home directory the lines entered at the
+// prompt are appended to
+const historyFile = ".scratchdb_history"
+
+// historySize is the number of lines kept in the history
+const historySize = 1000
+
+// history holds the lines entered at the prompt, oldest first. They are
+// appended to the file at path, when it is set, so the next sessions recall
+// them too. The file is only a convenience: failing to read or write it is
+// ignored.
+type history struct {
+	path    string
+	entries []string
+}
+
+// loadHistory reads the history file at path, trimming it to the last
+// historySize lines
+func loadHistory(path string) *history {
+	h := &history{path: path}
+	if path == "" {
+		return h
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return h
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			h.entries = append(h.entries, line)
+		}
+	}
+	if len(h.entries) > historySize {
+		h.entries = h.entries[len(h.entries)-historySize:]
+		os.WriteFile(path, []byte(strings.Join(h.entries, "\n")+"\n"), 0600)
+	}
+	return h
+}
+
+// add appends the line to the history, unless it is empty or repeats the
+// last one
+func (h *history) add(line string) {
+	if line == "" || len(h.entries) > 0 && h.entries[len(h.entries)-1] == line {
+		return
+	}
+	h.entries = append(h.entries, line)
+	if len(h.entries) > historySize {
+		h.entries = h.entries[1:]
+	}
+	if h.path == "" {
+		return
+	}
+	file, err := os.OpenFile(h.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	Printfln(file, "%s", line)
+}
+
+// key is a key read from the terminal: a rune, a control character or one
+// of the keys below sent as an escape sequence
+type key rune
+
+const (
+	keyUp key = unicode.MaxRune + 1 + iota
+	keyDown
+	keyRight
+	keyLeft
+	keyHome
+	keyEnd
+	keyDelete
+	// keyUnknown is an escape sequence of another key, it is ignored
+	keyUnknown
+)
+
+// ctrl returns the key of Ctrl and the letter
+func ctrl(letter byte) key {
+	return key(letter & 0x1f)
+}
+
+const (
+	keyTab       key = '\t'
+	keyEnter     key = '\r'
+	keyBackspace key = 0x7f
+	keyEscape    key = 0x1b
+)
+
+// lineEditor reads lines from a terminal in raw mode, with the keys of
+// readline:
+//   - Left, Right, Home, End, Ctrl-A, Ctrl-E, Ctrl-B and Ctrl-F move the cursor
+//   - Backspace, Delete, Ctrl-D, Ctrl-K, Ctrl-U and Ctrl-W delete
+//   - Up, Down, Ctrl-P and Ctrl-N recall the history, Ctrl-R searches it
+//   - Tab completes the word before the cursor
+//   - Ctrl-C interrupts and Ctrl-D on an empty line ends the input
+//
+// The terminal is only in raw mode while a line is read, so statements are
+// interrupted by Ctrl-C as usual. Lines are expected to fit the width of the
+// terminal, a line wrapping around is not redrawn correctly.
+type lineEditor struct {
+	file    *os.File
+	in      *bufio.Reader
+	wr      io.Writer
+	history *history
+	// complete returns the words that may replace word, typed after before
+	complete func(before, word string) []string
+}
+
+// editLine is the line being edited, with the cursor before buf[pos]
+type editLine struct {
+	wr     io.Writer
+	prompt string
+	buf    []rune
+	pos    int
+}
+
+// refresh redraws the line and puts the cursor back
+func (l *editLine) refresh() {
+	Printf(l.wr, "\r%s%s\x1b[K", l.prompt, string(l.buf))
+	if back := len(l.buf) - l.pos; back > 0 {
+		Printf(l.wr, "\x1b[%dD", back)
+	}
+}
+
+func (l *editLine) set(text string) {
+	l.buf = []rune(text)
+	l.pos = len(l.buf)
+}
+
+func (l *editLine) insert(runes ...rune) {
+	l.buf = append(l.buf[:l.pos], append(runes, l.buf[l.pos:]...)...)
+	l.pos += len(runes)
+}
+
+// delete removes the runes from start to the cursor
+func (l *editLine) delete(start int) {
+	l.buf = append(l.buf[:start], l.buf[l.pos:]...)
+	l.pos = start
+}
+
+// wordStart returns where the word before the cursor starts
+func (l *editLine) wordStart() int {
+	start := l.pos
+	for start > 0 && unicode.IsSpace(l.buf[start-1]) {
+		start--
+	}
+	for start > 0 && !unicode.IsSpace(l.buf[start-1]) {
+		start--
+	}
+	return start
+}
+
+func (e *lineEditor) addHistory(line string) {
+	e.history.add(line)
+}
+
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	restore, err := makeRaw(e.file)
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+
+	l := &editLine{wr: e.wr, prompt: prompt}
+	// entry is the position in the history of the line shown, the line being
+	// typed is past the last entry and kept in typed while entries are shown
+	entry := len(e.history.entries)
+	var typed string
+	recall := func(i int) {
+		if i < 0 || i > len(e.history.entries) || i == entry {
+			return
+		}
+		if entry == len(e.history.entries) {
+			typed = string(l.buf)
+		}
+		entry = i
+		if i == len(e.history.entries) {
+			l.set(typed)
+		} else {
+			l.set(e.history.entries[i])
+		}
+	}
+
+	l.refresh()
+	for {
+		k, err := e.readKey()
+		if err != nil {
+			return "", err
+		}
+		if k == ctrl('R') {
+			if k, err = e.search(l); err != nil {
+				return "", err
+			}
+		}
+		switch k {
+		case keyEnter, '\n':
+			l.refresh()
+			Print(e.wr, "\n")
+			return string(l.buf), nil
+		case ctrl('C'):
+			Print(e.wr, "^C")
+			return "", errInterrupted
+		case ctrl('D'):
+			if len(l.buf) == 0 {
+				Print(e.wr, "\n")
+				return "", io.EOF
+			}
+			fallthrough
+		case keyDelete:
+			if l.pos < len(l.buf) {
+				l.pos++
+				l.delete(l.pos - 1)
+			}
+		case keyBackspace, ctrl('H'):
+			if l.pos > 0 {
+				l.delete(l.pos - 1)
+			}
+		case keyLeft, ctrl('B'):
+			if l.pos > 0 {
+				l.pos--
+			}
+		case keyRight, ctrl('F'):
+			if l.pos < len(l.buf) {
+				l.pos++
+			}
+		case keyHome, ctrl('A'):
+			l.pos = 0
+		case keyEnd, ctrl('E'):
+			l.pos = len(l.buf)
+		case ctrl('K'):
+			l.buf = l.buf[:l.pos]
+		case ctrl('U'):
+			l.delete(0)
+		case ctrl('W'):
+			l.delete(l.wordStart())
+		case keyUp, ctrl('P'):
+			recall(entry - 1)
+		case keyDown, ctrl('N'):
+			recall(entry + 1)
+		case keyTab:
+			e.completeWord(l)
+		default:
+			if k >= ' ' && k <= unicode.MaxRune && k != keyBackspace {
+				l.insert(rune(k))
+			}
+		}
+		l.refresh()
+	}
+}
+
+// search runs the reverse search of Ctrl-R: the typed text is searched in
+// the history from the newest entry, Ctrl-R again finds an older match and
+// Ctrl-G gives up, restoring the line. Another key ends the search with the
+// match in the line, it is returned to be handled like any key.
+func (e *lineEditor) search(l *editLine) (key, error) {
+	entries := e.history.entries
+	original, originalPos := string(l.buf), l.pos
+	var query []rune
+	// match is the entry found, len(entries) before one is
+	match := len(entries)
+	failing := false
+	find := func(before int) {
+		for i := before - 1; i >= 0; i-- {
+			if at := strings.Index(entries[i], string(query)); at >= 0 {
+				match, failing = i, false
+				l.set(entries[i])
+				l.pos = len([]rune(entries[i][:at]))
+				return
+			}
+		}
+		failing = true
+	}
+
+	for {
+		status := "reverse-i-search"
+		if failing {
+			status = "failing " + status
+		}
+		Printf(e.wr, "\r(%s)`%s': %s\x1b[K", status, string(query), string(l.buf))
+		k, err := e.readKey()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case k == ctrl('R'):
+			if len(query) > 0 {
+				find(match)
+			}
+		case k == ctrl('G'):
+			l.set(original)
+			l.pos = originalPos
+			return keyUnknown, nil
+		case k == keyBackspace || k == ctrl('H'):
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				find(len(entries))
+			}
+		case k >= ' ' && k <= unicode.MaxRune:
+			query = append(query, rune(k))
+			if match < len(entries) {
+				find(match + 1)
+			} else {
+				find(match)
+			}
+		default:
+			return k, nil
+		}
+	}
+}
+
+// completeWord completes the word before the cursor with the words given by
+// complete: the only one, or else the prefix they share. When it can't be
+// extended further the words are listed under the line.
+func (e *lineEditor) completeWord(l *editLine) {
+	start := l.pos
+	for start > 0 && !unicode.IsSpace(l.buf[start-1]) && !strings.ContainsRune("(),;=<>", l.buf[start-1]) {
+		start--
+	}
+	word := string(l.buf[start:l.pos])
+	words := e.complete(string(l.buf[:start]), word)
+	switch len(words) {
+	case 0:
+		Print(e.wr, "\a")
+	case 1:
+		l.delete(start)
+		l.insert([]rune(words[0] + " ")...)
+	default:
+		prefix := words[0]
+		for _, w := range words[1:] {
+			for !strings.HasPrefix(w, prefix) {
+				prefix = prefix[:len(prefix)-1]
+			}
+		}
+		if len(prefix) > len(word) {
+			l.delete(start)
+			l.insert([]rune(prefix)...)
+			return
+		}
+		Print(e.wr, "\n"+strings.Join(words, "  ")+"\n")
+	}
+}
+
+// readKey reads a key, decoding the escape sequences of the arrows, Home,
+// End and Delete
+func (e *lineEditor) readKey() (key, error) {
+	r, _, err := e.in.ReadRune()
+	if err != nil || key(r) != keyEscape {
+		return key(r), err
+	}
+	r, _, err = e.in.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	if r != '[' && r != 'O' {
+		return keyUnknown, nil
+	}
+	// parameters until the final byte of the sequence
+	var params []rune
+	for {
+		if r, _, err = e.in.ReadRune(); err != nil {
+			return 0, err
+		}
+		if r >= 0x40 && r <= 0x7e {
+			break
+		}
+		params = append(params, r)
+	}
+	switch r {
+	case 'A':
+		return keyUp, nil
+	case 'B':
+		return keyDown, nil
+	case 'C':
+		return keyRight, nil
+	case 'D':
+		return keyLeft, nil
+	case 'H':
+		return keyHome, nil
+	case 'F':
+		return keyEnd, nil
+	case '~':
+		switch string(params) {
+		case "1", "7":
+			return keyHome, nil
+		case "4", "8":
+			return keyEnd, nil
+		case "3":
+			return keyDelete, nil
+		}
+	}
+	return keyUnknown, nil
+}
+
+// keywords are the words of the statements, completed by Tab
+var keywords = []string{
+	"analyze", "and", "asc", "avg", "begin", "blob", "boolean", "by", "commit", "count", "create",
+	"delete", "desc", "explain", "from", "group", "having", "index", "insert", "int", "integer",
+	"into", "is", "like", "limit", "max", "min", "not", "null", "offset", "on", "order", "random",
+	"real", "rollback", "select", "sum", "table", "text", "timestamp", "update", "vacuum", "where",
+}
+
+// completer returns the completion of the repl: meta commands at the start
+// of a line, table names after them, keywords and table names in statements
+func completer(db *scratchdb.DB) func(before, word string) []string {
+	return func(before, word string) []string {
+		var candidates []string
+		if strings.TrimSpace(before) == "" && strings.HasPrefix(word, ".") {
+			for _, cmd := range metaCommands {
+				candidates = append(candidates, cmd.name)
+			}
+		} else {
+			if !strings.HasPrefix(strings.TrimSpace(before), ".") {
+				candidates = append(candidates, keywords...)
+			}
+			if schemas, err := db.Tables(); err == nil {
+				for _, schema := range schemas {
+					candidates = append(candidates, schema.Name)
+				}
+			}
+		}
+
+		var words []string
+		seen := map[string]bool{}
+		for _, w := range candidates {
+			if strings.HasPrefix(strings.ToLower(w), strings.ToLower(word)) && !seen[w] {
+				seen[w] = true
+				words = append(words, w)
+			}
+		}
+		sort.Strings(words)
+		return words
+	}
+}
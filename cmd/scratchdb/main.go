@@ -3,13 +3,19 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"encoding/binary"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
-	"unsafe"
+	"time"
+
+	"github.com/fahmifan/scratchdb"
 )
 
 var (
@@ -19,209 +25,502 @@ var (
 )
 
 func main() {
-	if err := run(os.Args, os.Stdout); err != nil {
+	if err := run(os.Args, os.Stdin, os.Stdout, os.Stderr); err != nil {
 		os.Exit(1)
 	}
 }
 
-// run the repl
-func run(args []string, wr io.Writer) error {
-	rd := bufio.NewReader(os.Stdin)
-	table := &Table{}
+// defaultDBFile is the database opened when no file is given
+const defaultDBFile = "scratch.db"
+
+// Settings are the REPL options that can be changed at runtime with meta commands
+type Settings struct {
+	// Timeout aborts a statement that runs longer than it, zero means no timeout
+	Timeout time.Duration
+	// Logger is the database log, its level is changed with .log
+	Logger *scratchdb.Logger
+	// Mode is how selected rows are printed
+	Mode OutputMode
+	// Timer adds the duration and the pages read and written to the summary of a statement
+	Timer bool
+}
+
+// run the repl, or the statements given by the flags or piped to in. Rows
+// and prompts are written to wr, usage, errors of batch mode and the log to errWr.
+// The serve and client subcommands run a server or its client instead,
+// restore restores a backup and bench measures the database.
+func run(args []string, in io.Reader, wr, errWr io.Writer) error {
+	if len(args) > 1 {
+		switch args[1] {
+		case "serve":
+			return runServe(args[1:], wr, errWr)
+		case "client":
+			return runClient(args[1:], in, wr, errWr)
+		case "restore":
+			return runRestore(args[1:], wr, errWr)
+		case "bench":
+			return runBench(args[1:], wr, errWr)
+		}
+	}
+
+	settings := &Settings{}
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.SetOutput(errWr)
+	fs.DurationVar(&settings.Timeout, "timeout", 0, "abort statements running longer than this, e.g. 5s (0 disables)")
+	flushInterval := fs.Duration("flush-interval", time.Second, "how often committed changes are flushed to the db file in the background (0 disables)")
+	readOnly := fs.Bool("readonly", false, "open the db file read-only, statements changing it fail, even while another process has it open")
+	interactive := fs.Bool("interactive", false, "run the repl, with its prompts, even when stdin is not a terminal")
+	command := fs.String("c", "", "execute the `statements`, separated by ';', and exit")
+	scriptFile := fs.String("f", "", "execute the statements in `file` and exit")
+	mode := fs.String("mode", "table", "print selected rows as a `table`, json or csv")
+	logLevel := fs.String("log-level", "off", "log `level`: debug, info, warn, error or off")
+	logFile := fs.String("log-file", "", "append the log to `file` instead of stderr")
+	archiveDir := fs.String("archive-dir", "", "copy the write-ahead log to `dir` before each checkpoint, for scratchdb restore")
+	pageSize := fs.Uint("page-size", 0, fmt.Sprintf("page size of a new db file, a power of two from %d to %d, large enough for two rows of the default users table (default %d)", scratchdb.MinPageSize, scratchdb.MaxPageSize, scratchdb.DefaultPageSize))
+	fs.Usage = func() {
+		Printfln(fs.Output(), "Usage: %s [flags] [dbfile]\n       %s serve|client|restore|bench [flags]\n\nOpens dbfile, %s by default. Statements piped to stdin are executed like with -f.\n\nFlags:", args[0], args[0], defaultDBFile)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		err := fmt.Errorf("expected at most one db file, got %d arguments", fs.NArg())
+		Printfln(fs.Output(), "%v", err)
+		fs.Usage()
+		return err
+	}
+	if *command != "" && *scriptFile != "" {
+		err := errors.New("-c and -f can't be used together")
+		Printfln(fs.Output(), "%v", err)
+		return err
+	}
+	path := defaultDBFile
+	if fs.NArg() == 1 {
+		path = fs.Arg(0)
+	}
+
+	var ok bool
+	if settings.Mode, ok = parseOutputMode(*mode); !ok {
+		err := fmt.Errorf("unknown mode %q, expected table, json or csv", *mode)
+		Printfln(fs.Output(), "%v", err)
+		return err
+	}
+	level, err := scratchdb.ParseLogLevel(*logLevel)
+	if err != nil {
+		Printfln(fs.Output(), "%v", err)
+		return err
+	}
+	logOut := errWr
+	if *logFile != "" {
+		file, err := os.OpenFile(*logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			Printfln(errWr, "Error: %v", err)
+			return err
+		}
+		defer file.Close()
+		logOut = file
+	}
+	settings.Logger = scratchdb.NewLogger(logOut, level)
+
+	// without -c or -f, a script piped to stdin runs in batch mode too
+	var script io.Reader
+	switch {
+	case *command != "":
+		script = strings.NewReader(*command)
+	case *scriptFile != "":
+		file, err := os.Open(*scriptFile)
+		if err != nil {
+			Printfln(errWr, "Error: %v", err)
+			return err
+		}
+		defer file.Close()
+		script = file
+	case !isTerminal(in) && !*interactive:
+		script = in
+	}
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	db, err := scratchdb.OpenWithOptions(path, scratchdb.Options{
+		FlushInterval: *flushInterval,
+		PageSize:      uint32(*pageSize),
+		ReadOnly:      *readOnly,
+		Logger:        settings.Logger,
+		ArchiveDir:    *archiveDir,
+	})
+	if err != nil {
+		Printfln(wr, "Error: %v", err)
+		return err
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			Printfln(wr, "Error: %v", err)
+		}
+	}()
+
+	if script != nil {
+		if err := runBatch(wr, script, settings, db, interrupts); err != nil {
+			Printfln(errWr, "Error: %v", err)
+			return err
+		}
+		return nil
+	}
+
+	rd := newLineReader(in, wr, interrupts, db)
+	// pending are the lines of the statements typed so far, until one ends with ;
+	var pending []string
 	for {
-		Print(wr, "db > ")
-		in, err := rd.ReadString('\n')
-		switch err {
-		case nil:
-		case io.EOF:
+		prompt := "db > "
+		if len(pending) > 0 {
+			prompt = "...> "
+		}
+		line, err := rd.readLine(prompt)
+		switch {
+		case errors.Is(err, errInterrupted) && len(pending) > 0:
+			// Ctrl-C while continuing a statement drops it
+			Print(wr, "\n")
+			pending = nil
+			continue
+		case errors.Is(err, errInterrupted):
+			// Ctrl-C at the prompt exits the repl
+			Print(wr, "\n")
 			return nil
-		default:
+		case err == io.EOF:
+			return nil
+		case err != nil:
 			return err
 		}
 
-		in = strings.Trim(in, "\n")
-		if in == "" {
+		line = strings.TrimSpace(line)
+		if line == "" {
 			continue
 		}
-		if in[0] == '.' {
-			switch doMetaCommand(in) {
+		if len(pending) == 0 && line[0] == '.' {
+			rd.addHistory(line)
+			switch doMetaCommand(wr, line, settings, db, interrupts) {
 			case MetaCommandAbort:
 				return nil // exit loop
-			case MetaCommandSuccess:
-				continue
+			case MetaCommandSyntaxError:
+				Printfln(wr, "Syntax error")
 			case MetaCommandUnrecognizedCommand:
-				Printf(wr, "Unrecognized command: (%s)\n", in)
-				continue
+				Printfln(wr, "Unrecognized command: %s", unrecognizedMetaCommand(line))
 			}
+			continue
 		}
 
-		stmt := Statement{}
-		switch prepareStatement(in, &stmt) {
-		default: // PrepareUnrecognizedCharacter
-			Printfln(wr, "Unrecognized statement (%s)", in)
+		// the lines are joined with spaces, so a statement reads like it was
+		// typed on one line, in the history too
+		pending = append(pending, line)
+		stmts := splitStatements(strings.Join(pending, " "))
+		if strings.TrimSpace(stmts[len(stmts)-1]) != "" {
 			continue
-		case PrepareResultSyntaxError:
-			Printfln(wr, "Syntax error")
-		case PrepareResultSuccess:
-			executeStatement(wr, stmt, table)
-			Print(wr, "Executed\n")
-		}
-	}
-}
-
-const (
-	IDSize                = uint32(unsafe.Sizeof(Row{}.ID))
-	UsernameSize          = uint32(unsafe.Sizeof(Row{}.Username))
-	EmailSize             = uint32(unsafe.Sizeof(Row{}.Email))
-	IDOffset       uint32 = 0
-	UsernameOffset        = IDOffset + IDSize
-	EmailOffset           = UsernameOffset + UsernameSize
-	RowSize               = IDSize + UsernameSize + EmailSize
-	TableMaxPages  uint32 = 4096 // 4KB
-	PageSize       uint32 = 4096 // 4KB
-	RowsPerPage           = PageSize / IDSize
-	TableMaxRows          = RowsPerPage * TableMaxPages
-)
-
-type Table struct {
-	NumRows uint32
-	Pages   [TableMaxPages][]byte
-}
-
-func rowSlot(table *Table, rowNum uint32) (page []byte, slot uint32) {
-	pageNum := rowNum / RowsPerPage
-	if table.Pages[pageNum] == nil {
-		table.Pages[pageNum] = make([]byte, PageSize)
+		}
+		rd.addHistory(strings.Join(pending, " "))
+		pending = nil
+		for _, stmt := range stmts {
+			if stmt = strings.TrimSpace(stmt); stmt != "" {
+				execStatement(wr, stmt, settings, db, interrupts)
+			}
+		}
 	}
-	rowOffset := rowNum % RowsPerPage
-	bytesOffset := rowOffset * RowSize
-	return table.Pages[pageNum], bytesOffset
 }
 
-func executeStatement(wr io.Writer, stmt Statement, table *Table) {
-	switch stmt.Kind {
-	case StatementKindInsert:
-		executeInsert(&stmt, table)
-	case StatementKindSelect:
-		executeSelect(&stmt, table)
+// execStatement runs a statement typed at the prompt and prints its result,
+// an interrupt cancels it
+func execStatement(wr io.Writer, in string, settings *Settings, db *scratchdb.DB, interrupts <-chan os.Signal) {
+	ctx, cancel := statementContext(settings)
+	stop := cancelOnInterrupt(interrupts, cancel)
+	rs, err := db.QueryResult(ctx, in)
+	stop()
+	cancel()
+	if err != nil {
+		printError(wr, in, err)
+		return
+	}
+	if err := printResult(wr, settings.Mode, rs); err != nil {
+		Printfln(wr, "Error: %v", err)
 	}
+	printSummary(wr, settings, rs)
 }
 
-type ExecuteResult int
-
-const (
-	ExecuteTableFull ExecuteResult = iota + 1
-	ExecuteSuccess
-)
+// printError prints why the statement failed, pointing at the offending
+// token of a statement that can't be parsed
+func printError(wr io.Writer, in string, err error) {
+	if errors.Is(err, scratchdb.ErrUnrecognizedStatement) {
+		Printfln(wr, "Unrecognized statement (%s)", in)
+		return
+	}
+	Printfln(wr, "Error: %v", err)
 
-func serializeRow(row *Row, page []byte, slot uint32) {
-	binary.BigEndian.PutUint32(page[slot+IDOffset:], row.ID)
-	copy(page[slot+UsernameOffset:slot+EmailOffset], []byte(row.Username))
-	copy(page[slot+EmailOffset:slot+RowSize], []byte(row.Email))
+	var syntaxErr *scratchdb.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		Printfln(wr, "  %s", in)
+		Printfln(wr, "  %s^", strings.Repeat(" ", syntaxErr.Pos))
+	}
 }
 
-func deserializeRow(page []byte, slot uint32, row *Row) {
-	row.ID = binary.BigEndian.Uint32(page[slot+IDOffset:])
-	row.Username = string(trimNilBuf(page[slot+UsernameOffset : slot+EmailOffset]))
-	row.Email = string(trimNilBuf(page[slot+EmailOffset : slot+RowSize]))
+type inputLine struct {
+	text string
+	err  error
 }
 
-func trimNilBuf(buf []byte) []byte {
-	const trimSet = "\x00"
-	return bytes.Trim(buf, trimSet)
+// readLines reads rd line by line in the background, so the repl can wait
+// for input and interrupts at the same time. The channel is closed after
+// the first read error.
+func readLines(rd *bufio.Reader) <-chan inputLine {
+	lines := make(chan inputLine)
+	go func() {
+		defer close(lines)
+		for {
+			text, err := rd.ReadString('\n')
+			lines <- inputLine{text: text, err: err}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return lines
 }
 
-func executeInsert(stmt *Statement, table *Table) ExecuteResult {
-	if table.NumRows >= TableMaxRows {
-		return ExecuteTableFull
+// cancelOnInterrupt calls cancel when an interrupt arrives while a statement is running.
+// The returned stop func must be called once the statement is done, so later
+// interrupts go back to the prompt.
+func cancelOnInterrupt(interrupts <-chan os.Signal, cancel context.CancelFunc) (stop func()) {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-interrupts:
+			cancel()
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
 	}
-
-	rowToInsert := &stmt.RowToInsert
-	page, slot := rowSlot(table, table.NumRows)
-	serializeRow(rowToInsert, page, slot)
-	table.NumRows += 1
-
-	return ExecuteSuccess
 }
 
-func executeSelect(stmt *Statement, table *Table) ExecuteResult {
-	for i := uint32(0); i < table.NumRows; i++ {
-		row := Row{}
-		buf, slot := rowSlot(table, i)
-		deserializeRow(buf, slot, &row)
-		fmt.Println("row ", i, dump(row))
+// statementContext returns the context a single statement runs in,
+// bounded by the configured timeout if any
+func statementContext(settings *Settings) (context.Context, context.CancelFunc) {
+	if settings.Timeout <= 0 {
+		return context.WithCancel(context.Background())
 	}
-	return ExecuteSuccess
+	return context.WithTimeout(context.Background(), settings.Timeout)
 }
 
-type MetaCommand uint32
-
-const (
-	MetaCommandAbort MetaCommand = iota + 1
-	MetaCommandSuccess
-	MetaCommandUnrecognizedCommand
-)
-
-func doMetaCommand(in string) MetaCommand {
-	switch in {
-	case ".exit":
-		return MetaCommandAbort
-	default:
-		return MetaCommandUnrecognizedCommand
+// importCSV imports the csv file into the table, printing the progress and
+// the rejected lines as it goes
+func importCSV(wr io.Writer, db *scratchdb.DB, path, table string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	res, err := db.Import(file, table, scratchdb.ImportOptions{
+		Progress: func(rows uint64) { Printfln(wr, "%s imported...", plural(rows, "row")) },
+		Reject:   func(line int, err error) { Printfln(wr, "%s:%d: rejected: %v", path, line, err) },
+	})
+	if err == nil || res.Imported > 0 || res.Rejected > 0 {
+		Printfln(wr, "%s imported, %s rejected", plural(res.Imported, "row"), plural(res.Rejected, "line"))
 	}
+	return err
 }
 
-type PrepareResult uint32
-
-const (
-	PrepareStatementUnrecognized PrepareResult = iota + 1
-	PrepareResultSyntaxError
-	PrepareResultSuccess
-)
+// parseExportArgs splits `.export <table|select statement> <path>
+// [--format=csv|jsonl]`, the format is csv by default
+func parseExportArgs(in string) (source, path string, mode OutputMode, ok bool) {
+	rest, last := cutLastField(strings.TrimPrefix(in, ".export"))
+	mode = OutputModeCSV
+	if strings.HasPrefix(last, "--format=") {
+		switch strings.TrimPrefix(last, "--format=") {
+		case "csv":
+		case "jsonl":
+			mode = OutputModeJSON
+		default:
+			return "", "", 0, false
+		}
+		rest, last = cutLastField(rest)
+	}
+	source = strings.TrimSpace(rest)
+	return source, last, mode, source != "" && last != ""
+}
 
-type Row struct {
-	ID       uint32
-	Username string
-	Email    string
+// cutLastField splits s before its last space separated field
+func cutLastField(s string) (rest, last string) {
+	s = strings.TrimRight(s, " \t")
+	i := strings.LastIndexAny(s, " \t")
+	return s[:i+1], s[i+1:]
 }
 
-func (r Row) Validate() bool {
-	if r.Email == "" || r.Username == "" {
-		return false
+// exportRows writes the rows of the table, or of the select statement, to
+// the file at path in the output mode. The rows of a table are streamed from
+// a cursor, those of a statement are selected first. It returns the number
+// of rows written.
+func exportRows(db *scratchdb.DB, settings *Settings, source, path string, mode OutputMode) (uint64, error) {
+	var columns []scratchdb.Column
+	var rows []scratchdb.Row
+	var cursor *scratchdb.Cursor
+	if fields := strings.Fields(source); len(fields) > 1 || strings.EqualFold(fields[0], "select") {
+		stmt, err := scratchdb.Prepare(source)
+		if err != nil {
+			return 0, err
+		}
+		if stmt.Kind != scratchdb.StatementKindSelect {
+			return 0, fmt.Errorf("only the rows of a select can be exported, not of %s", stmt.Kind)
+		}
+		ctx, cancel := statementContext(settings)
+		rs, err := db.QueryResult(ctx, source)
+		cancel()
+		if err != nil {
+			return 0, err
+		}
+		columns, rows = rs.Columns, rs.Rows
+	} else {
+		schema, err := findSchema(db, source)
+		if err != nil {
+			return 0, err
+		}
+		if cursor, err = db.SeekTable(source, 0); err != nil {
+			return 0, err
+		}
+		columns = schema.Columns
 	}
 
-	return false
-}
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	bw := bufio.NewWriter(file)
+	w, err := newRowWriter(bw, mode, columns)
+	if err != nil {
+		return 0, err
+	}
 
-type Statement struct {
-	Kind        StatementKind
-	RowToInsert Row
+	n := uint64(0)
+	for _, row := range rows {
+		if err := w.writeRow(row); err != nil {
+			return n, err
+		}
+		n++
+	}
+	for cursor != nil && !cursor.End() {
+		row, err := cursor.Value()
+		if err != nil {
+			return n, err
+		}
+		if err := w.writeRow(row); err != nil {
+			return n, err
+		}
+		n++
+		if err := cursor.Advance(); err != nil {
+			return n, err
+		}
+	}
+	if err := w.flush(); err != nil {
+		return n, err
+	}
+	if err := bw.Flush(); err != nil {
+		return n, err
+	}
+	return n, file.Close()
 }
 
-type StatementKind uint32
+// findSchema returns the schema of the named table
+func findSchema(db *scratchdb.DB, name string) (scratchdb.Schema, error) {
+	tables, err := db.Tables()
+	if err != nil {
+		return scratchdb.Schema{}, err
+	}
+	for _, table := range tables {
+		if table.Name == name {
+			return table, nil
+		}
+	}
+	return scratchdb.Schema{}, fmt.Errorf("%w: %s", scratchdb.ErrNoSuchTable, name)
+}
 
-const (
-	StatementKindUnknown StatementKind = iota + 1
-	StatementKindInsert
-	StatementKindSelect
-)
+// printConstants prints the page layout constants, and the largest row and
+// leaf cell sizes of each table with the number of such cells a leaf holds
+func printConstants(wr io.Writer, db *scratchdb.DB) error {
+	tables, err := db.Tables()
+	if err != nil {
+		return err
+	}
 
-func prepareStatement(in string, stmt *Statement) PrepareResult {
-	if strings.HasPrefix(in, "insert") {
-		stmt.Kind = StatementKindInsert
-		nrow, err := fmt.Sscanf(in, "insert %d %s %s", &stmt.RowToInsert.ID, &stmt.RowToInsert.Username, &stmt.RowToInsert.Email)
-		if err != nil || nrow != 3 {
-			return PrepareResultSyntaxError
-		}
-		return PrepareResultSuccess
+	Printfln(wr, "Constants:")
+	pageSize := db.PageSize()
+	Printfln(wr, "PAGE_SIZE: %d", pageSize)
+	Printfln(wr, "PAGE_CHECKSUM_SIZE: %d", scratchdb.PageChecksumSize)
+	Printfln(wr, "FILE_HEADER_SIZE: %d", scratchdb.FileHeaderSize)
+	Printfln(wr, "COMMON_NODE_HEADER_SIZE: %d", scratchdb.CommonNodeHeaderSize)
+	Printfln(wr, "LEAF_NODE_HEADER_SIZE: %d", scratchdb.LeafNodeHeaderSize)
+	Printfln(wr, "LEAF_NODE_SPACE_FOR_CELLS: %d", scratchdb.LeafNodeSpaceForCells(pageSize))
+	Printfln(wr, "LEAF_NODE_MAX_RECORD_SIZE: %d", scratchdb.LeafNodeMaxRecordSize(pageSize))
+	Printfln(wr, "INTERNAL_NODE_HEADER_SIZE: %d", scratchdb.InternalNodeHeaderSize)
+	Printfln(wr, "INTERNAL_NODE_CELL_SIZE: %d", scratchdb.InternalNodeCellSize)
+	Printfln(wr, "INTERNAL_NODE_MAX_KEYS: %d", scratchdb.InternalNodeMaxKeys(pageSize, scratchdb.TableKeySize))
+	for _, table := range tables {
+		rowSize := table.RowSize()
+		Printfln(wr, "%s:", table.Name)
+		Printfln(wr, "  ROW_SIZE: %d", rowSize)
+		Printfln(wr, "  LEAF_NODE_CELL_SIZE: %d", scratchdb.LeafNodeCellHeaderSize+rowSize)
+		Printfln(wr, "  LEAF_NODE_MAX_CELLS: %d", scratchdb.LeafNodeMaxCells(pageSize, rowSize))
 	}
+	return nil
+}
 
-	if strings.HasPrefix(in, "select") {
-		stmt.Kind = StatementKindSelect
-		return PrepareResultSuccess
+// watchStatement handles `.watch <seconds> <statement>`, it re-runs the statement
+// every interval and redraws the output until interrupted
+func watchStatement(wr io.Writer, in string, settings *Settings, db *scratchdb.DB, interrupts <-chan os.Signal) MetaCommand {
+	args := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(in, ".watch")), " ", 2)
+	if len(args) != 2 {
+		return MetaCommandSyntaxError
 	}
+	seconds, err := strconv.ParseFloat(args[0], 64)
+	if err != nil || seconds <= 0 {
+		return MetaCommandSyntaxError
+	}
+	interval := time.Duration(seconds * float64(time.Second))
+	text := strings.TrimSpace(args[1])
+	if _, err := scratchdb.Prepare(text); err != nil {
+		return MetaCommandSyntaxError
+	}
+
+	for {
+		// clear the screen and move the cursor home before redrawing
+		Print(wr, "\x1b[H\x1b[2J")
+		Printfln(wr, "Every %s: %s\t%s", interval, text, time.Now().Format(time.RFC1123))
+		Print(wr, "\n")
+
+		ctx, cancel := statementContext(settings)
+		stop := cancelOnInterrupt(interrupts, cancel)
+		rs, err := db.QueryResult(ctx, text)
+		stop()
+		interrupted := errors.Is(ctx.Err(), context.Canceled)
+		cancel()
+		if interrupted {
+			return MetaCommandSuccess
+		}
+		if err != nil {
+			printError(wr, text, err)
+		} else if err := printResult(wr, settings.Mode, rs); err != nil {
+			Printfln(wr, "Error: %v", err)
+		}
 
-	return PrepareStatementUnrecognized
+		select {
+		case <-interrupts:
+			return MetaCommandSuccess
+		case <-time.After(interval):
+		}
+	}
 }
 
 func dumpPretty(i interface{}) string {
@@ -231,9 +530,3 @@ func dumpPretty(i interface{}) string {
 	_ = enc.Encode(i)
 	return buf.String()
 }
-
-func dump(i interface{}) string {
-	buf := bytes.NewBuffer(nil)
-	_ = json.NewEncoder(buf).Encode(i)
-	return buf.String()
-}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fahmifan/scratchdb"
+)
+
+// runScript runs the command with args after the db file, with input piped
+// to stdin, and returns what it wrote to stdout and stderr
+func runScript(t *testing.T, path string, input string, args ...string) (string, string, error) {
+	t.Helper()
+	var wr, errWr bytes.Buffer
+	args = append(append([]string{"scratchdb", "-flush-interval", "0"}, args...), path)
+	err := run(args, strings.NewReader(input), &wr, &errWr)
+	return wr.String(), errWr.String(), err
+}
+
+func TestREPL(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name: "statements",
+			input: `create table orders (id int, qty int, note text(16));
+insert into orders 1 3 fragile;
+insert into orders (2, 5, null), (3, 1, '');
+select from orders where qty > 1;
+select count(*), sum(qty)
+  from orders;
+insert into orders 1 9 dup;
+`,
+			want: `db > Executed
+db > 1 row inserted
+db > 2 rows inserted
+db > id | qty | note
+---+-----+--------
+ 1 |   3 | fragile
+ 2 |   5 | NULL
+2 rows selected
+db > ...> count(*) | sum(qty)
+---------+---------
+       3 |        9
+1 row selected
+db > Error: insert on table orders: duplicate key: id 1
+db > `,
+		},
+		{
+			name: "meta commands",
+			input: `insert 1 john john@example.com;
+.tables
+.mode csv
+select;
+.nope
+.exit
+select;
+`,
+			want: `db > 1 row inserted
+db > users
+db > db > id,username,email
+1,john,john@example.com
+1 row selected
+db > Unrecognized command: (.nope), did you mean .mode?
+db > `,
+		},
+		{
+			name: "meta commands wait for the statement",
+			input: `select
+.tables
+;
+`,
+			want: `db > ...> ...> Error: syntax error at column 8 near ".tables": expected a column name or an aggregate
+  select .tables
+         ^
+db > `,
+		},
+		{
+			name:  "unterminated statement at eof",
+			input: "insert 1 a a@example.com\n",
+			want:  "db > ...> ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.db")
+			out, errOut, err := runScript(t, path, tt.input, "-interactive")
+			if err != nil {
+				t.Fatalf("run: %v, stderr %q", err, errOut)
+			}
+			if out != tt.want {
+				t.Errorf("output:\n%s\nwant:\n%s", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestREPLKeepsRows(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	if _, errOut, err := runScript(t, path, "insert 1 john john@example.com;\ninsert 2 jane jane@example.com;\n", "-interactive"); err != nil {
+		t.Fatalf("run: %v, stderr %q", err, errOut)
+	}
+	out, errOut, err := runScript(t, path, "select where id = 2;\n", "-interactive")
+	if err != nil {
+		t.Fatalf("run: %v, stderr %q", err, errOut)
+	}
+	want := `db > id | username | email
+---+----------+-----------------
+ 2 | jane     | jane@example.com
+1 row selected
+db > `
+	if out != want {
+		t.Errorf("output:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	out, errOut, err := runScript(t, path, `insert 1 john john@example.com
+insert 2 jane jane@example.com; select where id = 2
+insert 1 again a@b.c
+select
+`)
+	if !errors.Is(err, scratchdb.ErrDuplicateKey) {
+		t.Fatalf("run error = %v, want the duplicate key of line 3", err)
+	}
+	wantOut := `id | username | email
+---+----------+-----------------
+ 2 | jane     | jane@example.com
+`
+	if out != wantOut {
+		t.Errorf("output:\n%s\nwant:\n%s", out, wantOut)
+	}
+	if wantErr := "Error: line 3: insert on table users: duplicate key: id 1 (insert 1 again a@b.c)\n"; errOut != wantErr {
+		t.Errorf("stderr %q, want %q", errOut, wantErr)
+	}
+
+	out, errOut, err = runScript(t, path, "", "-mode", "json", "-c", "select; select count(*)")
+	if err != nil {
+		t.Fatalf("run: %v, stderr %q", err, errOut)
+	}
+	wantOut = `{"id":1,"username":"john","email":"john@example.com"}
+{"id":2,"username":"jane","email":"jane@example.com"}
+{"count(*)":2}
+`
+	if out != wantOut {
+		t.Errorf("output:\n%s\nwant:\n%s", out, wantOut)
+	}
+
+	_, errOut, err = runScript(t, path, "", "-readonly", "-c", "delete 1")
+	if !errors.Is(err, scratchdb.ErrReadOnly) {
+		t.Errorf("run error = %v, want ErrReadOnly, stderr %q", err, errOut)
+	}
+}
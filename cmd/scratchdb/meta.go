@@ -0,0 +1,346 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fahmifan/scratchdb"
+)
+
+type MetaCommand uint32
+
+const (
+	MetaCommandAbort MetaCommand = iota + 1
+	MetaCommandSuccess
+	MetaCommandUnrecognizedCommand
+	MetaCommandSyntaxError
+)
+
+// metaEnv is what a meta command runs with
+type metaEnv struct {
+	wr         io.Writer
+	settings   *Settings
+	db         *scratchdb.DB
+	interrupts <-chan os.Signal
+}
+
+// metaCommand is a meta command of the repl: the line starting with its name
+// runs it, with its space separated fields
+type metaCommand struct {
+	name string
+	// args are the arguments as shown by .help, empty without any
+	args string
+	help string
+	// minArgs and maxArgs bound the number of fields after the name, maxArgs
+	// is -1 for commands parsing the line themselves
+	minArgs, maxArgs int
+	run              func(env *metaEnv, in string, fields []string) MetaCommand
+}
+
+// metaCommands are the meta commands in the order .help lists them. They are
+// set in init, since .help refers to them.
+var metaCommands []metaCommand
+
+func init() {
+	metaCommands = []metaCommand{
+		{name: ".backup", args: "<path>", help: "copy the committed database to a new file", minArgs: 1, maxArgs: 1, run: metaBackup},
+		{name: ".btree", args: "[table]", help: "print the B+tree of the table, the default table without a name", maxArgs: 1, run: metaBtree},
+		{name: ".constants", help: "print the page layout constants and the row and cell sizes of each table", run: metaConstants},
+		{name: ".dump", help: "print the statements that rebuild the database, replayable with -f", run: metaDump},
+		{name: ".exit", help: "exit", run: func(env *metaEnv, in string, fields []string) MetaCommand { return MetaCommandAbort }},
+		{name: ".export", args: "<source> <path> [--format=csv|jsonl]", help: "write the rows of a table, or of a select statement, to a file", minArgs: 2, maxArgs: -1, run: metaExport},
+		{name: ".flush", help: "write the committed changes to the db file now instead of waiting for the flusher", run: metaFlush},
+		{name: ".help", args: "[command]", help: "list the meta commands, or describe one", maxArgs: 1, run: metaHelp},
+		{name: ".import", args: "<file> <table>", help: "insert the rows of a csv file with a header line", minArgs: 2, maxArgs: 2, run: metaImport},
+		{name: ".log", args: "[level]", help: "print the log level, or change it to debug, info, warn, error or off", maxArgs: 1, run: metaLog},
+		{name: ".mode", args: "[table|json|csv]", help: "print the output mode, or change it", maxArgs: 1, run: metaMode},
+		{name: ".schema", args: "[table]", help: "print the create table and create index statements of the table, or of all tables", maxArgs: 1, run: metaSchema},
+		{name: ".tables", help: "list the tables", run: metaTables},
+		{name: ".timeout", args: "<duration>", help: "abort statements running longer than the duration, e.g. 5s, 0 disables it", minArgs: 1, maxArgs: 1, run: metaTimeout},
+		{name: ".timer", args: "on|off", help: "show how long statements take and the pages they read and write", minArgs: 1, maxArgs: 1, run: metaTimer},
+		{name: ".verify", help: "check the checksum of every page and print the corrupt ones", run: metaVerify},
+		{name: ".watch", args: "<seconds> <statement>", help: "re-run the statement every interval and redraw its output until interrupted", minArgs: 2, maxArgs: -1, run: metaWatch},
+	}
+}
+
+// findMetaCommand returns the meta command with the name
+func findMetaCommand(name string) (metaCommand, bool) {
+	for _, cmd := range metaCommands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return metaCommand{}, false
+}
+
+// doMetaCommand runs the meta command of the line, checking the number of
+// its arguments first
+func doMetaCommand(wr io.Writer, in string, settings *Settings, db *scratchdb.DB, interrupts <-chan os.Signal) MetaCommand {
+	fields := strings.Fields(in)
+	cmd, ok := findMetaCommand(fields[0])
+	if !ok {
+		return MetaCommandUnrecognizedCommand
+	}
+	if args := len(fields) - 1; args < cmd.minArgs || cmd.maxArgs >= 0 && args > cmd.maxArgs {
+		return MetaCommandSyntaxError
+	}
+	return cmd.run(&metaEnv{wr: wr, settings: settings, db: db, interrupts: interrupts}, in, fields)
+}
+
+// unrecognizedMetaCommand describes the unknown meta command of the line,
+// suggesting the command it is likely a typo of
+func unrecognizedMetaCommand(in string) string {
+	name := strings.Fields(in)[0]
+	if suggestion := suggestMetaCommand(name); suggestion != "" {
+		return "(" + in + "), did you mean " + suggestion + "?"
+	}
+	return "(" + in + ")"
+}
+
+// suggestMetaCommand returns the meta command the name is the start of, or
+// else the closest one at most 2 edits away, empty when there is none
+func suggestMetaCommand(name string) string {
+	var prefixed []string
+	for _, cmd := range metaCommands {
+		if strings.HasPrefix(cmd.name, name) {
+			prefixed = append(prefixed, cmd.name)
+		}
+	}
+	if len(prefixed) == 1 {
+		return prefixed[0]
+	}
+	suggestion, best := "", 3
+	for _, cmd := range metaCommands {
+		if d := editDistance(name, cmd.name); d < best {
+			suggestion, best = cmd.name, d
+		}
+	}
+	return suggestion
+}
+
+// editDistance returns the Levenshtein distance of a and b, the number of
+// runes to insert, delete or replace to turn one into the other
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	prev := make([]int, len(t)+1)
+	cur := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(t)]
+}
+
+// metaHelp handles `.help [command]`, listing every meta command with its
+// arguments and what it does, or only the named one
+func metaHelp(env *metaEnv, in string, fields []string) MetaCommand {
+	cmds := metaCommands
+	if len(fields) == 2 {
+		name := fields[1]
+		if !strings.HasPrefix(name, ".") {
+			name = "." + name
+		}
+		cmd, ok := findMetaCommand(name)
+		if !ok {
+			Printfln(env.wr, "Unrecognized command: %s", unrecognizedMetaCommand(name))
+			return MetaCommandSuccess
+		}
+		cmds = []metaCommand{cmd}
+	}
+
+	usages := make([]string, len(cmds))
+	width := 0
+	for i, cmd := range cmds {
+		usages[i] = strings.TrimSpace(cmd.name + " " + cmd.args)
+		if len(usages[i]) > width {
+			width = len(usages[i])
+		}
+	}
+	for i, cmd := range cmds {
+		Printfln(env.wr, "%-*s  %s", width, usages[i], cmd.help)
+	}
+	return MetaCommandSuccess
+}
+
+func metaBackup(env *metaEnv, in string, fields []string) MetaCommand {
+	if err := env.db.Backup(fields[1]); err != nil {
+		Printfln(env.wr, "Error: %v", err)
+		return MetaCommandSuccess
+	}
+	Printfln(env.wr, "Backed up to %s", fields[1])
+	return MetaCommandSuccess
+}
+
+func metaBtree(env *metaEnv, in string, fields []string) MetaCommand {
+	table := ""
+	if len(fields) == 2 {
+		table = fields[1]
+	}
+	Printfln(env.wr, "Tree:")
+	if err := env.db.PrintTree(env.wr, table); err != nil {
+		Printfln(env.wr, "Error: %v", err)
+	}
+	return MetaCommandSuccess
+}
+
+func metaConstants(env *metaEnv, in string, fields []string) MetaCommand {
+	if err := printConstants(env.wr, env.db); err != nil {
+		Printfln(env.wr, "Error: %v", err)
+	}
+	return MetaCommandSuccess
+}
+
+func metaDump(env *metaEnv, in string, fields []string) MetaCommand {
+	if err := env.db.Dump(env.wr); err != nil {
+		Printfln(env.wr, "Error: %v", err)
+	}
+	return MetaCommandSuccess
+}
+
+func metaExport(env *metaEnv, in string, fields []string) MetaCommand {
+	source, path, mode, ok := parseExportArgs(in)
+	if !ok {
+		return MetaCommandSyntaxError
+	}
+	n, err := exportRows(env.db, env.settings, source, path, mode)
+	if err != nil {
+		Printfln(env.wr, "Error: %v", err)
+		return MetaCommandSuccess
+	}
+	Printfln(env.wr, "%s exported to %s", plural(n, "row"), path)
+	return MetaCommandSuccess
+}
+
+func metaFlush(env *metaEnv, in string, fields []string) MetaCommand {
+	if err := env.db.Flush(); err != nil {
+		Printfln(env.wr, "Error: %v", err)
+	}
+	return MetaCommandSuccess
+}
+
+func metaImport(env *metaEnv, in string, fields []string) MetaCommand {
+	if err := importCSV(env.wr, env.db, fields[1], fields[2]); err != nil {
+		Printfln(env.wr, "Error: %v", err)
+	}
+	return MetaCommandSuccess
+}
+
+func metaLog(env *metaEnv, in string, fields []string) MetaCommand {
+	if len(fields) == 1 {
+		Printfln(env.wr, "%s", env.settings.Logger.Level())
+		return MetaCommandSuccess
+	}
+	level, err := scratchdb.ParseLogLevel(fields[1])
+	if err != nil {
+		return MetaCommandSyntaxError
+	}
+	env.settings.Logger.SetLevel(level)
+	return MetaCommandSuccess
+}
+
+func metaMode(env *metaEnv, in string, fields []string) MetaCommand {
+	if len(fields) == 1 {
+		Printfln(env.wr, "%s", env.settings.Mode)
+		return MetaCommandSuccess
+	}
+	mode, ok := parseOutputMode(fields[1])
+	if !ok {
+		return MetaCommandSyntaxError
+	}
+	env.settings.Mode = mode
+	return MetaCommandSuccess
+}
+
+func metaSchema(env *metaEnv, in string, fields []string) MetaCommand {
+	tables, err := env.db.Tables()
+	if err != nil {
+		Printfln(env.wr, "Error: %v", err)
+		return MetaCommandSuccess
+	}
+	indexes, err := env.db.Indexes()
+	if err != nil {
+		Printfln(env.wr, "Error: %v", err)
+		return MetaCommandSuccess
+	}
+	found := false
+	for _, table := range tables {
+		if len(fields) == 2 && table.Name != fields[1] {
+			continue
+		}
+		found = true
+		Printfln(env.wr, "%s", table)
+		for _, index := range indexes {
+			if index.Table == table.Name {
+				Printfln(env.wr, "%s", index)
+			}
+		}
+	}
+	if len(fields) == 2 && !found {
+		Printfln(env.wr, "Error: %v: %s", scratchdb.ErrNoSuchTable, fields[1])
+	}
+	return MetaCommandSuccess
+}
+
+func metaTables(env *metaEnv, in string, fields []string) MetaCommand {
+	tables, err := env.db.Tables()
+	if err != nil {
+		Printfln(env.wr, "Error: %v", err)
+		return MetaCommandSuccess
+	}
+	for _, table := range tables {
+		Printfln(env.wr, "%s", table.Name)
+	}
+	return MetaCommandSuccess
+}
+
+func metaTimeout(env *metaEnv, in string, fields []string) MetaCommand {
+	timeout, err := time.ParseDuration(fields[1])
+	if err != nil || timeout < 0 {
+		return MetaCommandSyntaxError
+	}
+	env.settings.Timeout = timeout
+	return MetaCommandSuccess
+}
+
+func metaTimer(env *metaEnv, in string, fields []string) MetaCommand {
+	if fields[1] != "on" && fields[1] != "off" {
+		return MetaCommandSyntaxError
+	}
+	env.settings.Timer = fields[1] == "on"
+	return MetaCommandSuccess
+}
+
+func metaVerify(env *metaEnv, in string, fields []string) MetaCommand {
+	pages, corrupt, err := env.db.Verify()
+	if err != nil {
+		Printfln(env.wr, "Error: %v", err)
+		return MetaCommandSuccess
+	}
+	for _, pageNum := range corrupt {
+		Printfln(env.wr, "page %d: %v", pageNum, scratchdb.ErrChecksum)
+	}
+	Printfln(env.wr, "%s verified, %d corrupt", plural(uint64(pages), "page"), len(corrupt))
+	return MetaCommandSuccess
+}
+
+func metaWatch(env *metaEnv, in string, fields []string) MetaCommand {
+	return watchStatement(env.wr, in, env.settings, env.db, env.interrupts)
+}
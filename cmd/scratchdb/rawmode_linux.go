@@ -0,0 +1,9 @@
+package main
+
+import "syscall"
+
+// the ioctl requests reading and setting the terminal mode
+const (
+	ioctlGetTermios = syscall.TCGETS
+	ioctlSetTermios = syscall.TCSETS
+)
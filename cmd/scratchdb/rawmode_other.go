@@ -0,0 +1,14 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// makeRaw always fails, lines are read without editing on this system
+func makeRaw(file *os.File) (restore func() error, err error) {
+	return nil, errors.New("raw terminal mode is not supported")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/fahmifan/scratchdb"
+)
+
+// runRestore restores a db file from a backup and the archived logs
+func runRestore(args []string, wr, errWr io.Writer) error {
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.SetOutput(errWr)
+	archiveDir := fs.String("archive-dir", "", "the `dir` the logs were archived to with --archive-dir")
+	to := fs.String("to", "", "stop after the commit with this `lsn`, or the last one at or before this RFC 3339 time")
+	logLevel := fs.String("log-level", "info", "log `level`: debug, info, warn, error or off")
+	fs.Usage = func() {
+		Printfln(fs.Output(), "Usage: scratchdb restore --archive-dir dir [--to lsn|time] backup dbfile\n\nWrites dbfile from the backup, taken with .backup, and replays the archived\ncommits after it, all of them or up to --to.\n\nFlags:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 || *archiveDir == "" {
+		err := errors.New("expected --archive-dir, a backup and a db file")
+		Printfln(fs.Output(), "%v", err)
+		fs.Usage()
+		return err
+	}
+	opts := scratchdb.RestoreOptions{ArchiveDir: *archiveDir}
+	if *to != "" {
+		var err error
+		if opts.ToLSN, err = strconv.ParseUint(*to, 10, 64); err != nil {
+			if opts.ToTime, err = time.Parse(time.RFC3339Nano, *to); err != nil {
+				err = fmt.Errorf("--to %q is neither an lsn nor an RFC 3339 time", *to)
+				Printfln(fs.Output(), "%v", err)
+				return err
+			}
+		}
+	}
+	level, err := scratchdb.ParseLogLevel(*logLevel)
+	if err != nil {
+		Printfln(fs.Output(), "%v", err)
+		return err
+	}
+	opts.Logger = scratchdb.NewLogger(errWr, level)
+
+	res, err := scratchdb.Restore(fs.Arg(0), fs.Arg(1), opts)
+	if err != nil {
+		Printfln(errWr, "Error: %v", err)
+		return err
+	}
+	Printfln(wr, "Restored %s to commit %d of %s, %s replayed from %s", fs.Arg(1), res.LSN, res.Time.Format(time.RFC3339Nano), plural(res.Commits, "commit"), plural(uint64(res.Segments), "archived log"))
+	return nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/fahmifan/scratchdb"
+	"github.com/fahmifan/scratchdb/server"
+)
+
+// defaultServeAddr is the address the server listens on and the client connects to
+const defaultServeAddr = "localhost:5433"
+
+// runServe serves the db file to clients until interrupted
+func runServe(args []string, wr, errWr io.Writer) error {
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.SetOutput(errWr)
+	listen := fs.String("listen", defaultServeAddr, "accept clients on `address`, empty to only serve HTTP")
+	httpAddr := fs.String("http", "", "serve the HTTP API on `address`, e.g. :8080")
+	flushInterval := fs.Duration("flush-interval", time.Second, "how often committed changes are flushed to the db file in the background (0 disables)")
+	readOnly := fs.Bool("readonly", false, "open the db file read-only, statements changing it fail, even while another process has it open")
+	archiveDir := fs.String("archive-dir", "", "copy the write-ahead log to `dir` before each checkpoint, for scratchdb restore")
+	txIdleTimeout := fs.Duration("tx-idle-timeout", server.DefaultTxIdleTimeout, "roll back a transaction left idle this long by its client (0 disables)")
+	logLevel := fs.String("log-level", "info", "log `level`: debug, info, warn, error or off")
+	fs.Usage = func() {
+		Printfln(fs.Output(), "Usage: scratchdb serve [flags] [dbfile]\n\nServes dbfile, %s by default, to scratchdb client.\n\nFlags:", defaultDBFile)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		err := fmt.Errorf("expected at most one db file, got %d arguments", fs.NArg())
+		Printfln(fs.Output(), "%v", err)
+		fs.Usage()
+		return err
+	}
+	if *listen == "" && *httpAddr == "" {
+		err := errors.New("nothing to serve, --listen and --http are both empty")
+		Printfln(fs.Output(), "%v", err)
+		return err
+	}
+	path := defaultDBFile
+	if fs.NArg() == 1 {
+		path = fs.Arg(0)
+	}
+	level, err := scratchdb.ParseLogLevel(*logLevel)
+	if err != nil {
+		Printfln(fs.Output(), "%v", err)
+		return err
+	}
+	logger := scratchdb.NewLogger(errWr, level)
+
+	db, err := scratchdb.OpenWithOptions(path, scratchdb.Options{
+		FlushInterval: *flushInterval,
+		ReadOnly:      *readOnly,
+		Logger:        logger,
+		ArchiveDir:    *archiveDir,
+	})
+	if err != nil {
+		Printfln(errWr, "Error: %v", err)
+		return err
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			Printfln(errWr, "Error: %v", err)
+		}
+	}()
+
+	srv := server.New(db, logger)
+	srv.TxIdleTimeout = *txIdleTimeout
+	httpSrv := &http.Server{Handler: srv.Handler()}
+	// errs receives the error of each of the running Serve, nil once closed
+	errs := make(chan error, 2)
+	serving := 0
+	if *listen != "" {
+		l, err := net.Listen("tcp", *listen)
+		if err != nil {
+			Printfln(errWr, "Error: %v", err)
+			return err
+		}
+		Printfln(wr, "Serving %s on %s", path, l.Addr())
+		serving++
+		go func() {
+			if err := srv.Serve(l); !errors.Is(err, server.ErrServerClosed) {
+				errs <- err
+				return
+			}
+			errs <- nil
+		}()
+	}
+	if *httpAddr != "" {
+		l, err := net.Listen("tcp", *httpAddr)
+		if err != nil {
+			srv.Close()
+			Printfln(errWr, "Error: %v", err)
+			return err
+		}
+		Printfln(wr, "Serving %s over HTTP on %s", path, l.Addr())
+		serving++
+		go func() {
+			if err := httpSrv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+				errs <- err
+				return
+			}
+			errs <- nil
+		}()
+	}
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	select {
+	case <-interrupts:
+	case err = <-errs:
+		serving--
+	}
+	httpSrv.Shutdown(context.Background())
+	srv.Close()
+	for ; serving > 0; serving-- {
+		if serveErr := <-errs; err == nil {
+			err = serveErr
+		}
+	}
+	if err != nil {
+		Printfln(errWr, "Error: %v", err)
+	}
+	return err
+}
+
+// runClient runs the statements given by -c, piped to in, or typed at a
+// prompt, on a server. Meta commands other than .exit, .mode and .timer are
+// not available.
+func runClient(args []string, in io.Reader, wr, errWr io.Writer) error {
+	settings := &Settings{}
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.SetOutput(errWr)
+	addr := fs.String("addr", defaultServeAddr, "connect to the server at `address`")
+	command := fs.String("c", "", "execute the `statements`, separated by ';', and exit")
+	mode := fs.String("mode", "table", "print selected rows as a `table`, json or csv")
+	fs.Usage = func() {
+		Printfln(fs.Output(), "Usage: scratchdb client [flags]\n\nRuns statements on a scratchdb server.\n\nFlags:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	var ok bool
+	if settings.Mode, ok = parseOutputMode(*mode); !ok {
+		err := fmt.Errorf("unknown mode %q, expected table, json or csv", *mode)
+		Printfln(fs.Output(), "%v", err)
+		return err
+	}
+
+	client, err := server.Dial(*addr)
+	if err != nil {
+		Printfln(errWr, "Error: %v", err)
+		return err
+	}
+	defer client.Close()
+
+	interactive := *command == "" && isTerminal(in)
+	if *command != "" {
+		in = strings.NewReader(*command)
+	}
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, server.MaxQuerySize)
+	for {
+		if interactive {
+			Print(wr, "db > ")
+		}
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if line[0] == '.' {
+			switch fields := strings.Fields(line); {
+			case fields[0] == ".exit":
+				return nil
+			case fields[0] == ".mode" && len(fields) == 2:
+				mode, ok := parseOutputMode(fields[1])
+				if !ok {
+					Printfln(wr, "Syntax error")
+					break
+				}
+				settings.Mode = mode
+			case fields[0] == ".timer" && len(fields) == 2 && (fields[1] == "on" || fields[1] == "off"):
+				settings.Timer = fields[1] == "on"
+			default:
+				Printf(wr, "Unrecognized command: (%s)\n", line)
+			}
+			continue
+		}
+
+		for _, stmt := range splitStatements(line) {
+			if stmt = strings.TrimSpace(stmt); stmt == "" {
+				continue
+			}
+			rs, err := client.Query(stmt)
+			if err != nil && !interactive {
+				Printfln(errWr, "Error: %v (%s)", err, stmt)
+				return err
+			}
+			if err != nil {
+				Printfln(wr, "Error: %v", err)
+				continue
+			}
+			if err := printResult(wr, settings.Mode, rs); err != nil {
+				Printfln(wr, "Error: %v", err)
+			}
+			if interactive || settings.Timer {
+				printSummary(wr, settings, rs)
+			}
+		}
+	}
+}
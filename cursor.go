@@ -0,0 +1,161 @@
+package scratchdb
+
+import "sync"
+
+// Cursor points to a row of the table and walks the rows in primary key order.
+// A cursor is invalidated by any statement that modifies the table, the
+// cursors returned by the DB then fail with ErrStaleCursor once anything in
+// the database changed.
+type Cursor struct {
+	// lock is the lock of the database for the cursors returned by the DB,
+	// they hold it shared while reading rows. It is nil for the cursors of
+	// statements, which already hold it.
+	lock *sync.RWMutex
+	// changes is the pager's count of changes when the DB returned the cursor
+	changes uint64
+	table   *Table
+	pageNum uint32
+	cellNum uint32
+	// endOfTable indicates a position one past the last element
+	endOfTable bool
+}
+
+// TableStart returns a cursor at the first row of the table
+func (db *DB) TableStart() (*Cursor, error) {
+	return db.Seek(0)
+}
+
+// Seek returns a cursor at the first row with a primary key >= key
+func (db *DB) Seek(key uint32) (*Cursor, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	table, err := defaultTable(db.pager, false)
+	if err != nil {
+		return nil, err
+	}
+	if table == nil {
+		return &Cursor{endOfTable: true}, nil
+	}
+	return db.seek(table, tableKey(key))
+}
+
+// SeekTable returns a cursor at the first row of the named table with a
+// primary key >= key, or ErrNoSuchTable
+func (db *DB) SeekTable(name string, key uint32) (*Cursor, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	table, err := findTable(db.pager, name)
+	if err != nil {
+		return nil, err
+	}
+	return db.seek(table, tableKey(key))
+}
+
+// seek is tableSeek for a cursor returned by the DB
+func (db *DB) seek(table *Table, key []byte) (*Cursor, error) {
+	c, err := tableSeek(table, key)
+	if err != nil {
+		return nil, err
+	}
+	c.lock = &db.lock
+	c.changes = db.pager.changes
+	return c, nil
+}
+
+// acquire holds the database lock for a cursor returned by the DB, and
+// checks that nothing changed since it was returned. The returned function
+// releases the lock.
+func (c *Cursor) acquire() (func(), error) {
+	if c.lock == nil {
+		return func() {}, nil
+	}
+	c.lock.RLock()
+	if c.table.pager.changes != c.changes {
+		c.lock.RUnlock()
+		return nil, ErrStaleCursor
+	}
+	return c.lock.RUnlock, nil
+}
+
+// tableSeek returns a cursor at the first cell with a key >= key, a nil key
+// is the first cell of the table
+func tableSeek(table *Table, key []byte) (*Cursor, error) {
+	c, err := tableFind(table, key)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := table.pager.getNode(c.pageNum)
+	if err != nil {
+		return nil, err
+	}
+	// the key may belong past the last cell of its leaf, continue at the next leaf
+	if c.cellNum >= n.leafNumCells() {
+		c.nextLeaf(n)
+	}
+	return c, nil
+}
+
+// End reports whether the cursor is past the last row of the table
+func (c *Cursor) End() bool {
+	return c.endOfTable
+}
+
+// Value returns the row the cursor points to
+func (c *Cursor) Value() (Row, error) {
+	release, err := c.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	n, err := c.table.pager.getNode(c.pageNum)
+	if err != nil {
+		return nil, err
+	}
+	return deserializeRow(&c.table.schema, n.leafRecord(c.cellNum))
+}
+
+// key returns a copy of the key of the cell the cursor points to
+func (c *Cursor) key() ([]byte, error) {
+	n, err := c.table.pager.getNode(c.pageNum)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), n.leafKey(c.cellNum)...), nil
+}
+
+// Advance moves the cursor to the next row, following the leaf's sibling pointer
+// once the end of the leaf is reached
+func (c *Cursor) Advance() error {
+	release, err := c.acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	n, err := c.table.pager.getNode(c.pageNum)
+	if err != nil {
+		return err
+	}
+
+	c.cellNum++
+	if c.cellNum >= n.leafNumCells() {
+		c.nextLeaf(n)
+	}
+	return nil
+}
+
+// nextLeaf moves the cursor to the first cell of the leaf's right sibling
+func (c *Cursor) nextLeaf(n node) {
+	nextPageNum := n.leafNextLeaf()
+	if nextPageNum == 0 {
+		// this was the rightmost leaf
+		c.endOfTable = true
+		return
+	}
+	c.pageNum = nextPageNum
+	c.cellNum = 0
+}
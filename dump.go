@@ -0,0 +1,109 @@
+package scratchdb
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dump writes the database as a script of statements that rebuild it: a
+// `create table` and an `insert` per row for each table, followed by the
+// `create index` statements, all in one transaction. Each statement is on
+// its own line, so the script can be run with the REPL's -f flag.
+func (db *DB) Dump(wr io.Writer) error {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	tables, err := readCatalog(db.pager)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(wr)
+	fmt.Fprintln(bw, "begin;")
+	for _, table := range tables {
+		fmt.Fprintf(bw, "%s;\n", table.schema)
+		if err := dumpRows(bw, table); err != nil {
+			return err
+		}
+	}
+	for _, table := range tables {
+		for _, idx := range table.indexes {
+			fmt.Fprintf(bw, "%s;\n", idx.Index)
+		}
+	}
+	fmt.Fprintln(bw, "commit;")
+	return bw.Flush()
+}
+
+// dumpRows writes an insert statement for each row of the table in primary key order
+func dumpRows(bw *bufio.Writer, table *Table) error {
+	c, err := tableSeek(table, nil)
+	if err != nil {
+		return err
+	}
+	for !c.End() {
+		row, err := c.Value()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(bw, "insert into %s", table.schema.Name)
+		for _, value := range row {
+			bw.WriteByte(' ')
+			bw.WriteString(Literal(value))
+		}
+		bw.WriteString(";\n")
+		if err := c.Advance(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Literal returns the value of a row as it is written in a statement, parsing
+// it back gives the same value. Text and timestamps are quoted, NULL is the
+// word null and blobs are hex.
+func Literal(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return quoteString(v)
+	case []byte:
+		return "0x" + hex.EncodeToString(v)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case time.Time:
+		return quoteString(v.Format(time.RFC3339Nano))
+	}
+	return fmt.Sprint(value)
+}
+
+// quoteString quotes s with ', escaping the characters the lexer unescapes
+func quoteString(s string) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', '\'':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case 0:
+			b.WriteString(`\0`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
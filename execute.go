@@ -0,0 +1,566 @@
+package scratchdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// executeStatement runs the statement against its table, errors are wrapped
+// with the statement kind and the table name
+func executeStatement(ctx context.Context, stmt Statement, pager *Pager) (*ResultSet, error) {
+	if stmt.Kind == StatementKindCreateTable {
+		if _, err := createTable(pager, stmt.Schema); err != nil {
+			return nil, fmt.Errorf("%s %s: %w", stmt.Kind, stmt.Schema.Name, err)
+		}
+		return &ResultSet{}, nil
+	}
+	if stmt.Kind == StatementKindCreateIndex {
+		if err := createIndex(pager, stmt.Index); err != nil {
+			return nil, fmt.Errorf("%s %s: %w", stmt.Kind, stmt.Index.Name, err)
+		}
+		return &ResultSet{}, nil
+	}
+	if stmt.Kind == StatementKindAnalyze {
+		if err := executeAnalyze(ctx, &stmt, pager); err != nil {
+			return nil, fmt.Errorf("%s: %w", stmt.Kind, err)
+		}
+		return &ResultSet{}, nil
+	}
+
+	table, err := statementTable(&stmt, pager)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", stmt.Kind, err)
+	}
+	if table == nil {
+		return &ResultSet{}, nil
+	}
+
+	rs := &ResultSet{}
+	switch stmt.Kind {
+	case StatementKindInsert:
+		rs.RowsAffected, err = executeInsert(&stmt, table)
+	case StatementKindSelect:
+		if stmt.isGrouped() {
+			rs.Columns, rs.Rows, err = executeGroup(ctx, &stmt, table)
+			break
+		}
+		rs.Columns = table.schema.Columns
+		if rs.Rows, err = executeSelect(ctx, &stmt, table); err == nil && len(stmt.Columns) > 0 {
+			rs.Columns, rs.Rows, err = projectRows(&table.schema, stmt.Columns, rs.Rows)
+		}
+	case StatementKindExplain:
+		rs.Columns, rs.Rows, err = executeExplain(&stmt, table)
+	case StatementKindInsertRandom:
+		rs.RowsAffected, err = executeInsertRandom(ctx, &stmt, table)
+	case StatementKindDelete:
+		rs.RowsAffected, err = executeDelete(ctx, &stmt, table)
+	case StatementKindUpdate:
+		rs.RowsAffected, err = executeUpdate(&stmt, table)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s on table %s: %w", stmt.Kind, table.schema.Name, err)
+	}
+	return rs, nil
+}
+
+// statementTable returns the table the statement names, or the default table.
+// Inserting into a database without tables creates the default table, other
+// statements find no rows and get a nil table.
+func statementTable(stmt *Statement, pager *Pager) (*Table, error) {
+	if stmt.Table != "" {
+		return findTable(pager, stmt.Table)
+	}
+	create := stmt.Kind == StatementKindInsert || stmt.Kind == StatementKindInsertRandom
+	return defaultTable(pager, create)
+}
+
+// executeInsert inserts the rows of the statement, it returns the number of rows inserted
+func executeInsert(stmt *Statement, table *Table) (uint64, error) {
+	rows := stmt.Rows
+	if len(rows) == 0 {
+		rows = [][]Value{stmt.Values}
+	}
+	for i, values := range rows {
+		row, err := table.schema.bindRow(values)
+		if err == nil {
+			err = insertRow(table, row)
+		}
+		if err != nil && len(rows) > 1 {
+			return 0, fmt.Errorf("row %d: %w", i+1, err)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return uint64(len(rows)), nil
+}
+
+// insertRow inserts the row and adds it to the indexes of the table, the
+// primary key is unique so it fails with ErrDuplicateKey when a row with the
+// same key exists
+func insertRow(table *Table, row Row) error {
+	c, err := tableFind(table, row.key())
+	if err != nil {
+		return err
+	}
+
+	n, err := table.pager.getNode(c.pageNum)
+	if err != nil {
+		return err
+	}
+	if c.cellNum < n.leafNumCells() && bytes.Equal(n.leafKey(c.cellNum), row.key()) {
+		return fmt.Errorf("%w: %s %d", ErrDuplicateKey, table.schema.Columns[0].Name, row[0])
+	}
+
+	if err := leafNodeInsert(c, row.key(), row); err != nil {
+		return err
+	}
+	for _, idx := range table.indexes {
+		if err := idx.insert(row); err != nil {
+			return err
+		}
+	}
+	return addRowCount(table.pager, 1)
+}
+
+var (
+	fakeNames   = []string{"alice", "bob", "carol", "dave", "erin", "frank", "grace", "heidi", "ivan", "judy", "oscar", "peggy", "trent", "victor", "walter"}
+	fakeDomains = []string{"ex.io", "mail.co", "db.dev"}
+)
+
+// fakeRow generates a plausible looking row with the given key, text columns
+// checking or named like an email get an email address and the others a
+// username
+func fakeRow(schema *Schema, key uint32) Row {
+	username := fmt.Sprintf("%s%d", fakeNames[rand.Intn(len(fakeNames))], rand.Intn(10000))
+	row := make(Row, len(schema.Columns))
+	row[0] = key
+	for i, col := range schema.Columns[1:] {
+		switch {
+		case col.Type == ColumnTypeInt:
+			row[i+1] = uint32(rand.Intn(10000))
+		case col.Type == ColumnTypeInteger:
+			row[i+1] = rand.Int63n(2000000) - 1000000
+		case col.Type == ColumnTypeReal:
+			row[i+1] = float64(rand.Intn(1000000)) / 100
+		case col.Type == ColumnTypeBoolean:
+			row[i+1] = rand.Intn(2) == 1
+		case col.Type == ColumnTypeTimestamp:
+			// a time in the past year
+			row[i+1] = time.Now().UTC().Truncate(time.Second).Add(-time.Duration(rand.Int63n(int64(365 * 24 * time.Hour))))
+		case col.Type == ColumnTypeBlob:
+			size := rand.Intn(int(col.Size) + 1)
+			if col.NotEmpty && size == 0 {
+				size = 1
+			}
+			blob := make([]byte, size)
+			rand.Read(blob)
+			row[i+1] = blob
+		case col.CheckEmail || strings.Contains(col.Name, "email"):
+			row[i+1] = truncate(username+"@"+fakeDomains[rand.Intn(len(fakeDomains))], col.Size)
+		default:
+			row[i+1] = truncate(username, col.Size)
+		}
+	}
+	return row
+}
+
+func truncate(s string, size uint32) string {
+	if uint32(len(s)) > size {
+		return s[:size]
+	}
+	return s
+}
+
+// executeInsertRandom inserts stmt.NumRandomRows generated rows with sequential keys
+// through the normal insert path
+func executeInsertRandom(ctx context.Context, stmt *Statement, table *Table) (uint64, error) {
+	maxKey, err := table.maxKey()
+	if err != nil {
+		return 0, err
+	}
+
+	for i := uint32(1); i <= stmt.NumRandomRows; i++ {
+		if ctx.Err() != nil {
+			return 0, ErrCancelled
+		}
+
+		if err := insertRow(table, fakeRow(&table.schema, maxKey+i)); err != nil {
+			return 0, err
+		}
+	}
+	return uint64(stmt.NumRandomRows), nil
+}
+
+// executeUpdate replaces the row with the same primary key and moves it in
+// the indexes of the changed columns, it returns the number of rows updated,
+// 0 when there is no such row
+func executeUpdate(stmt *Statement, table *Table) (uint64, error) {
+	row, err := table.schema.bindRow(stmt.Values)
+	if err != nil {
+		return 0, err
+	}
+	c, err := tableFind(table, row.key())
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := table.pager.getNode(c.pageNum)
+	if err != nil {
+		return 0, err
+	}
+	if c.cellNum >= n.leafNumCells() || !bytes.Equal(n.leafKey(c.cellNum), row.key()) {
+		return 0, nil
+	}
+	old, err := deserializeRow(&table.schema, n.leafRecord(c.cellNum))
+	if err != nil {
+		return 0, err
+	}
+	if err := leafNodeReplace(c, row); err != nil {
+		return 0, err
+	}
+
+	for _, idx := range table.indexes {
+		if compareValues(old[idx.column], row[idx.column]) == 0 {
+			continue
+		}
+		if err := idx.delete(old); err != nil {
+			return 0, err
+		}
+		if err := idx.insert(row); err != nil {
+			return 0, err
+		}
+	}
+	return 1, nil
+}
+
+// executeDelete removes the rows matching the where clause, it returns the
+// number of rows deleted
+func executeDelete(ctx context.Context, stmt *Statement, table *Table) (uint64, error) {
+	rows, err := executeSelect(ctx, stmt, table)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, row := range rows {
+		c, err := tableFind(table, row.key())
+		if err != nil {
+			return 0, err
+		}
+		if err := leafNodeDelete(c); err != nil {
+			return 0, err
+		}
+		for _, idx := range table.indexes {
+			if err := idx.delete(row); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err := addRowCount(table.pager, -int64(len(rows))); err != nil {
+		return 0, err
+	}
+	return uint64(len(rows)), nil
+}
+
+// executeSelect returns the rows matching the where clause, sorted by the
+// order by column and cut to the limit
+func executeSelect(ctx context.Context, stmt *Statement, table *Table) ([]Row, error) {
+	plan, err := planSelect(stmt, table)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := plan.scanRows(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if rows, err = orderRows(table, stmt, rows, plan.scan.keyOrder()); err != nil {
+		return nil, err
+	}
+	return limitRows(stmt, rows), nil
+}
+
+// executeExplain returns the plan of the select, one row per step
+func executeExplain(stmt *Statement, table *Table) ([]Column, []Row, error) {
+	plan, err := planSelect(stmt, table)
+	if err != nil {
+		return nil, nil, err
+	}
+	lines := plan.explain()
+	column := Column{Name: "plan", Type: ColumnTypeText}
+	rows := make([]Row, len(lines))
+	for i, line := range lines {
+		rows[i] = Row{line}
+		if uint32(len(line)) > column.Size {
+			column.Size = uint32(len(line))
+		}
+	}
+	return []Column{column}, rows, nil
+}
+
+// projectRows returns the columns of the select list of the rows
+func projectRows(schema *Schema, selected []SelectColumn, rows []Row) ([]Column, []Row, error) {
+	columns := make([]Column, len(selected))
+	positions := make([]int, len(selected))
+	for i, sel := range selected {
+		column, ok := schema.columnIndex(sel.Column)
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: %s", ErrNoSuchColumn, sel.Column)
+		}
+		columns[i], positions[i] = schema.Columns[column], column
+	}
+	for i, row := range rows {
+		projected := make(Row, len(positions))
+		for j, column := range positions {
+			projected[j] = row[column]
+		}
+		rows[i] = projected
+	}
+	return columns, rows, nil
+}
+
+// limitRows skips the offset rows and returns at most limit rows of the rest
+func limitRows(stmt *Statement, rows []Row) []Row {
+	if uint64(stmt.Offset) >= uint64(len(rows)) {
+		return nil
+	}
+	rows = rows[stmt.Offset:]
+	if stmt.HasLimit && uint64(stmt.Limit) < uint64(len(rows)) {
+		rows = rows[:stmt.Limit]
+	}
+	return rows
+}
+
+// scan calls visit with each row matching the conditions until it returns false
+func (p scanPlan) scan(ctx context.Context, table *Table, where []boundCondition, visit func(Row) bool) error {
+	if p.idx != nil {
+		return indexScan(ctx, table, p.idx, where, p.lo, p.hi, visit)
+	}
+	return tableScan(ctx, table, where, visit)
+}
+
+// tableScan scans the rows in key order starting at the lower bound of the
+// where clause on the primary key, and stops once a row is past its upper bound
+func tableScan(ctx context.Context, table *Table, where []boundCondition, visit func(Row) bool) error {
+	startKey, ok := whereStartKey(where)
+	if !ok {
+		return nil
+	}
+	c, err := tableSeek(table, tableKey(startKey))
+	if err != nil {
+		return err
+	}
+
+	for !c.End() {
+		// check the deadline on every row so a huge scan can be aborted
+		if ctx.Err() != nil {
+			return ErrCancelled
+		}
+
+		row, err := c.Value()
+		if err != nil {
+			return err
+		}
+
+		match, pastEnd := matchWhere(where, row)
+		if pastEnd {
+			return nil
+		}
+		if match && !visit(row) {
+			return nil
+		}
+
+		if err := c.Advance(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexRange returns the smallest and largest encoded values of the index
+// the conditions on its column allow, ok is false when no condition is on the
+// column. hi is nil when there is no upper bound. The bounds are inclusive,
+// the rows are matched against the conditions afterwards to exclude those
+// equal to the bound of a < or >.
+func indexRange(where []boundCondition, idx *index) (lo, hi []byte, ok bool) {
+	raise := func(v []byte) {
+		if bytes.Compare(v, lo) > 0 {
+			lo = v
+		}
+	}
+	lower := func(v []byte) {
+		if hi == nil || bytes.Compare(v, hi) < 0 {
+			hi = v
+		}
+	}
+	for _, cond := range where {
+		if cond.column != idx.column {
+			continue
+		}
+		switch cond.Op {
+		case OperatorLike:
+			// only a pattern starting with text bounds the values
+			prefix := likePrefix(cond.value.(string))
+			if prefix == "" {
+				continue
+			}
+			ok = true
+			raise(idx.encodeValue(prefix))
+			lower(idx.encodePrefixMax(prefix))
+			continue
+		case OperatorIsNull:
+			ok = true
+			lower(idx.encodeValue(nil))
+			continue
+		case OperatorIsNotNull:
+			continue
+		}
+
+		// a comparison never matches NULL, which sorts first
+		ok = true
+		raise([]byte{indexNotNull})
+		v := idx.encodeValue(cond.value)
+		if cond.Op != OperatorLess && cond.Op != OperatorLessEqual {
+			raise(v)
+		}
+		if cond.isUpperBound() {
+			lower(v)
+		}
+	}
+	return lo, hi, ok
+}
+
+// indexScan visits the rows of the index entries with values from lo to hi
+// matching the conditions, in the order of the index
+func indexScan(ctx context.Context, table *Table, idx *index, where []boundCondition, lo, hi []byte, visit func(Row) bool) error {
+	if hi != nil && bytes.Compare(lo, hi) > 0 {
+		return nil
+	}
+	c, err := tableSeek(idx.tree, lo)
+	if err != nil {
+		return err
+	}
+
+	for !c.End() {
+		if ctx.Err() != nil {
+			return ErrCancelled
+		}
+
+		key, err := c.key()
+		if err != nil {
+			return err
+		}
+		if hi != nil && bytes.Compare(keyValue(key), hi) > 0 {
+			return nil
+		}
+		row, err := findRow(table, keyRow(key))
+		if err != nil {
+			return err
+		}
+		if match, _ := matchWhere(where, row); match && !visit(row) {
+			return nil
+		}
+
+		if err := c.Advance(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// boundCondition is a condition with its column resolved to a position in
+// the row and its value converted to the column type, nil for is [not] null
+type boundCondition struct {
+	Condition
+	column int
+	value  interface{}
+}
+
+// bindWhere resolves the columns of the conditions and converts their values
+func bindWhere(schema *Schema, where []Condition) ([]boundCondition, error) {
+	bound := make([]boundCondition, 0, len(where))
+	for _, cond := range where {
+		column := 0
+		if cond.Column != "" {
+			var ok bool
+			column, ok = schema.columnIndex(cond.Column)
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", ErrNoSuchColumn, cond.Column)
+			}
+		}
+		if err := checkLike(cond, schema.Columns[column]); err != nil {
+			return nil, err
+		}
+		bc := boundCondition{Condition: cond, column: column}
+		if cond.Op.hasValue() {
+			var err error
+			if bc.value, err = parseValue(schema.Columns[column], cond.Value); err != nil {
+				return nil, err
+			}
+		}
+		bound = append(bound, bc)
+	}
+	return bound, nil
+}
+
+func (c boundCondition) match(value interface{}) bool {
+	return c.Op.match(value, c.value)
+}
+
+// isUpperBound reports whether the condition limits how large a matching value can be
+func (c boundCondition) isUpperBound() bool {
+	return c.Op == OperatorEqual || c.Op == OperatorLess || c.Op == OperatorLessEqual
+}
+
+// whereStartKey returns the smallest key that can match the conditions,
+// ok is false when no key can match
+func whereStartKey(where []boundCondition) (startKey uint32, ok bool) {
+	for _, cond := range where {
+		if cond.column != 0 {
+			continue
+		}
+		switch cond.Op {
+		case OperatorIsNull:
+			// the primary key is never NULL
+			return 0, false
+		case OperatorIsNotNull:
+			continue
+		}
+		key := cond.value.(uint32)
+		switch cond.Op {
+		case OperatorGreater:
+			if key == ^uint32(0) {
+				return 0, false
+			}
+			key++
+		case OperatorEqual, OperatorGreaterEqual:
+		default:
+			continue
+		}
+		if key > startKey {
+			startKey = key
+		}
+	}
+	return startKey, true
+}
+
+// matchWhere reports whether the row matches all the conditions, pastEnd is
+// true when neither the row nor any row with a larger key can match
+func matchWhere(where []boundCondition, row Row) (match bool, pastEnd bool) {
+	match = true
+	for _, cond := range where {
+		value := row[cond.column]
+		if cond.match(value) {
+			continue
+		}
+		// keys are scanned in order, once an upper bound on the key fails the following keys fail too
+		if cond.column == 0 && cond.isUpperBound() && (cond.Op != OperatorEqual || compareValues(value, cond.value) > 0) {
+			return false, true
+		}
+		match = false
+	}
+	return match, false
+}
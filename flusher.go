@@ -0,0 +1,41 @@
+package scratchdb
+
+import "time"
+
+// flusher periodically writes the committed pages in the write-ahead log to
+// the database file, so the log stays short and there is little to replay
+// after a crash
+type flusher struct {
+	stop chan struct{}
+	done chan struct{}
+	// err is the error that stopped the flusher, read once done is closed
+	err error
+}
+
+func startFlusher(pager *Pager, interval time.Duration) *flusher {
+	f := &flusher{stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(f.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-f.stop:
+				return
+			case <-ticker.C:
+				if f.err = pager.checkpoint(); f.err != nil {
+					pager.log.Errorf("background flush stopped: %v", f.err)
+					return
+				}
+			}
+		}
+	}()
+	return f
+}
+
+// close stops the flusher and returns the error that stopped it earlier, if any
+func (f *flusher) close() error {
+	close(f.stop)
+	<-f.done
+	return f.err
+}
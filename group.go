@@ -0,0 +1,509 @@
+package scratchdb
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/maphash"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// A grouped select aggregates its rows by the values of the group by
+// columns, without group by all rows are a single group. The groups are kept
+// in a hash map until it outgrows the memory limit, the rows of new groups are
+// then spilled to temporary files partitioned by the hash of their group.
+// Each file is grouped on its own once the scan is done, so all rows of a
+// group are aggregated together. The groups of a file are kept or dropped by
+// the having conditions as the file is done, and those kept go to a sorter,
+// so only the groups of one file are held at a time.
+const (
+	// spillPartitions is the number of files the spilled rows are divided into
+	spillPartitions = 16
+	// maxSpillDepth is how many times spilled rows may be partitioned again
+	// before the grouping fails with ErrMemoryLimit
+	maxSpillDepth = 4
+	// aggregatorSize is the estimated memory of an aggregator of a group
+	aggregatorSize = 64
+)
+
+// group holds the aggregates of the rows with the same group by values
+type group struct {
+	// row is the first row of the group, it holds the group by values
+	row         Row
+	aggregators []*aggregator
+}
+
+// grouper aggregates rows into groups
+type grouper struct {
+	table *Table
+	// keys are the positions of the group by columns
+	keys []int
+	// aggregators are copied for each new group
+	aggregators []*aggregator
+	groups      map[string]*group
+	// memory is the estimated size of the groups
+	memory int64
+	// depth is how many times the rows were spilled before
+	depth int
+	// partitions hold the spilled rows, nil until a row is spilled
+	partitions []*spillFile
+	// seed of the partition hash, each grouper has its own so the rows of a
+	// partition are divided again
+	seed maphash.Seed
+}
+
+func newGrouper(table *Table, keys []int, aggregators []*aggregator, depth int) *grouper {
+	return &grouper{
+		table:       table,
+		keys:        keys,
+		aggregators: aggregators,
+		groups:      map[string]*group{},
+		depth:       depth,
+		seed:        maphash.MakeSeed(),
+	}
+}
+
+// groupKey encodes the group by values of the row
+func (g *grouper) groupKey(row Row) string {
+	var buf []byte
+	for _, column := range g.keys {
+		// a flag byte tells NULL apart from the zero value
+		if row[column] == nil {
+			buf = append(buf, 0)
+			continue
+		}
+		buf = append(buf, 1)
+		switch v := row[column].(type) {
+		case uint32:
+			buf = appendUint32(buf, v)
+		case string:
+			buf = appendUint32(buf, uint32(len(v)))
+			buf = append(buf, v...)
+		case []byte:
+			buf = appendUint32(buf, uint32(len(v)))
+			buf = append(buf, v...)
+		case int64:
+			buf = appendUint64(buf, uint64(v))
+		case float64:
+			buf = appendUint64(buf, math.Float64bits(v))
+		case bool:
+			if v {
+				buf = append(buf, 1)
+			} else {
+				buf = append(buf, 0)
+			}
+		case time.Time:
+			buf = appendUint64(buf, uint64(v.UnixMicro()))
+		}
+	}
+	return string(buf)
+}
+
+// newGroup adds a group without rows for the key
+func (g *grouper) newGroup(key string, row Row) *group {
+	grp := &group{row: row, aggregators: make([]*aggregator, len(g.aggregators))}
+	for i, a := range g.aggregators {
+		grp.aggregators[i] = a.reset()
+	}
+	g.groups[key] = grp
+	return grp
+}
+
+// add aggregates the row into its group, the row is spilled when its group
+// is new and there is no memory left for it
+func (g *grouper) add(row Row) error {
+	key := g.groupKey(row)
+	grp, ok := g.groups[key]
+	if !ok {
+		size := int64(len(key)) + int64(g.table.schema.recordSize(row)) + int64(len(g.aggregators))*aggregatorSize
+		if g.memory+size > g.table.pager.memoryLimit {
+			return g.spill(key, row)
+		}
+		grp = g.newGroup(key, row)
+		g.memory += size
+	}
+	for _, a := range grp.aggregators {
+		a.add(row)
+	}
+	return nil
+}
+
+// spill writes the row to the partition of its group
+func (g *grouper) spill(key string, row Row) error {
+	if g.depth >= maxSpillDepth {
+		return fmt.Errorf("%w: the groups don't fit in %d bytes", ErrMemoryLimit, g.table.pager.memoryLimit)
+	}
+	if g.partitions == nil {
+		g.partitions = make([]*spillFile, spillPartitions)
+		for i := range g.partitions {
+			f, err := newSpillFile(&g.table.schema)
+			if err != nil {
+				return err
+			}
+			g.partitions[i] = f
+		}
+	}
+
+	var h maphash.Hash
+	h.SetSeed(g.seed)
+	h.WriteString(key)
+	return g.partitions[h.Sum64()%spillPartitions].write(row)
+}
+
+// results calls emit with each group, the groups in memory first and then
+// the groups of each partition
+func (g *grouper) results(ctx context.Context, emit func(*group) error) error {
+	for _, grp := range g.groups {
+		if err := emit(grp); err != nil {
+			return err
+		}
+	}
+	// the memory goes to the partitions
+	g.groups = nil
+
+	for _, p := range g.partitions {
+		if p == nil {
+			continue
+		}
+		sub := newGrouper(g.table, g.keys, g.aggregators, g.depth+1)
+		err := p.rows(func(row Row) error {
+			if ctx.Err() != nil {
+				return ErrCancelled
+			}
+			return sub.add(row)
+		})
+		if err == nil {
+			err = sub.results(ctx, emit)
+		}
+		sub.close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close removes the spill files
+func (g *grouper) close() {
+	for _, p := range g.partitions {
+		if p != nil {
+			p.close()
+		}
+	}
+}
+
+// spillFile is a temporary file of records, each after its size in 4 bytes.
+// The records are rows serialized with their schema, or the entries of a
+// sorter.
+type spillFile struct {
+	encode func(Row) []byte
+	decode func([]byte) (Row, error)
+	file   *os.File
+	wr     *bufio.Writer
+	buf    [4]byte
+}
+
+// newSpillFile returns a file of rows of the schema
+func newSpillFile(schema *Schema) (*spillFile, error) {
+	encode := func(row Row) []byte { return serializeRow(schema, row) }
+	decode := func(record []byte) (Row, error) { return deserializeRow(schema, record) }
+	return createSpillFile(encode, decode)
+}
+
+func createSpillFile(encode func(Row) []byte, decode func([]byte) (Row, error)) (*spillFile, error) {
+	file, err := os.CreateTemp("", "scratchdb-spill-*")
+	if err != nil {
+		return nil, err
+	}
+	return &spillFile{encode: encode, decode: decode, file: file, wr: bufio.NewWriter(file)}, nil
+}
+
+func (f *spillFile) write(row Row) error {
+	record := f.encode(row)
+	binary.BigEndian.PutUint32(f.buf[:], uint32(len(record)))
+	if _, err := f.wr.Write(f.buf[:]); err != nil {
+		return err
+	}
+	_, err := f.wr.Write(record)
+	return err
+}
+
+// rows calls visit with the rows written to the file
+func (f *spillFile) rows(visit func(Row) error) error {
+	rd, err := f.reader()
+	if err != nil {
+		return err
+	}
+	for {
+		row, err := rd.next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := visit(row); err != nil {
+			return err
+		}
+	}
+}
+
+// reader returns a reader of the rows written to the file, from the first
+func (f *spillFile) reader() (*spillReader, error) {
+	if err := f.wr.Flush(); err != nil {
+		return nil, err
+	}
+	if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return &spillReader{file: f, rd: bufio.NewReader(f.file)}, nil
+}
+
+func (f *spillFile) close() {
+	f.file.Close()
+	os.Remove(f.file.Name())
+}
+
+// spillReader reads the rows of a spill file one at a time
+type spillReader struct {
+	file   *spillFile
+	rd     *bufio.Reader
+	size   [4]byte
+	record []byte
+}
+
+// next returns the next row of the file, io.EOF after the last one
+func (r *spillReader) next() (Row, error) {
+	if _, err := io.ReadFull(r.rd, r.size[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(r.size[:])
+	if uint32(cap(r.record)) < size {
+		r.record = make([]byte, size)
+	}
+	record := r.record[:size]
+	if _, err := io.ReadFull(r.rd, record); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return r.file.decode(record)
+}
+
+// havingCondition is a having condition bound to an aggregator of the group
+// or a group by column
+type havingCondition struct {
+	Condition
+	// aggregator is the position of the aggregate in the group, -1 for a column
+	aggregator int
+	column     int
+	value      interface{}
+}
+
+func (c havingCondition) match(grp *group) bool {
+	// the group without rows of a select without group by has no row
+	if c.aggregator < 0 {
+		return c.Op.match(grp.row[c.column], c.value)
+	}
+	return c.Op.match(grp.aggregators[c.aggregator].result(), c.value)
+}
+
+// groupedSelect is a grouped select bound to its table
+type groupedSelect struct {
+	schema *Schema
+	keys   []int
+	// aggregators are the aggregates of the select list and the having conditions
+	aggregators []*aggregator
+	having      []havingCondition
+	columns     []Column
+	// outputs are the positions of the select list values, in the aggregators
+	// for aggregates or in the group row for columns
+	outputs    []int
+	aggregated []bool
+}
+
+// bindGrouped resolves the group by columns, the select list and the having
+// conditions. A column of the select list or of a having condition must be
+// one of the group by columns.
+func bindGrouped(schema *Schema, stmt *Statement) (*groupedSelect, error) {
+	gs := &groupedSelect{schema: schema}
+	for _, name := range stmt.GroupBy {
+		column, ok := schema.columnIndex(name)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrNoSuchColumn, name)
+		}
+		gs.keys = append(gs.keys, column)
+	}
+
+	selected := stmt.Columns
+	if len(selected) == 0 {
+		// a select without a list returns the group by values
+		for _, name := range stmt.GroupBy {
+			selected = append(selected, SelectColumn{Column: name})
+		}
+	}
+	for _, sel := range selected {
+		if sel.Func == 0 {
+			column, err := gs.groupColumn(sel.Column)
+			if err != nil {
+				return nil, err
+			}
+			gs.columns = append(gs.columns, schema.Columns[column])
+			gs.outputs = append(gs.outputs, column)
+			gs.aggregated = append(gs.aggregated, false)
+			continue
+		}
+		i, err := gs.aggregator(sel)
+		if err != nil {
+			return nil, err
+		}
+		gs.columns = append(gs.columns, gs.aggregators[i].resultColumn(schema))
+		gs.outputs = append(gs.outputs, i)
+		gs.aggregated = append(gs.aggregated, true)
+	}
+
+	for _, cond := range stmt.Having {
+		hc := havingCondition{Condition: cond, aggregator: -1}
+		var err error
+		if cond.Func == 0 {
+			if hc.column, err = gs.groupColumn(cond.Column); err != nil {
+				return nil, err
+			}
+			if err := checkLike(cond, schema.Columns[hc.column]); err != nil {
+				return nil, err
+			}
+			if cond.Op.hasValue() {
+				hc.value, err = parseValue(schema.Columns[hc.column], cond.Value)
+			}
+		} else {
+			if hc.aggregator, err = gs.aggregator(SelectColumn{Func: cond.Func, Column: cond.Column}); err != nil {
+				return nil, err
+			}
+			a := gs.aggregators[hc.aggregator]
+			if err := checkLike(cond, a.resultColumn(schema)); err != nil {
+				return nil, err
+			}
+			if cond.Op.hasValue() {
+				hc.value, err = a.parseResult(schema, cond.Value)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		gs.having = append(gs.having, hc)
+	}
+	return gs, nil
+}
+
+// groupColumn returns the position of the group by column with the name
+func (gs *groupedSelect) groupColumn(name string) (int, error) {
+	column, ok := gs.schema.columnIndex(name)
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrNoSuchColumn, name)
+	}
+	for _, key := range gs.keys {
+		if key == column {
+			return column, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s must be in the group by or in an aggregate", ErrSyntax, name)
+}
+
+// aggregator returns the position of the aggregate among the aggregators, it
+// is added when missing
+func (gs *groupedSelect) aggregator(sel SelectColumn) (int, error) {
+	for i, a := range gs.aggregators {
+		if a.SelectColumn == sel {
+			return i, nil
+		}
+	}
+	a, err := bindAggregate(gs.schema, sel)
+	if err != nil {
+		return 0, err
+	}
+	gs.aggregators = append(gs.aggregators, a)
+	return len(gs.aggregators) - 1, nil
+}
+
+// executeGroup computes the select list for each group of the rows matching
+// the where clause and keeps the groups matching the having conditions. The
+// groups are sorted by the order by column, which must be a group by column,
+// or else by their group by values.
+func executeGroup(ctx context.Context, stmt *Statement, table *Table) ([]Column, []Row, error) {
+	plan, err := planSelect(stmt, table)
+	if err != nil {
+		return nil, nil, err
+	}
+	gs := plan.grouped
+
+	g := newGrouper(table, gs.keys, gs.aggregators, 0)
+	defer g.close()
+	var addErr error
+	err = plan.scan.scan(ctx, table, plan.where, func(row Row) bool {
+		addErr = g.add(row)
+		return addErr == nil
+	})
+	if err == nil {
+		err = addErr
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	// without group by the aggregates have a value even without rows
+	if len(gs.keys) == 0 && len(g.groups) == 0 {
+		g.newGroup("", nil)
+	}
+
+	// a group kept by the having conditions is sorted as its order by values
+	// followed by its select list values
+	numOrderBy := len(plan.orderBy)
+	srt := newSorter(table.pager.memoryLimit, func(a, b Row) int {
+		for i := 0; i < numOrderBy; i++ {
+			if cmp := compareValues(a[i], b[i]); cmp != 0 {
+				if stmt.Desc {
+					return -cmp
+				}
+				return cmp
+			}
+		}
+		return 0
+	})
+	defer srt.close()
+	err = g.results(ctx, func(grp *group) error {
+		for _, cond := range gs.having {
+			if !cond.match(grp) {
+				return nil
+			}
+		}
+		entry := make(Row, 0, numOrderBy+len(gs.outputs))
+		for _, column := range plan.orderBy {
+			entry = append(entry, grp.row[column])
+		}
+		for j, pos := range gs.outputs {
+			if gs.aggregated[j] {
+				entry = append(entry, grp.aggregators[pos].result())
+			} else {
+				entry = append(entry, grp.row[pos])
+			}
+		}
+		return srt.add(entry)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the merge stops once the rows up to the limit are sorted
+	var rows []Row
+	err = srt.results(ctx, func(entry Row) bool {
+		rows = append(rows, entry[numOrderBy:])
+		return !stmt.HasLimit || uint64(len(rows)) < uint64(stmt.Offset)+uint64(stmt.Limit)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return gs.columns, limitRows(stmt, rows), nil
+}
@@ -0,0 +1,111 @@
+package scratchdb
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestGroupSpill(t *testing.T) {
+	// a limit of a few groups spills most rows, and sorts the groups in runs
+	small, _ := openTestDB(t, Options{MemoryLimit: 2000})
+	defer small.Close()
+	large, _ := openTestDB(t, Options{})
+	defer large.Close()
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	for _, db := range []*DB{small, large} {
+		mustExec(t, db, "create table sales (id int, region text(16), amount integer)")
+		for i := 1; i <= 2000; i++ {
+			region := "'region" + strconv.Itoa(i%300) + "'"
+			if i%7 == 0 {
+				region = "null"
+			}
+			mustExec(t, db, "insert into sales "+strconv.Itoa(i)+" "+region+" "+strconv.Itoa(i%13-6))
+		}
+	}
+
+	for _, sql := range []string{
+		"select region, count(*), sum(amount), min(id) from sales group by region",
+		"select region, count(*) from sales group by region having count(*) > 6 order by region desc",
+		"select region, max(amount) from sales where id > 500 group by region order by region limit 10 offset 5",
+		"select count(*), avg(amount) from sales",
+	} {
+		t.Run(sql, func(t *testing.T) {
+			want, err := large.Query(sql)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := small.Query(sql)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("spilled groups:\n%v\nwant:\n%v", got, want)
+			}
+			if files, _ := os.ReadDir(tmp); len(files) != 0 {
+				t.Errorf("%d spill files are left", len(files))
+			}
+		})
+	}
+}
+
+func TestSorterRuns(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	s := newSorter(100, func(a, b Row) int { return compareValues(a[0], b[0]) })
+	defer s.close()
+	var want []Row
+	for i := 0; i < 500; i++ {
+		key := uint32(i*7919) % 500
+		row := Row{key, "value" + strconv.Itoa(int(key)), nil, uint64(i), int64(-i), float64(i) / 2, i%2 == 0,
+			[]byte{byte(i)}, time.UnixMicro(int64(i)).UTC()}
+		if err := s.add(row); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, row)
+	}
+	if len(s.runs) < 2 {
+		t.Fatalf("%d runs written, want several", len(s.runs))
+	}
+
+	var got []Row
+	err := s.results(context.Background(), func(row Row) bool {
+		got = append(got, row)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("merged %d rows, want %d", len(got), len(want))
+	}
+	for i, row := range got {
+		if row[0] != uint32(i) {
+			t.Fatalf("row %d has key %v", i, row[0])
+		}
+	}
+	for _, row := range want {
+		if !reflect.DeepEqual(got[row[0].(uint32)], row) {
+			t.Errorf("merged row %v, want %v", got[row[0].(uint32)], row)
+		}
+	}
+}
+
+func TestDecodeEntryCorrupt(t *testing.T) {
+	// a uint32 of 5 bytes, text of 8 and a NULL of 1
+	record := encodeEntry(Row{uint32(1), "abc", nil})
+	whole := map[int]bool{0: true, 5: true, 13: true}
+	for size := 0; size < len(record); size++ {
+		row, err := decodeEntry(record[:size])
+		if whole[size] != (err == nil) {
+			t.Errorf("decodeEntry(%x) = %v, %v", record[:size], row, err)
+		}
+	}
+	if _, err := decodeEntry([]byte{0xff}); err == nil {
+		t.Error("decodeEntry of an unknown type succeeded")
+	}
+}
@@ -0,0 +1,117 @@
+package scratchdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// The database file starts with a header in page 0, before the catalog. It
+// holds the magic string, the format version, the page size, the number of
+// rows in all tables, the first page and length of the list of free pages,
+// and the LSN and time of the last commit.
+const (
+	FileMagic                   = "scratchdb format"
+	FileFormatVersion    uint32 = 8
+	FileMagicSize        uint32 = uint32(len(FileMagic))
+	FileMagicOffset      uint32 = 0
+	FileVersionSize      uint32 = 4
+	FileVersionOffset           = FileMagicOffset + FileMagicSize
+	FilePageSizeSize     uint32 = 4
+	FilePageSizeOffset          = FileVersionOffset + FileVersionSize
+	FileRowCountSize     uint32 = 8
+	FileRowCountOffset          = FilePageSizeOffset + FilePageSizeSize
+	FileFreelistSize     uint32 = 4
+	FileFreelistOffset          = FileRowCountOffset + FileRowCountSize
+	FileFreePagesSize    uint32 = 4
+	FileFreePagesOffset         = FileFreelistOffset + FileFreelistSize
+	FileLSNSize          uint32 = 8
+	FileLSNOffset               = FileFreePagesOffset + FileFreePagesSize
+	FileCommitTimeSize   uint32 = 8
+	FileCommitTimeOffset        = FileLSNOffset + FileLSNSize
+	FileHeaderSize              = FileCommitTimeOffset + FileCommitTimeSize
+	headerPageNum        uint32 = 0
+)
+
+// initializeFileHeader writes the header of a new database file
+func initializeFileHeader(pager *Pager) error {
+	page, err := pager.getDirtyNode(headerPageNum)
+	if err != nil {
+		return err
+	}
+	copy(page[FileMagicOffset:], FileMagic)
+	binary.BigEndian.PutUint32(page[FileVersionOffset:], FileFormatVersion)
+	binary.BigEndian.PutUint32(page[FilePageSizeOffset:], pager.pageSize)
+	binary.BigEndian.PutUint64(page[FileRowCountOffset:], 0)
+	binary.BigEndian.PutUint32(page[FileFreelistOffset:], 0)
+	binary.BigEndian.PutUint32(page[FileFreePagesOffset:], 0)
+	binary.BigEndian.PutUint64(page[FileLSNOffset:], 0)
+	binary.BigEndian.PutUint64(page[FileCommitTimeOffset:], 0)
+	return nil
+}
+
+// checkFileHeader returns ErrNotADatabase or ErrUnsupportedVersion when the
+// file can't be read as a database, or errUpgrade when it must be upgraded
+// first
+func checkFileHeader(pager *Pager) error {
+	page, err := pager.getPage(headerPageNum)
+	if err != nil {
+		return err
+	}
+	if string(page[FileMagicOffset:FileMagicOffset+FileMagicSize]) != FileMagic {
+		return ErrNotADatabase
+	}
+	version := binary.BigEndian.Uint32(page[FileVersionOffset:])
+	if version != FileFormatVersion && version != upgradableVersion {
+		return fmt.Errorf("%w: %d, expected %d", ErrUnsupportedVersion, version, FileFormatVersion)
+	}
+	if pageSize := binary.BigEndian.Uint32(page[FilePageSizeOffset:]); pageSize != pager.pageSize {
+		return fmt.Errorf("%w: file page size %d does not match %d", ErrInvalidPageSize, pageSize, pager.pageSize)
+	}
+	if version == upgradableVersion {
+		return errUpgrade
+	}
+	return nil
+}
+
+func rowCount(pager *Pager) (uint64, error) {
+	page, err := pager.getPage(headerPageNum)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(page[FileRowCountOffset:]), nil
+}
+
+// addRowCount adds delta to the row count in the header
+func addRowCount(pager *Pager, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+	page, err := pager.getDirtyNode(headerPageNum)
+	if err != nil {
+		return err
+	}
+	count := binary.BigEndian.Uint64(page[FileRowCountOffset:])
+	binary.BigEndian.PutUint64(page[FileRowCountOffset:], uint64(int64(count)+delta))
+	return nil
+}
+
+// stampCommit numbers the commit with the next LSN, the log sequence number
+// counting the commits of the database, and records its time. Every commit
+// changes the header this way, so each commit in the log carries its LSN.
+func stampCommit(pager *Pager) error {
+	page, err := pager.getDirtyNode(headerPageNum)
+	if err != nil {
+		return err
+	}
+	lsn := binary.BigEndian.Uint64(page[FileLSNOffset:])
+	binary.BigEndian.PutUint64(page[FileLSNOffset:], lsn+1)
+	binary.BigEndian.PutUint64(page[FileCommitTimeOffset:], uint64(time.Now().UnixMicro()))
+	return nil
+}
+
+// headerCommit returns the LSN and time of the last commit in the header page
+func headerCommit(page []byte) (lsn uint64, t time.Time) {
+	lsn = binary.BigEndian.Uint64(page[FileLSNOffset:])
+	return lsn, time.UnixMicro(int64(binary.BigEndian.Uint64(page[FileCommitTimeOffset:]))).UTC()
+}
@@ -0,0 +1,244 @@
+package scratchdb
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+const (
+	// ImportBatchRows is how many rows Import and BulkInsert insert between commits
+	ImportBatchRows = 1000
+	// DefaultImportProgressRows is how often Import reports its progress
+	// unless ImportOptions.ProgressRows is set
+	DefaultImportProgressRows = 10000
+)
+
+// ImportOptions configure Import, the zero value reports nothing
+type ImportOptions struct {
+	// Progress is called with the number of rows imported so far every ProgressRows rows
+	Progress func(rows uint64)
+	// ProgressRows is how often Progress is called, DefaultImportProgressRows when 0
+	ProgressRows uint64
+	// Reject is called with the line number and the reason of each line that
+	// is not imported
+	Reject func(line int, err error)
+}
+
+// ImportResult is the number of rows imported and of lines rejected by Import
+type ImportResult struct {
+	Imported uint64
+	Rejected uint64
+}
+
+// Import inserts the rows of the CSV in r into the table. The first line is
+// a header naming the column of each field, columns left out are NULL. An
+// empty field is NULL too, or empty text in a not null text column.
+//
+// A line that can't be parsed, or whose row is not valid or has a duplicate
+// key, is rejected and the import goes on. Outside a transaction the rows are
+// committed every ImportBatchRows rows, so a failing import keeps the rows of
+// the batches before it.
+//
+// The database is locked during the import, the callbacks of opts must not
+// use it.
+func (db *DB) Import(r io.Reader, tableName string, opts ImportOptions) (ImportResult, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	var res ImportResult
+	pager := db.pager
+	if pager.readOnly {
+		return res, ErrReadOnly
+	}
+	if opts.ProgressRows == 0 {
+		opts.ProgressRows = DefaultImportProgressRows
+	}
+	table, err := findTable(pager, tableName)
+	if err != nil {
+		return res, err
+	}
+
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return res, fmt.Errorf("import: no header line")
+	}
+	if err != nil {
+		return res, fmt.Errorf("import: %w", err)
+	}
+	columns, err := importColumns(&table.schema, header)
+	if err != nil {
+		return res, err
+	}
+
+	reject := func(line int, err error) {
+		res.Rejected++
+		if opts.Reject != nil {
+			opts.Reject(line, err)
+		}
+	}
+	committed := uint64(0)
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			reject(parseErr.StartLine, parseErr.Err)
+			continue
+		}
+		if err != nil {
+			return db.abortImport(res, committed, err)
+		}
+
+		line, _ := cr.FieldPos(0)
+		values := make([]Value, len(table.schema.Columns))
+		for i := range values {
+			values[i].Null = true
+		}
+		for i, field := range record {
+			col := table.schema.Columns[columns[i]]
+			if field == "" && !(col.NotNull && col.Type == ColumnTypeText) {
+				continue
+			}
+			values[columns[i]] = Value{Text: field}
+		}
+		row, err := table.schema.bindRow(values)
+		if err != nil {
+			reject(line, err)
+			continue
+		}
+
+		pager.beginStatement()
+		if err := insertRow(table, row); err != nil {
+			pager.rollbackStatement()
+			if errors.Is(err, ErrDuplicateKey) {
+				reject(line, err)
+				continue
+			}
+			return db.abortImport(res, committed, err)
+		}
+		pager.endStatement()
+
+		res.Imported++
+		if db.tx == nil && res.Imported%ImportBatchRows == 0 {
+			if err := pager.commit(); err != nil {
+				return db.abortImport(res, committed, err)
+			}
+			committed = res.Imported
+		}
+		if opts.Progress != nil && res.Imported%opts.ProgressRows == 0 {
+			opts.Progress(res.Imported)
+		}
+	}
+
+	if db.tx == nil {
+		if err := pager.commit(); err != nil {
+			return db.abortImport(res, committed, err)
+		}
+	}
+	return res, nil
+}
+
+// BulkInsert inserts the rows into the table in batches of ImportBatchRows
+// rows, the values of a row have the Go types of their columns, see Row. Each
+// batch is inserted in key order and committed with a single sync of the
+// write-ahead log, instead of one per row.
+//
+// It stops at the first row that is not valid or has a duplicate key. Outside
+// a transaction the batches before it stay committed and their rows are
+// returned as inserted, inside a transaction none of the rows are inserted.
+func (db *DB) BulkInsert(tableName string, rows []Row) (uint64, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	pager := db.pager
+	if pager.readOnly {
+		return 0, ErrReadOnly
+	}
+	table, err := findTable(pager, tableName)
+	if err != nil {
+		return 0, err
+	}
+
+	if db.tx != nil {
+		pager.beginStatement()
+	}
+	fail := func(inserted uint64, err error) (uint64, error) {
+		if db.tx != nil {
+			pager.rollbackStatement()
+			inserted = 0
+		} else {
+			pager.rollback()
+		}
+		return inserted, fmt.Errorf("bulk insert: %w", err)
+	}
+
+	inserted := uint64(0)
+	for start := 0; start < len(rows); start += ImportBatchRows {
+		end := start + ImportBatchRows
+		if end > len(rows) {
+			end = len(rows)
+		}
+		batch := make([]Row, 0, end-start)
+		for i, row := range rows[start:end] {
+			checked, err := table.schema.checkRow(row)
+			if err != nil {
+				return fail(inserted, fmt.Errorf("row %d: %w", start+i+1, err))
+			}
+			batch = append(batch, checked)
+		}
+		// rows in key order fill the leaves one after the other
+		sort.SliceStable(batch, func(i, j int) bool {
+			return batch[i][0].(uint32) < batch[j][0].(uint32)
+		})
+		for _, row := range batch {
+			if err := insertRow(table, row); err != nil {
+				return fail(inserted, err)
+			}
+		}
+		if db.tx == nil {
+			if err := pager.commit(); err != nil {
+				return fail(inserted, err)
+			}
+		}
+		inserted += uint64(len(batch))
+	}
+	if db.tx != nil {
+		pager.endStatement()
+	}
+	return inserted, nil
+}
+
+// importColumns returns the position in the table of the column each field
+// of the header names
+func importColumns(schema *Schema, header []string) ([]int, error) {
+	columns := make([]int, len(header))
+	seen := map[int]bool{}
+	for i, name := range header {
+		column, ok := schema.columnIndex(name)
+		if !ok {
+			return nil, fmt.Errorf("import: %w: %s", ErrNoSuchColumn, name)
+		}
+		if seen[column] {
+			return nil, fmt.Errorf("import: column %s is in the header twice", name)
+		}
+		seen[column] = true
+		columns[i] = column
+	}
+	return columns, nil
+}
+
+// abortImport discards the uncommitted batch of a failed import outside a
+// transaction, only the committed rows are counted as imported
+func (db *DB) abortImport(res ImportResult, committed uint64, err error) (ImportResult, error) {
+	if db.tx == nil {
+		db.pager.rollback()
+		res.Imported = committed
+	}
+	return res, fmt.Errorf("import: %w", err)
+}
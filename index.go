@@ -0,0 +1,271 @@
+package scratchdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// An index is a B+tree of keys without values. A key is the indexed value
+// encoded so the keys compare as bytes like the values, followed by the
+// primary key of the row in 4 bytes, so keys are unique and the rows with the
+// same value are next to each other in primary key order.
+//
+// The value starts with a byte that is 0 for NULL, whose bytes are zeros, and
+// 1 for a value, so NULL sorts first. An int is its 4 bytes big endian, an
+// integer or timestamp its 8 bytes with the sign bit flipped so negative
+// numbers come first, a real its IEEE 754 bits with the sign bit flipped, and
+// all the bits of negative numbers, and a boolean 1 byte. Text and blobs are
+// their bytes padded with zeros to the size of the column, followed by their
+// length in 2 bytes, so a value comes before the longer values it starts.
+// Every key of an index has the same size.
+
+// Index is a secondary index on a column of a table, created with `create index`
+type Index struct {
+	Name   string
+	Table  string
+	Column string
+}
+
+// String returns the `create index` statement defining the index
+func (i Index) String() string {
+	return fmt.Sprintf("create index %s on %s(%s)", i.Name, i.Table, i.Column)
+}
+
+// index is an Index of a table in the catalog
+type index struct {
+	Index
+	// column is the position of the indexed column in the rows of the table
+	column int
+	// columnDef is the indexed column, whose type and size give the encoding
+	// of the values
+	columnDef Column
+	// tree is the B+tree of the index, a table without columns
+	tree *Table
+	// stats are gathered by analyze, nil until the index is analyzed
+	stats *indexStats
+}
+
+const (
+	// indexNull and indexNotNull start the encoded values of the index
+	indexNull    byte = 0
+	indexNotNull byte = 1
+	// indexRowKeySize is the size of the primary key ending an index key
+	indexRowKeySize = IntSize
+	// indexMinKeys is the fewest keys an internal node of an index must hold,
+	// so wide keys don't make a deep tree of a few keys per node
+	indexMinKeys uint32 = 8
+)
+
+func newIndex(pager *Pager, def Index, schema *Schema, column int, rootPageNum uint32) *index {
+	return &index{
+		Index:     def,
+		column:    column,
+		columnDef: schema.Columns[column],
+		tree:      &Table{schema: Schema{Name: def.Name}, rootPageNum: rootPageNum, pager: pager},
+	}
+}
+
+// valueSize returns the size of the encoded values of the index
+func (idx *index) valueSize() uint32 {
+	size := 1 + idx.columnDef.Size
+	if idx.columnDef.isVariable() {
+		size += VarLengthSize
+	}
+	return size
+}
+
+// keySize returns the size of the keys of the index
+func (idx *index) keySize() uint32 {
+	return idx.valueSize() + indexRowKeySize
+}
+
+// encodeValue returns the encoded value, a value of the indexed column or
+// nil for NULL
+func (idx *index) encodeValue(value interface{}) []byte {
+	b := make([]byte, idx.valueSize())
+	if value == nil {
+		b[0] = indexNull
+		return b
+	}
+	b[0] = indexNotNull
+	switch v := value.(type) {
+	case uint32:
+		binary.BigEndian.PutUint32(b[1:], v)
+	case int64:
+		binary.BigEndian.PutUint64(b[1:], uint64(v)^1<<63)
+	case time.Time:
+		binary.BigEndian.PutUint64(b[1:], uint64(v.UnixMicro())^1<<63)
+	case float64:
+		bits := math.Float64bits(v)
+		// negative numbers sort in reverse when their sign bit is set
+		if bits>>63 == 1 {
+			bits = ^bits
+		} else {
+			bits |= 1 << 63
+		}
+		binary.BigEndian.PutUint64(b[1:], bits)
+	case bool:
+		if v {
+			b[1] = 1
+		}
+	case string:
+		encodeVariable(b, []byte(v))
+	case []byte:
+		encodeVariable(b, v)
+	}
+	return b
+}
+
+// encodeVariable writes the bytes of text or a blob after the first byte of
+// b, padded with zeros, and their length in the last 2 bytes
+func encodeVariable(b []byte, v []byte) {
+	copy(b[1:len(b)-int(VarLengthSize)], v)
+	binary.BigEndian.PutUint16(b[len(b)-int(VarLengthSize):], uint16(len(v)))
+}
+
+// encodePrefixMax returns the largest encoded value of text starting with
+// prefix: the prefix padded with 0xff and the largest length
+func (idx *index) encodePrefixMax(prefix string) []byte {
+	b := idx.encodeValue(prefix)
+	for i := 1 + len(prefix); i < len(b); i++ {
+		b[i] = 0xff
+	}
+	return b
+}
+
+// key returns the key of the row in the index
+func (idx *index) key(row Row) []byte {
+	key := append(idx.encodeValue(row[idx.column]), make([]byte, indexRowKeySize)...)
+	binary.BigEndian.PutUint32(key[idx.valueSize():], row[0].(uint32))
+	return key
+}
+
+// keyValue returns the encoded value of the index key
+func keyValue(key []byte) []byte {
+	return key[:len(key)-int(indexRowKeySize)]
+}
+
+// keyRow returns the primary key of the row of the index key
+func keyRow(key []byte) uint32 {
+	return binary.BigEndian.Uint32(key[len(key)-int(indexRowKeySize):])
+}
+
+// insert adds the row to the index
+func (idx *index) insert(row Row) error {
+	key := idx.key(row)
+	c, err := tableFind(idx.tree, key)
+	if err != nil {
+		return err
+	}
+	return leafNodeInsert(c, key, nil)
+}
+
+// delete removes the row from the index
+func (idx *index) delete(row Row) error {
+	key := idx.key(row)
+	c, err := tableFind(idx.tree, key)
+	if err != nil {
+		return err
+	}
+	n, err := idx.tree.pager.getNode(c.pageNum)
+	if err != nil {
+		return err
+	}
+	if c.cellNum >= n.leafNumCells() || !bytes.Equal(n.leafKey(c.cellNum), key) {
+		return fmt.Errorf("index %s is corrupt: missing row %d", idx.Name, row[0])
+	}
+	return leafNodeDelete(c)
+}
+
+// createIndex adds the index to the catalog, allocates its root page and
+// adds the rows already in the table
+func createIndex(pager *Pager, def Index) error {
+	if !isIdentifier(def.Name) {
+		return fmt.Errorf("%w: invalid index name %q", ErrInvalidSchema, def.Name)
+	}
+
+	tables, err := readCatalog(pager)
+	if err != nil {
+		return err
+	}
+	var table *Table
+	for _, t := range tables {
+		if t.schema.Name == def.Table {
+			table = t
+		}
+		for _, idx := range t.indexes {
+			if idx.Name == def.Name {
+				return ErrIndexExists
+			}
+		}
+	}
+	if table == nil {
+		return fmt.Errorf("%w: %s", ErrNoSuchTable, def.Table)
+	}
+	column, ok := table.schema.columnIndex(def.Column)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoSuchColumn, def.Column)
+	}
+	if column == 0 {
+		return fmt.Errorf("%w: %s is the primary key, the table is already ordered by it", ErrInvalidSchema, def.Column)
+	}
+
+	idx := newIndex(pager, def, &table.schema, column, 0)
+	if InternalNodeMaxKeys(pager.pageSize, idx.keySize()) < indexMinKeys {
+		return fmt.Errorf("%w: %s is too wide to index with %d byte pages", ErrInvalidSchema, def.Column, pager.pageSize)
+	}
+
+	rootPageNum, err := pager.allocatePage()
+	if err != nil {
+		return err
+	}
+	idx.tree.rootPageNum = rootPageNum
+	root, err := pager.getDirtyNode(rootPageNum)
+	if err != nil {
+		return err
+	}
+	initializeLeafNode(root, idx.keySize())
+	root.setRoot(true)
+
+	c, err := tableSeek(table, nil)
+	if err != nil {
+		return err
+	}
+	for !c.End() {
+		row, err := c.Value()
+		if err != nil {
+			return err
+		}
+		if err := idx.insert(row); err != nil {
+			return err
+		}
+		if err := c.Advance(); err != nil {
+			return err
+		}
+	}
+
+	table.indexes = append(table.indexes, idx)
+	return writeCatalog(pager, tables)
+}
+
+// Indexes returns the indexes of the tables, those of a table in the order
+// they were created
+func (db *DB) Indexes() ([]Index, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	tables, err := readCatalog(db.pager)
+	if err != nil {
+		return nil, err
+	}
+	var indexes []Index
+	for _, table := range tables {
+		for _, idx := range table.indexes {
+			indexes = append(indexes, idx.Index)
+		}
+	}
+	return indexes, nil
+}
@@ -0,0 +1,247 @@
+package scratchdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIndexKeyOrder(t *testing.T) {
+	schema := mustSchema(t, "create table t (id int, n int, i integer, r real, b boolean, s text(8), x blob(4), ts timestamp)")
+	tests := []struct {
+		column int
+		// values are in increasing order
+		values []interface{}
+	}{
+		{1, []interface{}{nil, uint32(0), uint32(1), uint32(256), uint32(math.MaxUint32)}},
+		{2, []interface{}{nil, int64(math.MinInt64), int64(-1 << 32), int64(-1), int64(0), int64(1), int64(1 << 32), int64(math.MaxInt64)}},
+		{3, []interface{}{nil, math.Inf(-1), -1e300, -2.5, -2.25, -1e-300, 0.0, 1e-300, 2.25, 2.5, 1e300, math.Inf(1)}},
+		{4, []interface{}{nil, false, true}},
+		{5, []interface{}{nil, "", "\x00", "a", "a\x00", "ab", "abcd", "abcde", "abcdf", "abcdfff", "b", "\xff\xff\xff\xff\xff\xff\xff\xff"}},
+		{6, []interface{}{nil, []byte{}, []byte{0}, []byte{0, 0}, []byte{0, 1}, []byte{1}, []byte{0xff, 0xff, 0xff, 0xff}}},
+		{7, []interface{}{nil, time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC), time.Unix(-1, 0), time.Unix(0, 0), time.Unix(0, 1000), time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)}},
+	}
+	for _, tt := range tests {
+		col := schema.Columns[tt.column]
+		t.Run(col.Name, func(t *testing.T) {
+			idx := newIndex(nil, Index{Name: "i", Table: "t", Column: col.Name}, schema, tt.column, 0)
+			for i := 1; i < len(tt.values); i++ {
+				a, b := tt.values[i-1], tt.values[i]
+				if compareValues(a, b) >= 0 {
+					t.Fatalf("test values %v and %v are not in order", a, b)
+				}
+				// the larger value sorts last even with a smaller primary key
+				keyA := idx.key(Row{uint32(2), a, a, a, a, a, a, a})
+				keyB := idx.key(Row{uint32(1), b, b, b, b, b, b, b})
+				if uint32(len(keyA)) != idx.keySize() || uint32(len(keyB)) != idx.keySize() {
+					t.Errorf("keys of %d and %d bytes, want %d", len(keyA), len(keyB), idx.keySize())
+				}
+				if bytes.Compare(keyA, keyB) >= 0 {
+					t.Errorf("key of %v (%x) doesn't sort before the key of %v (%x)", a, keyA, b, keyB)
+				}
+				if keyRow(keyA) != 2 || keyRow(keyB) != 1 {
+					t.Errorf("keyRow = %d and %d, want 2 and 1", keyRow(keyA), keyRow(keyB))
+				}
+			}
+		})
+	}
+}
+
+func TestIndexFullValue(t *testing.T) {
+	// small pages split the index into a tree of a few keys per node
+	db, _ := openTestDB(t, Options{PageSize: MinPageSize})
+	defer db.Close()
+	mustExec(t, db, "create table people (id int, name text(100), score integer)")
+	mustExec(t, db, "create index on people(name)")
+	mustExec(t, db, "create index on people(score)")
+	// the names share their first 10 bytes, and the scores their upper 32 bits
+	for i := 1; i <= 300; i++ {
+		n := strconv.Itoa(i)
+		name := "'john.smith" + n + "'"
+		if i%50 == 0 {
+			name = "null"
+		}
+		mustExec(t, db, "insert into people "+n+" "+name+" "+strconv.Itoa(1<<40+i%10))
+	}
+	for i := 100; i < 200; i++ {
+		mustExec(t, db, "delete from people "+strconv.Itoa(i))
+	}
+	mustExec(t, db, "update people 7 john.smith7b 1099511627777")
+
+	tests := []struct {
+		sql  string
+		want []uint32
+	}{
+		{"select from people where name = 'john.smith42'", []uint32{42}},
+		{"select from people where name = 'john.smith4'", []uint32{4}},
+		{"select from people where name = 'john.smith7'", []uint32{}},
+		{"select from people where name = 'john.smith7b'", []uint32{7}},
+		{"select from people where name = 'john.smith150'", []uint32{}},
+		{"select from people where name like 'john.smith29%'", []uint32{29, 290, 291, 292, 293, 294, 295, 296, 297, 298, 299}},
+		{"select from people where name > 'john.smith97'", []uint32{98, 99}},
+		{"select from people where name < 'john.smith10'", []uint32{1}},
+		{"select from people where name <= 'john.smith10'", []uint32{1, 10}},
+		{"select from people where name is null", []uint32{50, 200, 250, 300}},
+		{"select from people where score = 1099511627779", []uint32{3, 13, 23, 33, 43, 53, 63, 73, 83, 93, 203, 213, 223, 233, 243, 253, 263, 273, 283, 293}},
+		{"select from people where score < 1099511627777", []uint32{10, 20, 30, 40, 50, 60, 70, 80, 90, 200, 210, 220, 230, 240, 250, 260, 270, 280, 290, 300}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			rs, err := db.QueryResult(context.Background(), "explain "+tt.sql)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if plan := rs.Rows[0][0].(string); !strings.HasPrefix(plan, "index scan") {
+				t.Errorf("plan %q does not scan an index", plan)
+			}
+			keys := selectKeys(t, db, tt.sql+" order by id")
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Errorf("selected %v, want %v", keys, tt.want)
+			}
+		})
+	}
+	if _, corrupt, err := db.Verify(); err != nil || len(corrupt) != 0 {
+		t.Errorf("Verify: corrupt pages %v, %v", corrupt, err)
+	}
+}
+
+func TestCreateIndexTooWide(t *testing.T) {
+	db, _ := openTestDB(t, Options{PageSize: MinPageSize})
+	defer db.Close()
+	// a key of 127 bytes, an internal page holds only seven
+	mustExec(t, db, "create table t (id int, s text(120))")
+	if err := db.Exec("create index on t(s)"); !errors.Is(err, ErrInvalidSchema) {
+		t.Errorf("create index error = %v, want ErrInvalidSchema", err)
+	}
+	mustExec(t, db, "create table u (id int, s text(100))")
+	mustExec(t, db, "create index on u(s)")
+}
+
+// checkParents checks that every child of the internal nodes of the tree
+// points to its parent, and returns the depth of the tree
+func checkParents(t *testing.T, pager *Pager, pageNum uint32) int {
+	t.Helper()
+	n, err := pager.getNode(pageNum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.nodeType() == NodeLeaf {
+		return 1
+	}
+	depth := 0
+	for i := uint32(0); i <= n.internalNumKeys(); i++ {
+		childPageNum := n.internalRightChild()
+		if i < n.internalNumKeys() {
+			childPageNum = n.internalChild(i)
+		}
+		child, err := pager.getNode(childPageNum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if child.parent() != pageNum {
+			t.Errorf("page %d has parent %d, its parent is %d", childPageNum, child.parent(), pageNum)
+		}
+		depth = checkParents(t, pager, childPageNum) + 1
+	}
+	return depth
+}
+
+func TestIndexDeepTree(t *testing.T) {
+	// keys of a text(100) column leave about 9 keys per internal node of the
+	// smallest pages, so splits of internal nodes cascade
+	db, _ := openTestDB(t, Options{PageSize: MinPageSize})
+	defer db.Close()
+	mustExec(t, db, "create table t (id int, name text(100))")
+	mustExec(t, db, "create index on t(name)")
+
+	rnd := rand.New(rand.NewSource(1))
+	names := map[uint32]string{}
+	for i := 0; i < 6000; i++ {
+		id := uint32(rnd.Intn(3000) + 1)
+		name, value := "name"+strconv.Itoa(rnd.Intn(100000)), ""
+		if rnd.Intn(10) == 0 {
+			name, value = "", "null"
+		} else {
+			value = "'" + name + "'"
+		}
+		key := strconv.Itoa(int(id))
+		_, exists := names[id]
+		switch {
+		case !exists:
+			mustExec(t, db, "insert into t "+key+" "+value)
+			names[id] = name
+		case rnd.Intn(2) == 0:
+			mustExec(t, db, "update t "+key+" "+value)
+			names[id] = name
+		default:
+			mustExec(t, db, "delete from t "+key)
+			delete(names, id)
+		}
+	}
+
+	table, err := findTable(db.pager, "t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkParents(t, db.pager, table.rootPageNum)
+	if depth := checkParents(t, db.pager, table.indexes[0].tree.rootPageNum); depth < 4 {
+		t.Errorf("the index is %d levels deep, want at least 4", depth)
+	}
+	for id, name := range names {
+		if name == "" {
+			continue
+		}
+		keys := selectKeys(t, db, "select from t where name = '"+name+"'")
+		found := false
+		for _, key := range keys {
+			found = found || key == id
+		}
+		if !found {
+			t.Fatalf("row %d is not found by its name %s, found %v", id, name, keys)
+		}
+	}
+	if _, corrupt, err := db.Verify(); err != nil || len(corrupt) != 0 {
+		t.Errorf("Verify: corrupt pages %v, %v", corrupt, err)
+	}
+}
+
+func TestAnalyzeDistinct(t *testing.T) {
+	db, _ := openTestDB(t, Options{})
+	defer db.Close()
+	mustExec(t, db, "create table t (id int, s text(32), n integer)")
+	mustExec(t, db, "create index on t(s)")
+	mustExec(t, db, "create index on t(n)")
+	// 20 names sharing their first 8 bytes, 10 numbers sharing their upper
+	// 32 bits, and NULLs
+	for i := 1; i <= 100; i++ {
+		s, n := "'prefixed"+strconv.Itoa(i%20)+"'", strconv.Itoa(1<<40+i%10)
+		if i%25 == 0 {
+			s, n = "null", "null"
+		}
+		mustExec(t, db, "insert into t "+strconv.Itoa(i)+" "+s+" "+n)
+	}
+	mustExec(t, db, "analyze t")
+
+	table, err := findTable(db.pager, "t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.stats == nil || table.stats.rows != 100 {
+		t.Errorf("table stats %+v, want 100 rows", table.stats)
+	}
+	// every value is left in other rows, the NULLs of 25, 50, 75 and 100 are
+	// one more
+	want := map[string]uint64{"t_s_idx": 20 + 1, "t_n_idx": 10 + 1}
+	for _, idx := range table.indexes {
+		if idx.stats == nil || idx.stats.distinct != want[idx.Name] {
+			t.Errorf("index %s stats %+v, want %d distinct values", idx.Name, idx.stats, want[idx.Name])
+		}
+	}
+}
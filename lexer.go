@@ -0,0 +1,118 @@
+package scratchdb
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind uint32
+
+const (
+	// tokenWord is a keyword, a name or a value: a run of characters other
+	// than spaces and punctuation
+	tokenWord tokenKind = iota + 1
+	// tokenString is a value quoted with ' or "
+	tokenString
+	// tokenPunct is one of ( ) , ; = < <= > >=
+	tokenPunct
+	tokenEOF
+)
+
+// token is a token of a statement, text is its source and pos the byte
+// offset of its first character. The value of a string is unescaped.
+type token struct {
+	kind  tokenKind
+	text  string
+	value string
+	pos   int
+}
+
+// isKeyword reports whether the token is the keyword, keywords are case insensitive
+func (t token) isKeyword(keyword string) bool {
+	return t.kind == tokenWord && strings.EqualFold(t.text, keyword)
+}
+
+func (t token) isPunct(punct string) bool {
+	return t.kind == tokenPunct && t.text == punct
+}
+
+// isValue reports whether the token can be the value of a column
+func (t token) isValue() bool {
+	return t.kind == tokenWord || t.kind == tokenString
+}
+
+// tokenize splits the statement into tokens, the last one is always tokenEOF.
+// It returns a *SyntaxError for an unterminated string or an invalid escape.
+func tokenize(in string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(in) {
+		start := i
+		switch c := in[i]; {
+		case isSpace(c):
+			i++
+			continue
+		case (c == '<' || c == '>') && i+1 < len(in) && in[i+1] == '=':
+			i += 2
+			tokens = append(tokens, token{kind: tokenPunct, text: in[start:i], pos: start})
+		case c == '\'' || c == '"':
+			value, end, err := scanString(in, start)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+			tokens = append(tokens, token{kind: tokenString, text: in[start:end], value: value, pos: start})
+		case isPunct(c):
+			i++
+			tokens = append(tokens, token{kind: tokenPunct, text: in[start:i], pos: start})
+		default:
+			for i < len(in) && !isSpace(in[i]) && !isPunct(in[i]) && in[i] != '\'' && in[i] != '"' {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: in[start:i], value: in[start:i], pos: start})
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(in)}), nil
+}
+
+// escapes are the characters a backslash escape in a string stands for
+var escapes = map[byte]byte{
+	'\\': '\\',
+	'\'': '\'',
+	'"':  '"',
+	'n':  '\n',
+	'r':  '\r',
+	't':  '\t',
+	'0':  0,
+}
+
+// scanString unescapes the string quoted at in[start], it returns the value
+// and the offset past the closing quote
+func scanString(in string, start int) (value string, end int, err error) {
+	quote := in[start]
+	var b strings.Builder
+	for i := start + 1; i < len(in); i++ {
+		switch c := in[i]; {
+		case c == quote:
+			return b.String(), i + 1, nil
+		case c == '\\' && i+1 < len(in):
+			escaped, ok := escapes[in[i+1]]
+			if !ok {
+				return "", 0, &SyntaxError{Err: ErrSyntax, Pos: i, Near: in[i : i+2], Msg: "invalid escape sequence"}
+			}
+			b.WriteByte(escaped)
+			i++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, &SyntaxError{Err: ErrSyntax, Pos: start, Near: in[start:], Msg: fmt.Sprintf("unterminated string, expected %c", quote)}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isPunct(c byte) bool {
+	return strings.IndexByte("(),;=<>", c) >= 0
+}
@@ -0,0 +1,52 @@
+package scratchdb
+
+import "fmt"
+
+// matchLike reports whether s matches the pattern of a like condition, % in
+// the pattern matches any run of characters and _ a single character. The
+// match is case sensitive.
+func matchLike(s, pattern string) bool {
+	str, pat := []rune(s), []rune(pattern)
+	si, pi := 0, 0
+	// the position of the last % in the pattern and of the character it matched up to
+	starPi, starSi := -1, 0
+	for si < len(str) {
+		switch {
+		case pi < len(pat) && pat[pi] == '%':
+			starPi, starSi = pi, si
+			pi++
+		case pi < len(pat) && (pat[pi] == '_' || pat[pi] == str[si]):
+			si++
+			pi++
+		case starPi >= 0:
+			// let the last % match one more character
+			starSi++
+			si, pi = starSi, starPi+1
+		default:
+			return false
+		}
+	}
+	for pi < len(pat) && pat[pi] == '%' {
+		pi++
+	}
+	return pi == len(pat)
+}
+
+// likePrefix returns the text before the first wildcard of the pattern,
+// every matching value starts with it
+func likePrefix(pattern string) string {
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '%' || pattern[i] == '_' {
+			return pattern[:i]
+		}
+	}
+	return pattern
+}
+
+// checkLike returns an error when the like condition compares a column that is not text
+func checkLike(cond Condition, col Column) error {
+	if cond.Op == OperatorLike && col.Type != ColumnTypeText {
+		return fmt.Errorf("%w: like needs a text column, %s is not", ErrSyntax, col.Name)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package scratchdb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// lockPath returns the path of the lock file of the database file at dbPath
+func lockPath(dbPath string) string {
+	return dbPath + "-lock"
+}
+
+// lockDB takes the lock of the database file at path, so no other process
+// opens it until the returned file is closed. The lock file holds the pid of
+// the process owning the lock and is left in place when it is released.
+func lockDB(path string) (*os.File, error) {
+	file, err := os.OpenFile(lockPath(path), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open lock file: %w", err)
+	}
+	locked, err := tryLock(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("lock db file: %w", err)
+	}
+	if !locked {
+		defer file.Close()
+		if pid, ok := lockOwner(file); ok {
+			return nil, fmt.Errorf("%w by pid %d", ErrLocked, pid)
+		}
+		return nil, ErrLocked
+	}
+
+	if err := file.Truncate(0); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("write lock file: %w", err)
+	}
+	if _, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("write lock file: %w", err)
+	}
+	return file, nil
+}
+
+// lockOwner reads the pid of the process holding the lock from the lock file
+func lockOwner(file *os.File) (int, bool) {
+	buf := make([]byte, 32)
+	n, err := file.ReadAt(buf, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(buf[:n])))
+	return pid, err == nil && pid > 0
+}
+
+// closeLock releases the lock taken by lockDB, file may be nil
+func closeLock(file *os.File) {
+	if file != nil {
+		file.Close()
+	}
+}
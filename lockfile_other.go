@@ -0,0 +1,16 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package scratchdb
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// tryLock fails, database files can't be locked on this system and opening
+// one unlocked would let two processes write it. Read-only opens don't lock.
+func tryLock(file *os.File) (bool, error) {
+	return false, fmt.Errorf("file locking is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,36 @@
+//go:build windows
+// +build windows
+
+package scratchdb
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	// errorLockViolation is returned by LockFileEx when another handle holds the range
+	errorLockViolation syscall.Errno = 33
+)
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+// tryLock takes an exclusive LockFileEx lock on the file without waiting, it
+// returns false when another open file holds it. Windows locks are mandatory,
+// so the locked byte is past the pid lockOwner reads, at offset 1<<32.
+func tryLock(file *os.File) (bool, error) {
+	overlapped := syscall.Overlapped{OffsetHigh: 1}
+	r, _, err := procLockFileEx.Call(file.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0,
+		1, 0, uintptr(unsafe.Pointer(&overlapped)))
+	if r != 0 {
+		return true, nil
+	}
+	if errors.Is(err, errorLockViolation) {
+		return false, nil
+	}
+	return false, err
+}
@@ -0,0 +1,98 @@
+package scratchdb
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type LogLevel int32
+
+const (
+	LogLevelDebug LogLevel = iota + 1
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+	// LogLevelOff disables logging
+	LogLevelOff
+)
+
+var logLevelNames = map[LogLevel]string{
+	LogLevelDebug: "debug",
+	LogLevelInfo:  "info",
+	LogLevelWarn:  "warn",
+	LogLevelError: "error",
+	LogLevelOff:   "off",
+}
+
+func (l LogLevel) String() string {
+	if name, ok := logLevelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("LogLevel(%d)", int32(l))
+}
+
+// ParseLogLevel returns the level named debug, info, warn, error or off
+func ParseLogLevel(name string) (LogLevel, error) {
+	for level, levelName := range logLevelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q, expected debug, info, warn, error or off", name)
+}
+
+// Logger writes the messages at or above its level to out, one line each.
+// It is safe for concurrent use, and a nil *Logger discards everything.
+type Logger struct {
+	mu  sync.Mutex
+	out io.Writer
+	// level is read without mu so disabled levels cost a single atomic load
+	level int32
+}
+
+func NewLogger(out io.Writer, level LogLevel) *Logger {
+	return &Logger{out: out, level: int32(level)}
+}
+
+// SetLevel changes the lowest level written
+func (l *Logger) SetLevel(level LogLevel) {
+	atomic.StoreInt32(&l.level, int32(level))
+}
+
+func (l *Logger) Level() LogLevel {
+	if l == nil {
+		return LogLevelOff
+	}
+	return LogLevel(atomic.LoadInt32(&l.level))
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.logf(LogLevelDebug, format, args...)
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.logf(LogLevelInfo, format, args...)
+}
+
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.logf(LogLevelWarn, format, args...)
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.logf(LogLevelError, format, args...)
+}
+
+func (l *Logger) logf(level LogLevel, format string, args ...interface{}) {
+	if level < l.Level() || l.Level() == LogLevelOff {
+		return
+	}
+
+	line := fmt.Sprintf("%s %-5s %s\n", time.Now().Format("2006-01-02T15:04:05.000Z07:00"), strings.ToUpper(level.String()), fmt.Sprintf(format, args...))
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.out, line)
+}
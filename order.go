@@ -0,0 +1,304 @@
+package scratchdb
+
+import (
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// DefaultMemoryLimit is the bytes of rows a select may sort when
+// Options.MemoryLimit is not set
+const DefaultMemoryLimit int64 = 64 << 20 // 64MB
+
+// orderRows sorts the selected rows by the order by column, ties are broken
+// by the primary key. Rows scanned in key order are only reversed
+// to order them by the primary key, other orders are sorted in memory up to
+// the memory limit.
+func orderRows(table *Table, stmt *Statement, rows []Row, keyOrder bool) ([]Row, error) {
+	if stmt.OrderBy == "" {
+		return rows, nil
+	}
+	column, ok := table.schema.columnIndex(stmt.OrderBy)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchColumn, stmt.OrderBy)
+	}
+
+	if column == 0 && keyOrder {
+		if stmt.Desc {
+			for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+				rows[i], rows[j] = rows[j], rows[i]
+			}
+		}
+		return rows, nil
+	}
+
+	size := int64(0)
+	for _, row := range rows {
+		size += int64(table.schema.recordSize(row))
+	}
+	if size > table.pager.memoryLimit {
+		return nil, fmt.Errorf("%w: sorting %d bytes of rows, the limit is %d", ErrMemoryLimit, size, table.pager.memoryLimit)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		cmp := compareValues(rows[i][column], rows[j][column])
+		if cmp == 0 {
+			cmp = compareValues(rows[i][0], rows[j][0])
+		}
+		if stmt.Desc {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+	return rows, nil
+}
+
+// A sorter sorts rows that may not fit in memory, the groups of a grouped
+// select. The rows are buffered until they outgrow the memory limit, the
+// buffer is then sorted and written to a temporary file as a sorted run. The
+// runs and the last buffer are merged once every row is added.
+type sorter struct {
+	// compare returns -1, 0 or 1 when a sorts before, like or after b
+	compare func(a, b Row) int
+	limit   int64
+	rows    []Row
+	// memory is the estimated size of the buffered rows
+	memory int64
+	runs   []*spillFile
+}
+
+func newSorter(limit int64, compare func(a, b Row) int) *sorter {
+	return &sorter{compare: compare, limit: limit}
+}
+
+// add buffers the row, the buffer is written as a run once it is full
+func (s *sorter) add(row Row) error {
+	s.rows = append(s.rows, row)
+	s.memory += int64(len(encodeEntry(row)))
+	if s.memory <= s.limit {
+		return nil
+	}
+
+	s.sort()
+	run, err := createSpillFile(encodeEntry, decodeEntry)
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, run)
+	for _, row := range s.rows {
+		if err := run.write(row); err != nil {
+			return err
+		}
+	}
+	s.rows, s.memory = nil, 0
+	return nil
+}
+
+func (s *sorter) sort() {
+	sort.SliceStable(s.rows, func(i, j int) bool { return s.compare(s.rows[i], s.rows[j]) < 0 })
+}
+
+// results calls emit with the rows in order until it returns false
+func (s *sorter) results(ctx context.Context, emit func(Row) bool) error {
+	s.sort()
+	merge := &mergeHeap{compare: s.compare}
+	for _, run := range s.runs {
+		rd, err := run.reader()
+		if err != nil {
+			return err
+		}
+		if err := merge.push(rd.next); err != nil {
+			return err
+		}
+	}
+	buffered := s.rows
+	err := merge.push(func() (Row, error) {
+		if len(buffered) == 0 {
+			return nil, io.EOF
+		}
+		row := buffered[0]
+		buffered = buffered[1:]
+		return row, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for merge.Len() > 0 {
+		if ctx.Err() != nil {
+			return ErrCancelled
+		}
+		src := merge.sources[0]
+		if !emit(src.row) {
+			return nil
+		}
+		row, err := src.next()
+		if err == io.EOF {
+			heap.Pop(merge)
+			continue
+		} else if err != nil {
+			return err
+		}
+		src.row = row
+		heap.Fix(merge, 0)
+	}
+	return nil
+}
+
+// close removes the files of the runs
+func (s *sorter) close() {
+	for _, run := range s.runs {
+		run.close()
+	}
+}
+
+// mergeSource is a sorted run being merged, row is its smallest row left
+type mergeSource struct {
+	next func() (Row, error)
+	row  Row
+}
+
+// mergeHeap is a heap of the runs being merged by their smallest row
+type mergeHeap struct {
+	compare func(a, b Row) int
+	sources []*mergeSource
+}
+
+// push adds the run to the heap unless it is empty
+func (h *mergeHeap) push(next func() (Row, error)) error {
+	row, err := next()
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	heap.Push(h, &mergeSource{next: next, row: row})
+	return nil
+}
+
+func (h *mergeHeap) Len() int { return len(h.sources) }
+
+func (h *mergeHeap) Less(i, j int) bool {
+	return h.compare(h.sources[i].row, h.sources[j].row) < 0
+}
+
+func (h *mergeHeap) Swap(i, j int) { h.sources[i], h.sources[j] = h.sources[j], h.sources[i] }
+
+func (h *mergeHeap) Push(x interface{}) { h.sources = append(h.sources, x.(*mergeSource)) }
+
+func (h *mergeHeap) Pop() interface{} {
+	last := h.sources[len(h.sources)-1]
+	h.sources = h.sources[:len(h.sources)-1]
+	return last
+}
+
+// The values of an entry of a sorter are written after a byte telling their
+// type, as they are values of columns or results of aggregates
+const (
+	entryNull byte = iota
+	entryUint32
+	entryUint64
+	entryInt64
+	entryFloat64
+	entryBool
+	entryString
+	entryBytes
+	entryTime
+)
+
+// encodeEntry returns the values of the row with their types
+func encodeEntry(row Row) []byte {
+	var buf []byte
+	for _, value := range row {
+		switch v := value.(type) {
+		case nil:
+			buf = append(buf, entryNull)
+		case uint32:
+			buf = appendUint32(append(buf, entryUint32), v)
+		case uint64:
+			buf = appendUint64(append(buf, entryUint64), v)
+		case int64:
+			buf = appendUint64(append(buf, entryInt64), uint64(v))
+		case float64:
+			buf = appendUint64(append(buf, entryFloat64), math.Float64bits(v))
+		case bool:
+			b := byte(0)
+			if v {
+				b = 1
+			}
+			buf = append(buf, entryBool, b)
+		case string:
+			buf = appendUint32(append(buf, entryString), uint32(len(v)))
+			buf = append(buf, v...)
+		case []byte:
+			buf = appendUint32(append(buf, entryBytes), uint32(len(v)))
+			buf = append(buf, v...)
+		case time.Time:
+			buf = appendUint64(append(buf, entryTime), uint64(v.UnixMicro()))
+		default:
+			panic(fmt.Sprintf("can't encode a value of type %T", value))
+		}
+	}
+	return buf
+}
+
+// decodeEntry returns the values encodeEntry wrote, copied out of the record
+func decodeEntry(record []byte) (Row, error) {
+	var row Row
+	size := len(record)
+	corrupt := func() (Row, error) {
+		return nil, fmt.Errorf("entry of %d bytes is corrupt", size)
+	}
+	for len(record) > 0 {
+		tag := record[0]
+		record = record[1:]
+		n := 0
+		switch tag {
+		case entryNull:
+		case entryUint32:
+			n = 4
+		case entryUint64, entryInt64, entryFloat64, entryTime:
+			n = 8
+		case entryBool:
+			n = 1
+		case entryString, entryBytes:
+			if len(record) < 4 {
+				return corrupt()
+			}
+			n = 4 + int(binary.BigEndian.Uint32(record))
+		default:
+			return corrupt()
+		}
+		if len(record) < n {
+			return corrupt()
+		}
+		b := record[:n]
+		record = record[n:]
+
+		switch tag {
+		case entryNull:
+			row = append(row, nil)
+		case entryUint32:
+			row = append(row, binary.BigEndian.Uint32(b))
+		case entryUint64:
+			row = append(row, binary.BigEndian.Uint64(b))
+		case entryInt64:
+			row = append(row, int64(binary.BigEndian.Uint64(b)))
+		case entryFloat64:
+			row = append(row, math.Float64frombits(binary.BigEndian.Uint64(b)))
+		case entryBool:
+			row = append(row, b[0] == 1)
+		case entryString:
+			row = append(row, string(b[4:]))
+		case entryBytes:
+			row = append(row, append([]byte{}, b[4:]...))
+		case entryTime:
+			row = append(row, time.UnixMicro(int64(binary.BigEndian.Uint64(b))).UTC())
+		}
+	}
+	return row, nil
+}
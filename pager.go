@@ -0,0 +1,546 @@
+package scratchdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// Pager caches the pages of the database file in memory. Changed pages are
+// tracked as dirty until they are committed to the write-ahead log, and are
+// never evicted from the cache before that.
+type Pager struct {
+	// mu guards the files, filePages and the log, which the background
+	// flusher checkpoints while statements are running
+	mu       sync.Mutex
+	file     *os.File
+	pageSize uint32
+	// readOnly pagers fail to change pages with ErrReadOnly
+	readOnly bool
+	log      *Logger
+	// memoryLimit is Options.MemoryLimit, kept with the pager as every table shares it
+	memoryLimit int64
+	// archiveDir is Options.ArchiveDir, the log is copied there before each checkpoint
+	archiveDir string
+	// filePages is the number of pages in the database file
+	filePages uint32
+	numPages  uint32
+	cache     *pageCache
+	wal       *WAL
+	// dirty are the pages changed since the last commit
+	dirty map[uint32]bool
+	// committedNumPages is numPages as of the last commit, restored on rollback
+	committedNumPages uint32
+	// undo holds the images the pages changed by the running statement had
+	// before it, it is nil when the statement doesn't need to be undone on its own
+	undo         map[uint32][]byte
+	undoNumPages uint32
+	// cacheMu guards the cache, numPages and pagesRead in getPage, the only
+	// part of the pager statements reading the database together change
+	cacheMu sync.Mutex
+	// pagesRead is the number of pages read since the pager was opened
+	pagesRead uint64
+	// pagesChanged are the pages changed by the running statement, it is
+	// reset by resetStats
+	pagesChanged map[uint32]bool
+	// changes counts the pages made dirty and the file replacements, the
+	// cursors returned by the DB compare it to know they are stale
+	changes uint64
+}
+
+func openPager(path string, opts Options) (*Pager, error) {
+	flag := os.O_RDWR | os.O_CREATE
+	if opts.ReadOnly {
+		flag = os.O_RDONLY
+	}
+	file, err := os.OpenFile(path, flag, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open db file: %w", err)
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("stat db file: %w", err)
+	}
+
+	pageSize, err := databasePageSize(file, stat.Size(), walPath(path), opts.PageSize)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	filePages := uint32(stat.Size() / int64(pageSize))
+
+	wal, err := openWAL(walPath(path), pageSize, opts.ReadOnly)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if stat.Size()%int64(pageSize) != 0 {
+		// a crash while a checkpoint extends the file can leave its last
+		// page partly written, the log still holds the page and the next
+		// checkpoint writes it whole
+		if _, ok := wal.frames[filePages]; !ok {
+			if wal.file != nil {
+				wal.file.Close()
+			}
+			file.Close()
+			return nil, fmt.Errorf("%w: size is not a whole number of pages", ErrNotADatabase)
+		}
+		filePages++
+	}
+
+	numPages := filePages
+	if wal.numPages > numPages {
+		numPages = wal.numPages
+	}
+	opts.Logger.Infof("open %s: %d pages of %d bytes, %d frames in the log", path, numPages, pageSize, wal.numFrames())
+	return &Pager{
+		file:              file,
+		pageSize:          pageSize,
+		readOnly:          opts.ReadOnly,
+		log:               opts.Logger,
+		memoryLimit:       opts.MemoryLimit,
+		archiveDir:        opts.ArchiveDir,
+		filePages:         filePages,
+		numPages:          numPages,
+		cache:             newPageCache(opts.CacheSize),
+		wal:               wal,
+		dirty:             map[uint32]bool{},
+		committedNumPages: numPages,
+		pagesChanged:      map[uint32]bool{},
+	}, nil
+}
+
+// databasePageSize returns the page size of the database: the one in the file
+// header, or in the log header while the file was never checkpointed, or the
+// requested size for a new database. A requested size of 0 is DefaultPageSize.
+func databasePageSize(file *os.File, fileSize int64, walPath string, requested uint32) (uint32, error) {
+	var pageSize uint32
+	if fileSize > 0 {
+		header := make([]byte, FileHeaderSize)
+		if _, err := file.ReadAt(header, 0); err != nil || string(header[FileMagicOffset:FileMagicOffset+FileMagicSize]) != FileMagic {
+			return 0, ErrNotADatabase
+		}
+		// checked before reading any page, older versions have no checksums
+		if version := binary.BigEndian.Uint32(header[FileVersionOffset:]); version != FileFormatVersion && version != upgradableVersion {
+			return 0, fmt.Errorf("%w: %d, expected %d", ErrUnsupportedVersion, version, FileFormatVersion)
+		}
+		pageSize = binary.BigEndian.Uint32(header[FilePageSizeOffset:])
+	} else if walPageSize, ok := walPageSize(walPath); ok {
+		pageSize = walPageSize
+	}
+
+	switch {
+	case pageSize == 0 && requested == 0:
+		return DefaultPageSize, nil
+	case pageSize == 0:
+		pageSize = requested
+	case requested != 0 && requested != pageSize:
+		return 0, fmt.Errorf("%w: the database page size is %d, not %d", ErrInvalidPageSize, pageSize, requested)
+	}
+	if !validPageSize(pageSize) {
+		return 0, fmt.Errorf("%w: %d", ErrInvalidPageSize, pageSize)
+	}
+	return pageSize, nil
+}
+
+// validPageSize reports whether the page size is a power of two between MinPageSize and MaxPageSize
+func validPageSize(pageSize uint32) bool {
+	return pageSize >= MinPageSize && pageSize <= MaxPageSize && pageSize&(pageSize-1) == 0
+}
+
+// getPage returns the cached page without its checksum trailer, reading it
+// from the log or the file on a cache miss. Pages past the end of the file
+// are allocated empty.
+func (p *Pager) getPage(pageNum uint32) ([]byte, error) {
+	if pageNum >= TableMaxPages {
+		return nil, fmt.Errorf("page number out of bounds: %d >= %d", pageNum, TableMaxPages)
+	}
+
+	p.cacheMu.Lock()
+	defer p.cacheMu.Unlock()
+	usable := PageUsableSize(p.pageSize)
+	if page := p.cache.get(pageNum); page != nil {
+		return page[:usable:usable], nil
+	}
+
+	page := make([]byte, p.pageSize)
+	if err := p.readPage(pageNum, page); err != nil {
+		return nil, err
+	}
+	p.pagesRead++
+
+	// clean pages can always be read again from the log or the file
+	p.cache.evict(func(pageNum uint32) bool { return p.dirty[pageNum] })
+	p.cache.put(pageNum, page)
+	if pageNum >= p.numPages {
+		p.numPages = pageNum + 1
+	}
+	return page[:usable:usable], nil
+}
+
+// readPage reads the committed image of the page from the log or the file,
+// it fails with ErrChecksum when the page is corrupt
+func (p *Pager) readPage(pageNum uint32, page []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stored, err := p.readStoredPage(pageNum, page)
+	if err != nil {
+		return err
+	}
+	if stored && !validPageChecksum(page) {
+		return fmt.Errorf("%w: page %d", ErrChecksum, pageNum)
+	}
+	return nil
+}
+
+// readStoredPage reads the committed image of the page from the log or the
+// file, stored is false for a page in neither, which is left empty. It must
+// be called with mu held.
+func (p *Pager) readStoredPage(pageNum uint32, page []byte) (stored bool, err error) {
+	inWAL, err := p.wal.readPage(pageNum, page)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case inWAL:
+		p.log.Debugf("read page %d from the log", pageNum)
+	case pageNum < p.filePages:
+		p.log.Debugf("read page %d from the file", pageNum)
+		_, err := p.file.ReadAt(page, int64(pageNum)*int64(p.pageSize))
+		if err != nil && err != io.EOF {
+			return false, fmt.Errorf("read page %d: %w", pageNum, err)
+		}
+	default:
+		p.log.Debugf("allocate page %d", pageNum)
+		return false, nil
+	}
+	return true, nil
+}
+
+// verify checks the checksums of the committed pages, see DB.Verify
+func (p *Pager) verify() (pages uint32, corrupt []uint32, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	page := make([]byte, p.pageSize)
+	for pageNum := uint32(0); pageNum < p.committedNumPages; pageNum++ {
+		stored, err := p.readStoredPage(pageNum, page)
+		if err != nil {
+			return pages, corrupt, err
+		}
+		if !stored || !validPageChecksum(page) {
+			corrupt = append(corrupt, pageNum)
+		}
+		pages++
+	}
+	return pages, corrupt, nil
+}
+
+func (p *Pager) getNode(pageNum uint32) (node, error) {
+	page, err := p.getPage(pageNum)
+	return node(page), err
+}
+
+// getDirtyNode returns the node for a page that is about to be changed,
+// or ErrReadOnly
+func (p *Pager) getDirtyNode(pageNum uint32) (node, error) {
+	if p.readOnly {
+		return nil, ErrReadOnly
+	}
+	n, err := p.getNode(pageNum)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.undo[pageNum]; p.undo != nil && !ok {
+		p.undo[pageNum] = append([]byte(nil), n...)
+	}
+	p.dirty[pageNum] = true
+	p.pagesChanged[pageNum] = true
+	p.changes++
+	return n, nil
+}
+
+// resetStats starts counting the pages changed by a statement
+func (p *Pager) resetStats() {
+	p.pagesChanged = map[uint32]bool{}
+}
+
+// readCount returns the number of pages read so far, a statement reads the
+// difference between its end and its start
+func (p *Pager) readCount() uint64 {
+	p.cacheMu.Lock()
+	defer p.cacheMu.Unlock()
+	return p.pagesRead
+}
+
+// beginStatement starts recording the changes of a statement inside a
+// transaction, so they can be undone without discarding the whole transaction
+func (p *Pager) beginStatement() {
+	p.undo = map[uint32][]byte{}
+	p.undoNumPages = p.numPages
+}
+
+// endStatement keeps the changes of the statement
+func (p *Pager) endStatement() {
+	p.undo = nil
+}
+
+// rollbackStatement restores the pages changed since beginStatement, and
+// drops the pages it allocated past the end so they are allocated empty again
+func (p *Pager) rollbackStatement() {
+	for pageNum := p.undoNumPages; pageNum < p.numPages; pageNum++ {
+		p.cache.remove(pageNum)
+		delete(p.dirty, pageNum)
+		delete(p.pagesChanged, pageNum)
+		delete(p.undo, pageNum)
+	}
+	for pageNum, page := range p.undo {
+		copy(p.cache.get(pageNum), page)
+	}
+	p.numPages = p.undoNumPages
+	p.undo = nil
+}
+
+// allocatePage returns the page number for a new page, the first page of the
+// freelist or a page past the end of the file when it is empty. Either way
+// the page is zeroed, a page past the end must be read with getDirtyNode
+// before allocating another one.
+//
+// The free pages are linked by the page number of the next one in their
+// first 4 bytes, the file header holds the first one and their number.
+func (p *Pager) allocatePage() (uint32, error) {
+	header, err := p.getPage(headerPageNum)
+	if err != nil {
+		return 0, err
+	}
+	pageNum := binary.BigEndian.Uint32(header[FileFreelistOffset:])
+	if pageNum == 0 {
+		return p.numPages, nil
+	}
+
+	page, err := p.getDirtyNode(pageNum)
+	if err != nil {
+		return 0, err
+	}
+	header, err = p.getDirtyNode(headerPageNum)
+	if err != nil {
+		return 0, err
+	}
+	binary.BigEndian.PutUint32(header[FileFreelistOffset:], binary.BigEndian.Uint32(page))
+	binary.BigEndian.PutUint32(page, 0)
+	numFree := binary.BigEndian.Uint32(header[FileFreePagesOffset:])
+	binary.BigEndian.PutUint32(header[FileFreePagesOffset:], numFree-1)
+	p.log.Debugf("reuse free page %d", pageNum)
+	return pageNum, nil
+}
+
+// freePage adds the page to the freelist, it must no longer be referenced
+func (p *Pager) freePage(pageNum uint32) error {
+	page, err := p.getDirtyNode(pageNum)
+	if err != nil {
+		return err
+	}
+	header, err := p.getDirtyNode(headerPageNum)
+	if err != nil {
+		return err
+	}
+	for i := range page {
+		page[i] = 0
+	}
+	binary.BigEndian.PutUint32(page, binary.BigEndian.Uint32(header[FileFreelistOffset:]))
+	binary.BigEndian.PutUint32(header[FileFreelistOffset:], pageNum)
+	numFree := binary.BigEndian.Uint32(header[FileFreePagesOffset:])
+	binary.BigEndian.PutUint32(header[FileFreePagesOffset:], numFree+1)
+	p.log.Debugf("free page %d", pageNum)
+	return nil
+}
+
+// commit makes the dirty pages durable by appending them to the log, and
+// checkpoints the log once it grows past WALCheckpointFrames
+func (p *Pager) commit() error {
+	if len(p.dirty) == 0 {
+		return nil
+	}
+	if err := stampCommit(p); err != nil {
+		return err
+	}
+
+	pages := make(map[uint32][]byte, len(p.dirty))
+	for pageNum := range p.dirty {
+		page := p.cache.get(pageNum)
+		setPageChecksum(page)
+		pages[pageNum] = page
+	}
+	p.mu.Lock()
+	err := p.wal.commit(pages, p.numPages)
+	full := p.wal.numFrames() >= WALCheckpointFrames
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	p.log.Debugf("commit %d pages to the log", len(pages))
+	p.dirty = map[uint32]bool{}
+	p.committedNumPages = p.numPages
+
+	if full {
+		return p.checkpoint()
+	}
+	return nil
+}
+
+// rollback discards the changes since the last commit,
+// the dirty pages are read again from the log or the file on next use
+func (p *Pager) rollback() {
+	if len(p.dirty) > 0 {
+		p.log.Debugf("roll back %d pages", len(p.dirty))
+	}
+	for pageNum := range p.dirty {
+		p.cache.remove(pageNum)
+	}
+	p.dirty = map[uint32]bool{}
+	p.numPages = p.committedNumPages
+	p.undo = nil
+}
+
+// checkpoint writes the committed pages of the log to the database file,
+// fsyncs it and empties the log. Uncommitted pages are left in the cache,
+// so it is safe to call while a transaction is open.
+func (p *Pager) checkpoint() error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.wal.numFrames() == 0 {
+		return nil
+	}
+	if p.archiveDir != "" {
+		if err := p.archiveLog(); err != nil {
+			return err
+		}
+	}
+
+	page := make([]byte, p.pageSize)
+	for pageNum := range p.wal.frames {
+		if err := p.flush(pageNum, page); err != nil {
+			return err
+		}
+	}
+	if err := syncFile(p.file); err != nil {
+		return fmt.Errorf("sync db file: %w", err)
+	}
+	p.log.Infof("checkpoint %d pages to the db file", len(p.wal.frames))
+	return p.wal.reset()
+}
+
+// flush writes the committed image of the page in the log to the database file,
+// page is the buffer to read it into. It must be called with mu held.
+func (p *Pager) flush(pageNum uint32, page []byte) error {
+	if _, err := p.wal.readPage(pageNum, page); err != nil {
+		return err
+	}
+
+	_, err := p.file.WriteAt(page, int64(pageNum)*int64(p.pageSize))
+	if err != nil {
+		return fmt.Errorf("write page %d: %w", pageNum, err)
+	}
+	if pageNum >= p.filePages {
+		p.filePages = pageNum + 1
+	}
+	return nil
+}
+
+// replaceFile renames the database file at path over the database file and
+// reads from it from now on. The log must be empty and no page dirty, the
+// cached pages are dropped as they may have moved.
+func (p *Pager) replaceFile(path string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.wal.numFrames() != 0 || len(p.dirty) != 0 {
+		return fmt.Errorf("replace db file: the log is not empty")
+	}
+	dbPath := p.file.Name()
+	if err := os.Rename(path, dbPath); err != nil {
+		return fmt.Errorf("replace db file: %w", err)
+	}
+	if err := syncDir(filepath.Dir(dbPath)); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(dbPath, os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("open db file: %w", err)
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("stat db file: %w", err)
+	}
+	p.file.Close()
+	p.file = file
+	p.filePages = uint32(stat.Size() / int64(p.pageSize))
+	p.numPages = p.filePages
+	p.committedNumPages = p.filePages
+	p.cache = newPageCache(p.cache.capacity)
+	p.changes++
+	return nil
+}
+
+// syncDir fsyncs the directory, so a file renamed into it survives a crash.
+// Windows makes renames durable without it and can't flush a directory.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("open dir: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("sync dir: %w", err)
+	}
+	return nil
+}
+
+// close checkpoints the log and closes the files, uncommitted changes are
+// discarded. A read-only pager only closes the files. The files are closed
+// even when the checkpoint fails, the log is then kept to be replayed by the
+// next open and the first error is returned.
+func (p *Pager) close() error {
+	if p.readOnly {
+		p.closeFiles()
+		return nil
+	}
+
+	p.rollback()
+	err := p.checkpoint()
+	if closeErr := p.wal.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Remove(p.wal.file.Name())
+	}
+	if closeErr := p.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// closeFiles closes the files without writing anything
+func (p *Pager) closeFiles() {
+	if p.wal.file != nil {
+		p.wal.file.Close()
+	}
+	p.file.Close()
+}
@@ -0,0 +1,365 @@
+package scratchdb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openTestDB opens a new database in a temporary directory
+func openTestDB(t testing.TB, opts Options) (*DB, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := OpenWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	return db, path
+}
+
+// crash closes the files of the database without checkpointing the log or
+// rolling back, like a process killed in the middle of its work
+func crash(db *DB) {
+	db.pager.closeFiles()
+	closeLock(db.lockFile)
+}
+
+func mustExec(t testing.TB, db *DB, sql string) {
+	t.Helper()
+	if err := db.Exec(sql); err != nil {
+		t.Fatalf("%s: %v", sql, err)
+	}
+}
+
+// selectKeys returns the primary keys of the rows of the select in order
+func selectKeys(t testing.TB, db *DB, sql string) []uint32 {
+	t.Helper()
+	rows, err := db.Query(sql)
+	if err != nil {
+		t.Fatalf("%s: %v", sql, err)
+	}
+	keys := make([]uint32, len(rows))
+	for i, row := range rows {
+		keys[i] = row[0].(uint32)
+	}
+	return keys
+}
+
+func fileSize(t testing.TB, path string) int64 {
+	t.Helper()
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stat.Size()
+}
+
+func TestWALReplayAfterCrash(t *testing.T) {
+	db, path := openTestDB(t, Options{})
+	for i := 1; i <= 300; i++ {
+		mustExec(t, db, "insert "+strconv.Itoa(i)+" user"+strconv.Itoa(i)+" user"+strconv.Itoa(i)+"@example.com")
+	}
+	mustExec(t, db, "delete 7")
+	crash(db)
+
+	if size := fileSize(t, walPath(path)); size <= int64(WALHeaderSize) {
+		t.Fatalf("the log is %d bytes, the commits should still be in it", size)
+	}
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer db.Close()
+	keys := selectKeys(t, db, "select")
+	if len(keys) != 299 || keys[0] != 1 || keys[6] != 8 || keys[298] != 300 {
+		t.Errorf("selected %d rows after the crash, want the 299 committed ones", len(keys))
+	}
+	if n, err := db.RowCount(); err != nil || n != 299 {
+		t.Errorf("RowCount = %d, %v, want 299", n, err)
+	}
+	if _, corrupt, err := db.Verify(); err != nil || len(corrupt) != 0 {
+		t.Errorf("Verify: corrupt pages %v, %v", corrupt, err)
+	}
+}
+
+func TestWALReplayIgnoresTornCommit(t *testing.T) {
+	tests := []struct {
+		name string
+		// tear damages the last commit of the log of size bytes
+		tear func(t *testing.T, file *os.File, size int64)
+	}{
+		{"truncated frame", func(t *testing.T, file *os.File, size int64) {
+			if err := file.Truncate(size - 1); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"bad checksum", func(t *testing.T, file *os.File, size int64) {
+			b := make([]byte, 1)
+			if _, err := file.ReadAt(b, size-100); err != nil {
+				t.Fatal(err)
+			}
+			b[0] ^= 0xff
+			if _, err := file.WriteAt(b, size-100); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"trailing garbage", func(t *testing.T, file *os.File, size int64) {
+			// the frames of the last commit without their commit frame
+			if err := file.Truncate(size - int64(WALFrameHeaderSize+DefaultPageSize)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := file.WriteAt([]byte("garbage"), size); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, path := openTestDB(t, Options{})
+			mustExec(t, db, "insert 1 a a@example.com")
+			mustExec(t, db, "insert 2 b b@example.com")
+			last := fileSize(t, walPath(path))
+			mustExec(t, db, "insert into users (3, c, 'c@example.com'), (4, d, 'd@example.com')")
+			crash(db)
+
+			file, err := os.OpenFile(walPath(path), os.O_RDWR, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.tear(t, file, fileSize(t, walPath(path)))
+			file.Close()
+
+			db, err = Open(path)
+			if err != nil {
+				t.Fatalf("reopen: %v", err)
+			}
+			defer db.Close()
+			if keys := selectKeys(t, db, "select"); len(keys) != 2 {
+				t.Errorf("selected keys %v, want the 2 rows committed before the torn commit", keys)
+			}
+			// the torn frames were truncated, new commits follow the last valid one
+			mustExec(t, db, "insert 3 c c@example.com")
+			if size := fileSize(t, walPath(path)); size <= last {
+				t.Errorf("the log is %d bytes after a new commit, want more than %d", size, last)
+			}
+		})
+	}
+}
+
+func TestCheckpointAfterCrash(t *testing.T) {
+	db, path := openTestDB(t, Options{})
+	for i := 1; i <= 50; i++ {
+		mustExec(t, db, "insert "+strconv.Itoa(i)+" u u@example.com")
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	mustExec(t, db, "delete 1")
+	crash(db)
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, err := os.Stat(walPath(path)); !os.IsNotExist(err) {
+		t.Errorf("the log is left after close: %v", err)
+	}
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer db.Close()
+	if keys := selectKeys(t, db, "select"); len(keys) != 49 || keys[0] != 2 {
+		t.Errorf("selected %d rows, want 49 from key 2", len(keys))
+	}
+}
+
+func BenchmarkGetPage(b *testing.B) {
+	db, path := openTestDB(b, Options{})
+	if err := db.Exec("insert random 2000"); err != nil {
+		b.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bm := range []struct {
+		name      string
+		cacheSize int
+	}{
+		{"cached", DefaultCacheSize},
+		// every page is read from the file
+		{"uncached", 1},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			db, err := OpenWithOptions(path, Options{CacheSize: bm.cacheSize, ReadOnly: true})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer db.Close()
+			numPages := db.pager.numPages
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := db.pager.getPage(uint32(i) % numPages); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenPagerTornLastPage(t *testing.T) {
+	pageSize := int64(DefaultPageSize)
+	// newDB writes a database of whole pages and returns its page count
+	newDB := func(t *testing.T, path string) int64 {
+		db, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mustExec(t, db, "insert 1 a a@example.com")
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return fileSize(t, path) / pageSize
+	}
+	appendBytes := func(t *testing.T, path string, n int) {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if _, err := file.Write(make([]byte, n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		// setup writes the files at path and returns the pages the database
+		// file should have once opened
+		setup   func(t *testing.T, path string) uint32
+		wantErr error
+	}{
+		{"empty file", func(t *testing.T, path string) uint32 {
+			if err := os.WriteFile(path, nil, 0600); err != nil {
+				t.Fatal(err)
+			}
+			return 0
+		}, nil},
+		{"exactly one page", func(t *testing.T, path string) uint32 {
+			newDB(t, path)
+			if err := os.Truncate(path, pageSize); err != nil {
+				t.Fatal(err)
+			}
+			return 1
+		}, nil},
+		{"partial page with a frame in the log", func(t *testing.T, path string) uint32 {
+			numPages := newDB(t, path)
+			// the new table takes a page past the end of the file, its
+			// frame stays in the log of the crashed database
+			db, err := Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mustExec(t, db, "create table t (id int)")
+			crash(db)
+			// a checkpoint extending the file crashed while writing the page
+			appendBytes(t, path, 100)
+			return uint32(numPages) + 1
+		}, nil},
+		{"partial page without a frame in the log", func(t *testing.T, path string) uint32 {
+			newDB(t, path)
+			appendBytes(t, path, 100)
+			return 0
+		}, ErrNotADatabase},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.db")
+			wantPages := tt.setup(t, path)
+			pager, err := openPager(path, Options{CacheSize: DefaultCacheSize})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("openPager error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer pager.closeFiles()
+			if pager.filePages != wantPages {
+				t.Errorf("filePages = %d, want %d", pager.filePages, wantPages)
+			}
+			for pageNum := uint32(0); pageNum < pager.numPages; pageNum++ {
+				if _, err := pager.getPage(pageNum); err != nil {
+					t.Errorf("getPage(%d): %v", pageNum, err)
+				}
+			}
+		})
+	}
+}
+
+func TestRollbackStatementAllocatedPages(t *testing.T) {
+	// rows returns an insert of the keys from to to with one statement
+	rows := func(from, to int) string {
+		var values []string
+		for i := from; i <= to; i++ {
+			n := strconv.Itoa(i)
+			values = append(values, "("+n+", user"+n+", 'user"+n+"@example.com')")
+		}
+		return "insert " + strings.Join(values, ", ")
+	}
+	tests := []struct {
+		name string
+		// fail runs a statement of tx allocating pages and failing
+		fail func(t *testing.T, tx *Tx)
+	}{
+		{"duplicate key", func(t *testing.T, tx *Tx) {
+			// the last row repeats the first
+			if err := tx.Exec(rows(101, 1000) + ", (101, again, 'again@example.com')"); !errors.Is(err, ErrDuplicateKey) {
+				t.Fatalf("insert error = %v, want ErrDuplicateKey", err)
+			}
+		}},
+		{"cancelled", func(t *testing.T, tx *Tx) {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Millisecond)
+			defer cancel()
+			if err := tx.ExecContext(ctx, "insert random 100000"); !errors.Is(err, ErrCancelled) {
+				t.Fatalf("insert error = %v, want ErrCancelled", err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := openTestDB(t, Options{PageSize: MinPageSize})
+			defer db.Close()
+			mustExec(t, db, rows(1, 100))
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.fail(t, tx)
+			// the pages of the failed statement are allocated again
+			if err := tx.Exec(rows(101, 2000)); err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			if n, err := db.RowCount(); err != nil || n != 2000 {
+				t.Errorf("RowCount = %d, %v, want 2000", n, err)
+			}
+			if keys := selectKeys(t, db, "select"); len(keys) != 2000 || keys[1999] != 2000 {
+				t.Errorf("selected %d rows, want 2000", len(keys))
+			}
+			if _, corrupt, err := db.Verify(); err != nil || len(corrupt) != 0 {
+				t.Errorf("Verify: corrupt pages %v, %v", corrupt, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,629 @@
+package scratchdb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SyntaxError is returned by Prepare for a statement that can't be parsed,
+// it wraps ErrUnrecognizedStatement, ErrSyntax, ErrNegativeNumber or
+// ErrNumberOutOfRange
+type SyntaxError struct {
+	Err error
+	// Pos is the byte offset of the offending token in the statement
+	Pos int
+	// Near is the offending token, empty at the end of the statement
+	Near string
+	// Msg tells what was expected instead, it may be empty
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	msg := fmt.Sprintf("%v at end of statement", e.Err)
+	if e.Near != "" {
+		msg = fmt.Sprintf("%v at column %d near %q", e.Err, e.Pos+1, e.Near)
+	}
+	if e.Msg != "" {
+		msg += ": " + e.Msg
+	}
+	return msg
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+// Prepare parses the statement, it returns a *SyntaxError when the input is
+// not a valid statement
+func Prepare(sql string) (Statement, error) {
+	tokens, err := tokenize(sql)
+	if err != nil {
+		return Statement{}, err
+	}
+	p := &parser{tokens: tokens}
+	return p.parseStatement()
+}
+
+// parser is a recursive descent parser over the tokens of a statement,
+// each parse method consumes the tokens of one grammar rule
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+// next consumes the next token, the last token tokenEOF is never consumed
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// errorf returns a SyntaxError wrapping err for the token
+func (p *parser) errorf(t token, err error, format string, args ...interface{}) error {
+	return &SyntaxError{Err: err, Pos: t.pos, Near: t.text, Msg: fmt.Sprintf(format, args...)}
+}
+
+// acceptKeyword consumes the next token if it is the keyword
+func (p *parser) acceptKeyword(keyword string) bool {
+	if p.peek().isKeyword(keyword) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) acceptPunct(punct string) bool {
+	if p.peek().isPunct(punct) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(keyword string) error {
+	if t := p.next(); !t.isKeyword(keyword) {
+		return p.errorf(t, ErrSyntax, "expected %s", keyword)
+	}
+	return nil
+}
+
+func (p *parser) expectPunct(punct string) error {
+	if t := p.next(); !t.isPunct(punct) {
+		return p.errorf(t, ErrSyntax, "expected %s", punct)
+	}
+	return nil
+}
+
+// expectName consumes a table or column name, what is the kind of name for the error
+func (p *parser) expectName(what string) (string, error) {
+	t := p.next()
+	if t.kind != tokenWord || !isIdentifier(t.text) {
+		return "", p.errorf(t, ErrSyntax, "expected %s", what)
+	}
+	return t.text, nil
+}
+
+// expectInt consumes a number that fits an int column
+func (p *parser) expectInt(what string) (uint32, error) {
+	t := p.next()
+	if t.kind != tokenWord {
+		return 0, p.errorf(t, ErrSyntax, "expected %s", what)
+	}
+	value, res := parseInt(t.text)
+	switch res {
+	case PrepareResultSuccess:
+		return value, nil
+	case PrepareResultSyntaxError:
+		return 0, p.errorf(t, ErrSyntax, "expected %s", what)
+	default:
+		return 0, p.errorf(t, prepareResultError(res), "")
+	}
+}
+
+// expectEnd consumes an optional `;` ending the statement
+func (p *parser) expectEnd() error {
+	p.acceptPunct(";")
+	if t := p.peek(); t.kind != tokenEOF {
+		return p.errorf(t, ErrSyntax, "expected end of statement")
+	}
+	return nil
+}
+
+func (p *parser) parseStatement() (Statement, error) {
+	stmt := Statement{}
+	var err error
+	switch t := p.next(); {
+	case t.isKeyword("begin"):
+		stmt.Kind = StatementKindBegin
+	case t.isKeyword("commit"):
+		stmt.Kind = StatementKindCommit
+	case t.isKeyword("rollback"):
+		stmt.Kind = StatementKindRollback
+	case t.isKeyword("vacuum"):
+		stmt.Kind = StatementKindVacuum
+	case t.isKeyword("insert"):
+		err = p.parseInsert(&stmt)
+	case t.isKeyword("update"):
+		err = p.parseUpdate(&stmt)
+	case t.isKeyword("delete"):
+		err = p.parseDelete(&stmt)
+	case t.isKeyword("select"):
+		err = p.parseSelect(&stmt)
+	case t.isKeyword("create"):
+		err = p.parseCreate(&stmt)
+	case t.isKeyword("explain"):
+		err = p.parseExplain(&stmt)
+	case t.isKeyword("analyze"):
+		p.parseAnalyze(&stmt)
+	default:
+		return stmt, p.errorf(t, ErrUnrecognizedStatement, "")
+	}
+	if err != nil {
+		return stmt, err
+	}
+	return stmt, p.expectEnd()
+}
+
+// parseTable parses an optional `<keyword> <table>`
+func (p *parser) parseTable(keyword string, stmt *Statement) error {
+	if !p.acceptKeyword(keyword) {
+		return nil
+	}
+	var err error
+	stmt.Table, err = p.expectName("a table name")
+	return err
+}
+
+// parseInsert parses `insert random <N> [into <table>]`, `insert [into
+// <table>] <values...>` or `insert [into <table>] (<values,...>), ...`
+func (p *parser) parseInsert(stmt *Statement) error {
+	if p.acceptKeyword("random") {
+		stmt.Kind = StatementKindInsertRandom
+		var err error
+		if stmt.NumRandomRows, err = p.expectInt("the number of rows"); err != nil {
+			return err
+		}
+		return p.parseTable("into", stmt)
+	}
+
+	stmt.Kind = StatementKindInsert
+	if err := p.parseTable("into", stmt); err != nil {
+		return err
+	}
+	if p.peek().isPunct("(") {
+		return p.parseRows(stmt)
+	}
+	return p.parseValues(stmt)
+}
+
+// parseRows parses the rows of a multi-row insert, each a parenthesized list
+// of values separated by commas
+func (p *parser) parseRows(stmt *Statement) error {
+	for {
+		if err := p.expectPunct("("); err != nil {
+			return err
+		}
+		var values []Value
+		for {
+			t := p.next()
+			if !t.isValue() {
+				return p.errorf(t, ErrSyntax, "expected a value")
+			}
+			values = append(values, tokenValue(t))
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return err
+		}
+		stmt.Rows = append(stmt.Rows, values)
+		if !p.acceptPunct(",") {
+			return nil
+		}
+	}
+}
+
+// parseUpdate parses `update [<table>] <id> <values...>`, it replaces the row
+// with the id. Ids are numbers and table names can't be, so the table is told
+// apart by its name.
+func (p *parser) parseUpdate(stmt *Statement) error {
+	stmt.Kind = StatementKindUpdate
+	if t := p.peek(); t.kind == tokenWord && isIdentifier(t.text) {
+		stmt.Table = p.next().text
+	}
+	return p.parseValues(stmt)
+}
+
+// parseValues parses the values of a row up to the end of the statement,
+// values are words or quoted strings. The unquoted word null is NULL, 'null'
+// is text.
+func (p *parser) parseValues(stmt *Statement) error {
+	for p.peek().isValue() {
+		stmt.Values = append(stmt.Values, tokenValue(p.next()))
+	}
+	if len(stmt.Values) == 0 {
+		return p.errorf(p.peek(), ErrSyntax, "expected values")
+	}
+	return nil
+}
+
+// tokenValue returns the value a value token stands for
+func tokenValue(t token) Value {
+	if t.isKeyword("null") {
+		return Value{Null: true}
+	}
+	return Value{Text: t.value}
+}
+
+// parseDelete parses `delete [from <table>] <id>`
+func (p *parser) parseDelete(stmt *Statement) error {
+	stmt.Kind = StatementKindDelete
+	if err := p.parseTable("from", stmt); err != nil {
+		return err
+	}
+	idToken := p.peek()
+	if _, err := p.expectInt("an id"); err != nil {
+		return err
+	}
+	stmt.Where = []Condition{{Op: OperatorEqual, Value: idToken.value}}
+	return nil
+}
+
+// parseSelect parses `select [<column>|<aggregate>, ...] [from <table>] [where
+// <column> <op> <value> [and ...]] [group by <column>, ...] [having
+// <column>|<aggregate> <op> <value> [and ...]] [order by <column> [asc|desc]]
+// [limit N] [offset M]`. `select *` is the same as no select list.
+func (p *parser) parseSelect(stmt *Statement) error {
+	stmt.Kind = StatementKindSelect
+	if err := p.parseSelectList(stmt); err != nil {
+		return err
+	}
+	if err := p.parseTable("from", stmt); err != nil {
+		return err
+	}
+	var err error
+	if p.acceptKeyword("where") {
+		if stmt.Where, err = p.parseConditions(false); err != nil {
+			return err
+		}
+	}
+	if p.acceptKeyword("group") {
+		if err := p.expectKeyword("by"); err != nil {
+			return err
+		}
+		for {
+			name, err := p.expectName("a column name")
+			if err != nil {
+				return err
+			}
+			stmt.GroupBy = append(stmt.GroupBy, name)
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+	}
+	if p.acceptKeyword("having") {
+		if stmt.Having, err = p.parseConditions(true); err != nil {
+			return err
+		}
+	}
+	if err := p.parseOrderBy(stmt); err != nil {
+		return err
+	}
+	return p.parseLimit(stmt)
+}
+
+// parseExplain parses `explain select ...`
+func (p *parser) parseExplain(stmt *Statement) error {
+	if err := p.expectKeyword("select"); err != nil {
+		return err
+	}
+	if err := p.parseSelect(stmt); err != nil {
+		return err
+	}
+	stmt.Kind = StatementKindExplain
+	return nil
+}
+
+// parseAnalyze parses `analyze [<table>]`, without a table every table is analyzed
+func (p *parser) parseAnalyze(stmt *Statement) {
+	stmt.Kind = StatementKindAnalyze
+	if t := p.peek(); t.kind == tokenWord && isIdentifier(t.text) {
+		stmt.Table = p.next().text
+	}
+}
+
+// selectClauses are the keywords that can follow select, a select list
+// can't start with them
+var selectClauses = []string{"from", "where", "group", "having", "order", "limit", "offset"}
+
+// parseSelectList parses the optional columns and aggregates after select
+func (p *parser) parseSelectList(stmt *Statement) error {
+	t := p.peek()
+	if t.kind == tokenEOF || t.isPunct(";") {
+		return nil
+	}
+	for _, keyword := range selectClauses {
+		if t.isKeyword(keyword) {
+			return nil
+		}
+	}
+	if t.kind == tokenWord && t.text == "*" {
+		p.next()
+		return nil
+	}
+
+	for {
+		sel, err := p.parseSelectColumn()
+		if err != nil {
+			return err
+		}
+		stmt.Columns = append(stmt.Columns, sel)
+		if !p.acceptPunct(",") {
+			return nil
+		}
+	}
+}
+
+// parseSelectColumn parses a column name, `count(*)` or `<func>(<column>)`
+func (p *parser) parseSelectColumn() (SelectColumn, error) {
+	t := p.peek()
+	f, ok := parseAggregateFunc(strings.ToLower(t.text))
+	if t.kind != tokenWord || !ok || !p.tokens[p.pos+1].isPunct("(") {
+		name, err := p.expectName("a column name or an aggregate")
+		return SelectColumn{Column: name}, err
+	}
+
+	p.next()
+	p.next()
+	sel := SelectColumn{Func: f}
+	if t := p.peek(); f == AggregateCount && t.kind == tokenWord && t.text == "*" {
+		p.next()
+	} else {
+		var err error
+		if sel.Column, err = p.expectName("a column name"); err != nil {
+			return SelectColumn{}, err
+		}
+	}
+	return sel, p.expectPunct(")")
+}
+
+// parseConditions parses conditions joined by and, having conditions may
+// compare an aggregate
+func (p *parser) parseConditions(having bool) ([]Condition, error) {
+	var conds []Condition
+	for {
+		cond, err := p.parseCondition(having)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, cond)
+		if !p.acceptKeyword("and") {
+			return conds, nil
+		}
+	}
+}
+
+// parseOrderBy parses an optional `order by <column> [asc|desc]`
+func (p *parser) parseOrderBy(stmt *Statement) error {
+	if !p.acceptKeyword("order") {
+		return nil
+	}
+	if err := p.expectKeyword("by"); err != nil {
+		return err
+	}
+	var err error
+	if stmt.OrderBy, err = p.expectName("a column name"); err != nil {
+		return err
+	}
+	if p.acceptKeyword("desc") {
+		stmt.Desc = true
+	} else {
+		p.acceptKeyword("asc")
+	}
+	return nil
+}
+
+// parseLimit parses an optional `limit N` followed by an optional `offset M`
+func (p *parser) parseLimit(stmt *Statement) error {
+	var err error
+	if p.acceptKeyword("limit") {
+		stmt.HasLimit = true
+		if stmt.Limit, err = p.expectInt("the number of rows"); err != nil {
+			return err
+		}
+	}
+	if p.acceptKeyword("offset") {
+		if stmt.Offset, err = p.expectInt("the number of rows to skip"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseCondition parses `<column> <op> <value>`, `<column> like <pattern>` or
+// `<column> is [not] null`, the value is a word or a quoted string. A having
+// condition may compare an aggregate instead of a column.
+func (p *parser) parseCondition(having bool) (Condition, error) {
+	var cond Condition
+	if having {
+		sel, err := p.parseSelectColumn()
+		if err != nil {
+			return Condition{}, err
+		}
+		cond.Func, cond.Column = sel.Func, sel.Column
+	} else {
+		var err error
+		if cond.Column, err = p.expectName("a column name"); err != nil {
+			return Condition{}, err
+		}
+	}
+	if p.acceptKeyword("is") {
+		cond.Op = OperatorIsNull
+		if p.acceptKeyword("not") {
+			cond.Op = OperatorIsNotNull
+		}
+		return cond, p.expectKeyword("null")
+	}
+
+	t := p.next()
+	op, ok := operators[t.text]
+	if t.isKeyword("like") {
+		op, ok = OperatorLike, true
+	} else if t.kind != tokenPunct {
+		ok = false
+	}
+	if !ok {
+		return Condition{}, p.errorf(t, ErrSyntax, "expected one of = < <= > >= like is")
+	}
+	if !p.peek().isValue() {
+		return Condition{}, p.errorf(p.peek(), ErrSyntax, "expected a value")
+	}
+	cond.Op, cond.Value = op, p.next().value
+	return cond, nil
+}
+
+// parseCreate parses `create table ...` or `create index ...`
+func (p *parser) parseCreate(stmt *Statement) error {
+	switch t := p.next(); {
+	case t.isKeyword("table"):
+		return p.parseCreateTable(stmt)
+	case t.isKeyword("index"):
+		return p.parseCreateIndex(stmt)
+	default:
+		return p.errorf(t, ErrSyntax, "expected table or index")
+	}
+}
+
+// parseCreateTable parses `<name> (<column> <type> [not null], ...)` after
+// `create table`, text(N) and blob(N) hold up to N bytes
+func (p *parser) parseCreateTable(stmt *Statement) error {
+	stmt.Kind = StatementKindCreateTable
+	var err error
+	if stmt.Schema.Name, err = p.expectName("a table name"); err != nil {
+		return err
+	}
+	if err := p.expectPunct("("); err != nil {
+		return err
+	}
+	for {
+		col, err := p.parseColumn()
+		if err != nil {
+			return err
+		}
+		stmt.Schema.Columns = append(stmt.Schema.Columns, col)
+		if !p.acceptPunct(",") {
+			break
+		}
+	}
+	return p.expectPunct(")")
+}
+
+// parseLength parses the `(N)` of a text or blob type, the most bytes its values hold
+func (p *parser) parseLength(typ string) (uint32, error) {
+	if err := p.expectPunct("("); err != nil {
+		return 0, err
+	}
+	sizeToken := p.peek()
+	size, err := p.expectInt("the " + typ + " size")
+	if err != nil {
+		return 0, err
+	}
+	if size == 0 {
+		return 0, p.errorf(sizeToken, ErrSyntax, "the %s size must be at least 1", typ)
+	}
+	return size, p.expectPunct(")")
+}
+
+// parseCreateIndex parses `[<name>] on <table>(<column>)` after `create
+// index`, the name defaults to <table>_<column>_idx
+func (p *parser) parseCreateIndex(stmt *Statement) error {
+	stmt.Kind = StatementKindCreateIndex
+	var err error
+	if !p.peek().isKeyword("on") {
+		if stmt.Index.Name, err = p.expectName("an index name"); err != nil {
+			return err
+		}
+	}
+	if err := p.expectKeyword("on"); err != nil {
+		return err
+	}
+	if stmt.Index.Table, err = p.expectName("a table name"); err != nil {
+		return err
+	}
+	if err := p.expectPunct("("); err != nil {
+		return err
+	}
+	if stmt.Index.Column, err = p.expectName("a column name"); err != nil {
+		return err
+	}
+	if stmt.Index.Name == "" {
+		stmt.Index.Name = stmt.Index.Table + "_" + stmt.Index.Column + "_idx"
+	}
+	return p.expectPunct(")")
+}
+
+// parseColumn parses `<name> <type>` followed by the optional constraints `not
+// null`, `not empty` and `check email`, the type is int, integer, real,
+// boolean, timestamp, text(N) or blob(N)
+func (p *parser) parseColumn() (Column, error) {
+	name, err := p.expectName("a column name")
+	if err != nil {
+		return Column{}, err
+	}
+	col := Column{Name: name}
+
+	switch t := p.next(); {
+	case t.isKeyword("int"):
+		col.Type, col.Size = ColumnTypeInt, IntSize
+	case t.isKeyword("integer"):
+		col.Type, col.Size = ColumnTypeInteger, IntegerSize
+	case t.isKeyword("real"):
+		col.Type, col.Size = ColumnTypeReal, RealSize
+	case t.isKeyword("boolean"):
+		col.Type, col.Size = ColumnTypeBoolean, BooleanSize
+	case t.isKeyword("timestamp"):
+		col.Type, col.Size = ColumnTypeTimestamp, TimestampSize
+	case t.isKeyword("text"):
+		size, err := p.parseLength("text")
+		if err != nil {
+			return Column{}, err
+		}
+		col.Type, col.Size = ColumnTypeText, size
+	case t.isKeyword("blob"):
+		size, err := p.parseLength("blob")
+		if err != nil {
+			return Column{}, err
+		}
+		col.Type, col.Size = ColumnTypeBlob, size
+	default:
+		return Column{}, p.errorf(t, ErrSyntax, "expected int, integer, real, boolean, timestamp, text(N) or blob(N)")
+	}
+	for {
+		switch {
+		case p.acceptKeyword("not"):
+			switch t := p.next(); {
+			case t.isKeyword("null"):
+				col.NotNull = true
+			case t.isKeyword("empty"):
+				col.NotEmpty = true
+			default:
+				return Column{}, p.errorf(t, ErrSyntax, "expected null or empty")
+			}
+		case p.acceptKeyword("check"):
+			if err := p.expectKeyword("email"); err != nil {
+				return Column{}, err
+			}
+			col.CheckEmail = true
+		default:
+			return col, nil
+		}
+	}
+}
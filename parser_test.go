@@ -0,0 +1,198 @@
+package scratchdb
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPrepare(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want Statement
+	}{
+		{"insert 1 john john@example.com", Statement{Kind: StatementKindInsert,
+			Values: []Value{{Text: "1"}, {Text: "john"}, {Text: "john@example.com"}}}},
+		{"INSERT INTO orders 1 3 fragile;", Statement{Kind: StatementKindInsert, Table: "orders",
+			Values: []Value{{Text: "1"}, {Text: "3"}, {Text: "fragile"}}}},
+		{`insert 2 "say \"hi\"" 'it\'s' null 'null'`, Statement{Kind: StatementKindInsert,
+			Values: []Value{{Text: "2"}, {Text: `say "hi"`}, {Text: "it's"}, {Null: true}, {Text: "null"}}}},
+		{"insert into users (3, alice, 'a@b.c'), (4, bob, null)", Statement{Kind: StatementKindInsert, Table: "users",
+			Rows: [][]Value{{{Text: "3"}, {Text: "alice"}, {Text: "a@b.c"}}, {{Text: "4"}, {Text: "bob"}, {Null: true}}}}},
+		{"insert random 10", Statement{Kind: StatementKindInsertRandom, NumRandomRows: 10}},
+		{"update orders 1 4 fragile", Statement{Kind: StatementKindUpdate, Table: "orders",
+			Values: []Value{{Text: "1"}, {Text: "4"}, {Text: "fragile"}}}},
+		{"delete from orders 1", Statement{Kind: StatementKindDelete, Table: "orders",
+			Where: []Condition{{Op: OperatorEqual, Value: "1"}}}},
+		{"select", Statement{Kind: StatementKindSelect}},
+		{"select * from users", Statement{Kind: StatementKindSelect, Table: "users"}},
+		{"select from orders where id >= 1 and qty < 10 and note is not null", Statement{Kind: StatementKindSelect, Table: "orders",
+			Where: []Condition{{Column: "id", Op: OperatorGreaterEqual, Value: "1"}, {Column: "qty", Op: OperatorLess, Value: "10"},
+				{Column: "note", Op: OperatorIsNotNull}}}},
+		{"select where email like '%@gmail.com'", Statement{Kind: StatementKindSelect,
+			Where: []Condition{{Column: "email", Op: OperatorLike, Value: "%@gmail.com"}}}},
+		{"select username, count(*) group by username having count(*) > 1 order by username desc limit 5 offset 2",
+			Statement{Kind: StatementKindSelect,
+				Columns: []SelectColumn{{Column: "username"}, {Func: AggregateCount}},
+				GroupBy: []string{"username"}, Having: []Condition{{Func: AggregateCount, Op: OperatorGreater, Value: "1"}},
+				OrderBy: "username", Desc: true, Limit: 5, HasLimit: true, Offset: 2}},
+		{"create table t (id int, s text(8) not null, b blob(4), ts timestamp, r real, n integer, x boolean)",
+			Statement{Kind: StatementKindCreateTable, Schema: Schema{Name: "t", Columns: []Column{
+				{Name: "id", Type: ColumnTypeInt, Size: IntSize},
+				{Name: "s", Type: ColumnTypeText, Size: 8, NotNull: true},
+				{Name: "b", Type: ColumnTypeBlob, Size: 4},
+				{Name: "ts", Type: ColumnTypeTimestamp, Size: TimestampSize},
+				{Name: "r", Type: ColumnTypeReal, Size: RealSize},
+				{Name: "n", Type: ColumnTypeInteger, Size: IntegerSize},
+				{Name: "x", Type: ColumnTypeBoolean, Size: BooleanSize},
+			}}}},
+		{"create table u (id int, name text(32) not empty not null, email text(64) check email not empty, b blob(4) not empty)",
+			Statement{Kind: StatementKindCreateTable, Schema: Schema{Name: "u", Columns: []Column{
+				{Name: "id", Type: ColumnTypeInt, Size: IntSize},
+				{Name: "name", Type: ColumnTypeText, Size: 32, NotNull: true, NotEmpty: true},
+				{Name: "email", Type: ColumnTypeText, Size: 64, NotEmpty: true, CheckEmail: true},
+				{Name: "b", Type: ColumnTypeBlob, Size: 4, NotEmpty: true},
+			}}}},
+		{"create index on users(email)", Statement{Kind: StatementKindCreateIndex,
+			Index: Index{Name: "users_email_idx", Table: "users", Column: "email"}}},
+		{"begin", Statement{Kind: StatementKindBegin}},
+		{"commit", Statement{Kind: StatementKindCommit}},
+		{"rollback", Statement{Kind: StatementKindRollback}},
+		{"vacuum", Statement{Kind: StatementKindVacuum}},
+		{"analyze", Statement{Kind: StatementKindAnalyze}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			got, err := Prepare(tt.sql)
+			if err != nil {
+				t.Fatalf("Prepare: %v", err)
+			}
+			if !reflect.DeepEqual(normalizeStatement(got), normalizeStatement(tt.want)) {
+				t.Errorf("Prepare =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// normalizeStatement replaces the empty slices of the statement with nil, the
+// parser returns either
+func normalizeStatement(s Statement) Statement {
+	if len(s.Values) == 0 {
+		s.Values = nil
+	}
+	if len(s.Rows) == 0 {
+		s.Rows = nil
+	}
+	if len(s.Columns) == 0 {
+		s.Columns = nil
+	}
+	if len(s.Where) == 0 {
+		s.Where = nil
+	}
+	if len(s.GroupBy) == 0 {
+		s.GroupBy = nil
+	}
+	if len(s.Having) == 0 {
+		s.Having = nil
+	}
+	if len(s.Schema.Columns) == 0 {
+		s.Schema.Columns = nil
+	}
+	return s
+}
+
+func TestPrepareInvalid(t *testing.T) {
+	tests := []struct {
+		sql  string
+		err  error
+		pos  int
+		near string
+	}{
+		{"", ErrUnrecognizedStatement, 0, ""},
+		{"foo", ErrUnrecognizedStatement, 0, "foo"},
+		{"insert", ErrSyntax, 6, ""},
+		{"update", ErrSyntax, 6, ""},
+		{"insert 'abc", ErrSyntax, 7, "'abc"},
+		{"insert (1, a, b) (2, c, d)", ErrSyntax, 17, "("},
+		{"insert random -5", ErrNegativeNumber, 14, "-5"},
+		{"delete -1", ErrNegativeNumber, 7, "-1"},
+		{"delete 4294967296", ErrNumberOutOfRange, 7, "4294967296"},
+		{"select limit -1", ErrNegativeNumber, 13, "-1"},
+		{"select limit 99999999999", ErrNumberOutOfRange, 13, "99999999999"},
+		{"select where", ErrSyntax, 12, ""},
+		{"select from", ErrSyntax, 11, ""},
+		{"select from t where x ~ 1", ErrSyntax, 22, "~"},
+		{"create table", ErrSyntax, 12, ""},
+		{"create table t (id int, s text)", ErrSyntax, 30, ")"},
+		{"create table t (id int, s text(8) not full)", ErrSyntax, 38, "full"},
+		{"create table t (id int, s text(8) check phone)", ErrSyntax, 40, "phone"},
+		{"create index i on t(", ErrSyntax, 20, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			_, err := Prepare(tt.sql)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Prepare error = %v, want %v", err, tt.err)
+			}
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("Prepare error %T is not a *SyntaxError", err)
+			}
+			if syntaxErr.Near != tt.near || (tt.near != "" && syntaxErr.Pos != tt.pos) {
+				t.Errorf("error at %d near %q, want %d near %q", syntaxErr.Pos, syntaxErr.Near, tt.pos, tt.near)
+			}
+		})
+	}
+}
+
+// FuzzPrepare checks that Prepare doesn't panic, and that the values of an
+// insert it accepts parse back the same once written as literals
+func FuzzPrepare(f *testing.F) {
+	for _, seed := range []string{
+		"insert 1 john john@example.com",
+		`insert 2 "say \"hi\"" 'it\'s' null 'null'`,
+		"insert into users (3, alice, 'a@b.c'), (4, bob, null)",
+		"select username, count(*) from users where id >= 1 and email like 'a%' group by username having count(*) > 1 order by username desc limit 5 offset 2",
+		"create table t (id int, s text(8) not null, b blob(4), ts timestamp)",
+		"create table u (id int, name text(32) not empty, email text(64) not null check email)",
+		"create index on users(email)",
+		"delete from orders 1",
+		"explain select where email is null",
+		"insert 'abc",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, sql string) {
+		stmt, err := Prepare(sql)
+		if err != nil || stmt.Kind != StatementKindInsert {
+			return
+		}
+		rows := stmt.Rows
+		if len(rows) == 0 {
+			rows = [][]Value{stmt.Values}
+		}
+		var literals []string
+		for _, values := range rows {
+			row := make([]string, len(values))
+			for i, v := range values {
+				row[i] = "null"
+				if !v.Null {
+					row[i] = quoteString(v.Text)
+				}
+			}
+			literals = append(literals, "("+strings.Join(row, ", ")+")")
+		}
+		again, err := Prepare("insert into t " + strings.Join(literals, ", "))
+		if err != nil {
+			t.Fatalf("Prepare of the values of %q: %v", sql, err)
+		}
+		got := again.Rows
+		if len(got) == 0 {
+			got = [][]Value{again.Values}
+		}
+		if !reflect.DeepEqual(got, rows) {
+			t.Errorf("values %+v parsed back as %+v", rows, got)
+		}
+	})
+}
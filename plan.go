@@ -0,0 +1,375 @@
+package scratchdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// The selectivities estimate the share of the rows a condition matches when
+// the number of distinct values of its column is unknown: an equality matches
+// few rows, a range a part of them
+const (
+	equalSelectivity = 10
+	rangeSelectivity = 4
+)
+
+// indexRowCost is the cost of a row found through an index, in rows read by
+// a scan of the table: its index entry is read, then the row is searched in
+// the table
+const indexRowCost = 3
+
+// selectPlan is how a select runs: the scan finds the rows matching the where
+// clause, they are grouped when the select has aggregates, then sorted and
+// cut to the limit. planSelect builds it, executeSelect and executeGroup run
+// it and explain describes it.
+type selectPlan struct {
+	stmt  *Statement
+	table *Table
+	where []boundCondition
+	scan  scanPlan
+	// tableRows is the number of rows of the table, from analyze or estimated
+	// from its tree
+	tableRows uint64
+	// max is the number of rows the scan stops after, -1 to scan them all.
+	// Only rows found in the order they are returned stop early.
+	max int
+	// grouped is the grouping of a select with aggregates, nil otherwise
+	grouped *groupedSelect
+	// orderBy are the positions of the columns the rows, or the groups, are
+	// sorted by
+	orderBy []int
+}
+
+// planSelect binds the select to the table and chooses how its rows are found
+func planSelect(stmt *Statement, table *Table) (*selectPlan, error) {
+	schema := &table.schema
+	where, err := bindWhere(schema, stmt.Where)
+	if err != nil {
+		return nil, err
+	}
+	plan := &selectPlan{stmt: stmt, table: table, where: where, max: -1}
+	if plan.tableRows, err = table.rowCount(); err != nil {
+		return nil, err
+	}
+	plan.scan = planScan(table, where, plan.tableRows)
+
+	if stmt.isGrouped() {
+		if plan.grouped, err = bindGrouped(schema, stmt); err != nil {
+			return nil, err
+		}
+		plan.orderBy = plan.grouped.keys
+		if stmt.OrderBy != "" {
+			column, err := plan.grouped.groupColumn(stmt.OrderBy)
+			if err != nil {
+				return nil, err
+			}
+			plan.orderBy = []int{column}
+		}
+		return plan, nil
+	}
+
+	if stmt.OrderBy != "" {
+		column, ok := schema.columnIndex(stmt.OrderBy)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrNoSuchColumn, stmt.OrderBy)
+		}
+		plan.orderBy = []int{column}
+	}
+	if stmt.HasLimit && !plan.sorts() && (len(plan.orderBy) == 0 || !stmt.Desc) {
+		plan.max = int(uint64(stmt.Offset) + uint64(stmt.Limit))
+	}
+	return plan, nil
+}
+
+// sorts reports whether the rows are sorted in memory, rows scanned in key
+// order and ordered by the primary key are not
+func (p *selectPlan) sorts() bool {
+	if len(p.orderBy) == 0 {
+		return false
+	}
+	return p.grouped != nil || !(p.orderBy[0] == 0 && p.scan.keyOrder())
+}
+
+// scanRows returns the rows the scan finds, up to max
+func (p *selectPlan) scanRows(ctx context.Context) ([]Row, error) {
+	var rows []Row
+	err := p.scan.scan(ctx, p.table, p.where, func(row Row) bool {
+		if len(rows) == p.max {
+			return false
+		}
+		rows = append(rows, row)
+		return true
+	})
+	return rows, err
+}
+
+// scanPlan is how the rows matching a where clause are found: the entries of
+// idx with values from lo to hi when idx is set, otherwise the rows of the
+// table in key order. rows is the estimated number of rows it visits and
+// cost what visiting them takes.
+type scanPlan struct {
+	idx    *index
+	lo, hi []byte
+	rows   uint64
+	cost   uint64
+}
+
+// planScan chooses the cheapest way to find the rows: a scan of the table,
+// starting and stopping at the bounds of the conditions on the primary key,
+// or a scan of the index of a column the conditions bound. An index is only
+// used when it visits less than a third of the rows the table scan visits,
+// on a column with few distinct values a scan is cheaper.
+func planScan(table *Table, where []boundCondition, tableRows uint64) scanPlan {
+	best := scanPlan{rows: tableRows}
+	for _, cond := range where {
+		if best.bounds(cond) {
+			best.rows = selectRows(best.rows, cond.Op, tableRows)
+		}
+	}
+	best.cost = best.rows
+
+	for _, idx := range table.indexes {
+		lo, hi, ok := indexRange(where, idx)
+		if !ok {
+			continue
+		}
+		plan := scanPlan{idx: idx, lo: lo, hi: hi, rows: tableRows}
+		for _, cond := range where {
+			if plan.bounds(cond) {
+				plan.rows = selectRows(plan.rows, cond.Op, idx.distinct())
+			}
+		}
+		plan.cost = plan.rows * indexRowCost
+		if plan.cost < best.cost {
+			best = plan
+		}
+	}
+	return best
+}
+
+// keyOrder reports whether the rows are found in primary key order
+func (p scanPlan) keyOrder() bool {
+	return p.idx == nil
+}
+
+// bounds reports whether the condition narrows the rows the scan visits: a
+// condition on the primary key of a table scan, or one on the indexed column
+// giving indexRange a bound
+func (p scanPlan) bounds(cond boundCondition) bool {
+	if p.idx == nil {
+		return cond.column == 0 && cond.Op != OperatorIsNotNull
+	}
+	if cond.column != p.idx.column || cond.Op == OperatorIsNotNull {
+		return false
+	}
+	return cond.Op != OperatorLike || likePrefix(cond.value.(string)) != ""
+}
+
+// explain describes the plan, one line per step with the steps run first
+// indented under the ones they feed. Each step ends with the number of rows
+// it is estimated to return.
+func (p *selectPlan) explain() []string {
+	schema := &p.table.schema
+	stmt := p.stmt
+
+	var steps []string
+	step := func(estimate uint64, format string, args ...interface{}) {
+		steps = append(steps, fmt.Sprintf(format, args...)+fmt.Sprintf(" (~%s)", pluralRows(estimate)))
+	}
+
+	var bounds, filters []string
+	rows := p.scan.rows
+	for _, cond := range p.where {
+		name := schema.Columns[cond.column].Name
+		if p.scan.bounds(cond) {
+			bounds = append(bounds, describeCondition(name, cond.Condition, cond.value))
+			continue
+		}
+		filters = append(filters, describeCondition(name, cond.Condition, cond.value))
+		rows = selectRows(rows, cond.Op, p.table.distinct(cond.column, p.tableRows))
+	}
+	var scan string
+	switch {
+	case p.scan.idx != nil:
+		scan = fmt.Sprintf("index scan %s on %s where %s", p.scan.idx.Name, schema.Name, strings.Join(bounds, " and "))
+	case len(bounds) > 0:
+		scan = fmt.Sprintf("search %s by key where %s", schema.Name, strings.Join(bounds, " and "))
+	default:
+		scan = fmt.Sprintf("scan %s", schema.Name)
+	}
+	step(p.scan.rows, "%s, cost %d", scan, p.scan.cost)
+	if len(filters) > 0 {
+		step(rows, "filter %s", strings.Join(filters, " and "))
+	}
+	if p.max >= 0 && uint64(p.max) < rows {
+		rows = uint64(p.max)
+		step(rows, "stop after %s", pluralRows(rows))
+	}
+
+	if gs := p.grouped; gs != nil {
+		aggregates := make([]string, len(gs.aggregators))
+		for i, a := range gs.aggregators {
+			aggregates[i] = a.String()
+		}
+		if len(gs.keys) == 0 {
+			rows = 1
+			step(rows, "aggregate %s", strings.Join(aggregates, ", "))
+		} else {
+			// there is a group per distinct value of the key
+			var distinct uint64
+			if len(gs.keys) == 1 {
+				distinct = p.table.distinct(gs.keys[0], p.tableRows)
+			}
+			switch {
+			case distinct == 0:
+				rows = selectRows(rows, OperatorEqual, 0)
+			case distinct < rows:
+				rows = distinct
+			}
+			group := fmt.Sprintf("group by %s", strings.Join(columnNames(schema, gs.keys), ", "))
+			if len(aggregates) > 0 {
+				group += " computing " + strings.Join(aggregates, ", ")
+			}
+			step(rows, "%s", group)
+		}
+		if len(gs.having) > 0 {
+			having := make([]string, len(gs.having))
+			for i, cond := range gs.having {
+				having[i] = describeCondition(SelectColumn{Func: cond.Func, Column: cond.Column}.String(), cond.Condition, cond.value)
+			}
+			for _, cond := range gs.having {
+				rows = selectRows(rows, cond.Op, 0)
+			}
+			step(rows, "having %s", strings.Join(having, " and "))
+		}
+	}
+
+	if p.sorts() {
+		order := strings.Join(columnNames(schema, p.orderBy), ", ")
+		if stmt.Desc {
+			order += " desc"
+		}
+		step(rows, "sort by %s", order)
+	} else if len(p.orderBy) > 0 && stmt.Desc {
+		step(rows, "reverse the key order")
+	}
+	if stmt.HasLimit || stmt.Offset > 0 {
+		if uint64(stmt.Offset) >= rows {
+			rows = 0
+		} else {
+			rows -= uint64(stmt.Offset)
+		}
+		var limit []string
+		if stmt.HasLimit {
+			if uint64(stmt.Limit) < rows {
+				rows = uint64(stmt.Limit)
+			}
+			limit = append(limit, fmt.Sprintf("limit %d", stmt.Limit))
+		}
+		if stmt.Offset > 0 {
+			limit = append(limit, fmt.Sprintf("offset %d", stmt.Offset))
+		}
+		step(rows, "%s", strings.Join(limit, " "))
+	}
+
+	// the last step returns the rows, it goes first
+	lines := make([]string, len(steps))
+	for i := range steps {
+		lines[i] = strings.Repeat("  ", i) + steps[len(steps)-1-i]
+	}
+	return lines
+}
+
+// selectRows estimates the rows of n a condition with the operator matches,
+// distinct is the number of distinct values of its column, 0 when unknown
+func selectRows(n uint64, op Operator, distinct uint64) uint64 {
+	if n == 0 {
+		return 0
+	}
+	switch op {
+	case OperatorIsNotNull:
+		return n
+	case OperatorEqual, OperatorIsNull:
+		if distinct > 0 {
+			n /= distinct
+		} else {
+			n /= equalSelectivity
+		}
+	default:
+		n /= rangeSelectivity
+	}
+	// a condition is expected to match some row of a table with rows
+	if n == 0 {
+		return 1
+	}
+	return n
+}
+
+// rowCount returns the rows of the table counted by analyze, or else
+// estimated from the leftmost path of its tree, assuming every node holds as
+// many cells as the nodes on it
+func (t *Table) rowCount() (uint64, error) {
+	if t.stats != nil {
+		return t.stats.rows, nil
+	}
+	n, err := t.pager.getNode(t.rootPageNum)
+	if err != nil {
+		return 0, err
+	}
+	fanout := uint64(1)
+	for n.nodeType() == NodeInternal {
+		fanout *= uint64(n.internalNumKeys()) + 1
+		if n, err = t.pager.getNode(n.internalChild(0)); err != nil {
+			return 0, err
+		}
+	}
+	return fanout * uint64(n.leafNumCells()), nil
+}
+
+// distinct returns the number of distinct values of the column, 0 when
+// unknown. The primary key has one per row, other columns are known from the
+// statistics of their index.
+func (t *Table) distinct(column int, rows uint64) uint64 {
+	if column == 0 {
+		return rows
+	}
+	for _, idx := range t.indexes {
+		if idx.column == column {
+			return idx.distinct()
+		}
+	}
+	return 0
+}
+
+// distinct returns the number of distinct values of the index, 0 until it is analyzed
+func (idx *index) distinct() uint64 {
+	if idx.stats == nil {
+		return 0
+	}
+	return idx.stats.distinct
+}
+
+// describeCondition returns the condition on the named column or aggregate
+// as written in a statement, with its value converted to the column type
+func describeCondition(name string, cond Condition, value interface{}) string {
+	if !cond.Op.hasValue() {
+		return fmt.Sprintf("%s %s", name, cond.Op)
+	}
+	return fmt.Sprintf("%s %s %s", name, cond.Op, Literal(value))
+}
+
+func columnNames(schema *Schema, columns []int) []string {
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = schema.Columns[column].Name
+	}
+	return names
+}
+
+func pluralRows(n uint64) string {
+	if n == 1 {
+		return "1 row"
+	}
+	return fmt.Sprintf("%d rows", n)
+}
@@ -0,0 +1,22 @@
+package scratchdb
+
+import "time"
+
+// ResultSet is the result of a statement: the selected rows and the columns
+// of their table, and what it took to execute. Statements other than select
+// return no columns.
+type ResultSet struct {
+	Kind    StatementKind
+	Columns []Column
+	Rows    []Row
+	// RowsAffected is the number of rows inserted, updated or deleted
+	RowsAffected uint64
+	// Duration is the time taken to parse and execute the statement
+	Duration time.Duration
+	// PagesRead is the number of pages read from the log or the database
+	// file, pages found in the cache are not counted. Selects running at the
+	// same time count the pages read by each other.
+	PagesRead uint64
+	// PagesWritten is the number of pages the statement changed
+	PagesWritten uint64
+}
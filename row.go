@@ -0,0 +1,301 @@
+package scratchdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Row holds the values of a row in the order of the table's columns, nil for
+// NULL. The Go type of a value depends on its column type: uint32 for int,
+// string for text, float64 for real, int64 for integer, bool for boolean,
+// []byte for blob and time.Time for timestamp.
+type Row []interface{}
+
+// Validate returns an error when the row can't be stored in a table with the
+// schema: it must have a value for each column, of the Go type of the column,
+// text and blobs must fit the size of their column, and the values must pass
+// the constraints of their column. NULL is rejected with ErrNotNull for the
+// primary key and not null columns, a value of another type with
+// ErrTypeMismatch, a long value with ErrStringTooLong, an empty value of a
+// not empty column with ErrEmptyValue and a value of a check email column
+// that is not an email address with ErrInvalidEmail.
+func (r Row) Validate(schema *Schema) error {
+	if len(r) != len(schema.Columns) {
+		return fmt.Errorf("%w: table %s has %d columns, got %d values", ErrSyntax, schema.Name, len(schema.Columns), len(r))
+	}
+	for i, col := range schema.Columns {
+		value := r[i]
+		if value == nil {
+			if i == 0 || col.NotNull {
+				return fmt.Errorf("%w: %s", ErrNotNull, col.Name)
+			}
+			continue
+		}
+		ok := false
+		size := -1
+		switch v := value.(type) {
+		case uint32:
+			ok = col.Type == ColumnTypeInt
+		case string:
+			ok, size = col.Type == ColumnTypeText, len(v)
+		case float64:
+			ok = col.Type == ColumnTypeReal && !math.IsNaN(v)
+		case int64:
+			ok = col.Type == ColumnTypeInteger
+		case bool:
+			ok = col.Type == ColumnTypeBoolean
+		case []byte:
+			ok, size = col.Type == ColumnTypeBlob, len(v)
+		case time.Time:
+			ok = col.Type == ColumnTypeTimestamp
+		}
+		if !ok {
+			return fmt.Errorf("%w: %s is %s, got %T %v", ErrTypeMismatch, col.Name, col.Type, value, value)
+		}
+		if size > int(col.Size) {
+			return fmt.Errorf("%w: %s is at most %d bytes", ErrStringTooLong, col.Name, col.Size)
+		}
+		if size == 0 && col.NotEmpty {
+			return fmt.Errorf("%w: %s", ErrEmptyValue, col.Name)
+		}
+		if col.CheckEmail && !isEmail(value.(string)) {
+			return fmt.Errorf("%w: %s is %q", ErrInvalidEmail, col.Name, value)
+		}
+	}
+	return nil
+}
+
+// isEmail reports whether s looks like an email address: a local part, one @
+// and a domain of at least two dot separated labels, without spaces or
+// control characters
+func isEmail(s string) bool {
+	at := strings.IndexByte(s, '@')
+	if at <= 0 || strings.IndexByte(s[at+1:], '@') >= 0 {
+		return false
+	}
+	for _, c := range s {
+		if c <= ' ' || c == 0x7f {
+			return false
+		}
+	}
+	labels := strings.Split(s[at+1:], ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if label == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// key returns the key of the row's cell in the table, from its primary key
+func (r Row) key() []byte {
+	return tableKey(r[0].(uint32))
+}
+
+// compareValues returns -1, 0 or 1 when a is less than, equal to or greater
+// than b, two values of the same column or aggregate. NULL is less than any
+// value so it sorts first.
+func compareValues(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	switch a := a.(type) {
+	case uint32:
+		b := b.(uint32)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	case uint64:
+		b := b.(uint64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	case float64:
+		b := b.(float64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	case int64:
+		b := b.(int64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	case bool:
+		b := b.(bool)
+		switch {
+		case a == b:
+			return 0
+		case b:
+			return -1
+		}
+		return 1
+	case string:
+		return strings.Compare(a, b.(string))
+	case []byte:
+		return bytes.Compare(a, b.([]byte))
+	case time.Time:
+		b := b.(time.Time)
+		switch {
+		case a.Before(b):
+			return -1
+		case a.After(b):
+			return 1
+		}
+		return 0
+	}
+	return 0
+}
+
+// A serialized row starts with its null bitmap, a bit per column in column
+// order set when the value is NULL, followed by the values of the other
+// columns. Values of fixed-width types take the width of their type, text and
+// blob values their length in VarLengthSize bytes and their bytes. A NULL
+// value takes no space.
+
+// nullBitmapSize returns the bytes of the null bitmap of a row with the columns
+func nullBitmapSize(numColumns int) uint32 {
+	return uint32(numColumns+7) / 8
+}
+
+// recordSize returns the serialized size of the row
+func (s *Schema) recordSize(row Row) uint32 {
+	size := nullBitmapSize(len(s.Columns))
+	for i, col := range s.Columns {
+		switch v := row[i].(type) {
+		case nil:
+		case string:
+			size += VarLengthSize + uint32(len(v))
+		case []byte:
+			size += VarLengthSize + uint32(len(v))
+		default:
+			size += col.Size
+		}
+	}
+	return size
+}
+
+// serializeRow returns the record of the row, text values must fit their column
+func serializeRow(schema *Schema, row Row) []byte {
+	record := make([]byte, schema.recordSize(row))
+	offset := nullBitmapSize(len(schema.Columns))
+	for i, col := range schema.Columns {
+		if row[i] == nil {
+			record[i/8] |= 1 << (i % 8)
+			continue
+		}
+		switch col.Type {
+		case ColumnTypeInt:
+			binary.BigEndian.PutUint32(record[offset:], row[i].(uint32))
+		case ColumnTypeText:
+			offset += putVar(record[offset:], []byte(row[i].(string)))
+			continue
+		case ColumnTypeReal:
+			binary.BigEndian.PutUint64(record[offset:], math.Float64bits(row[i].(float64)))
+		case ColumnTypeInteger:
+			binary.BigEndian.PutUint64(record[offset:], uint64(row[i].(int64)))
+		case ColumnTypeBoolean:
+			if row[i].(bool) {
+				record[offset] = 1
+			}
+		case ColumnTypeBlob:
+			offset += putVar(record[offset:], row[i].([]byte))
+			continue
+		case ColumnTypeTimestamp:
+			binary.BigEndian.PutUint64(record[offset:], uint64(row[i].(time.Time).UnixMicro()))
+		}
+		offset += col.Size
+	}
+	return record
+}
+
+// putVar writes the length and the bytes of a text or blob value, it returns
+// the bytes written
+func putVar(buf []byte, value []byte) uint32 {
+	binary.BigEndian.PutUint16(buf, uint16(len(value)))
+	copy(buf[VarLengthSize:], value)
+	return VarLengthSize + uint32(len(value))
+}
+
+// deserializeRow decodes the record of a row. The values are copied out of
+// the record, it may be a page that is reused. A record that is cut short,
+// has bytes left over or a value longer than its column is corrupt.
+func deserializeRow(schema *Schema, record []byte) (Row, error) {
+	row := make(Row, len(schema.Columns))
+	offset := nullBitmapSize(len(schema.Columns))
+	if uint32(len(record)) < offset {
+		return nil, fmt.Errorf("record of %d bytes is corrupt: no null bitmap", len(record))
+	}
+	for i, col := range schema.Columns {
+		if record[i/8]&(1<<(i%8)) != 0 {
+			continue
+		}
+		size := col.Size
+		if col.isVariable() {
+			if uint32(len(record)) < offset+VarLengthSize {
+				return nil, fmt.Errorf("record of %d bytes is corrupt: %s is cut short", len(record), col.Name)
+			}
+			length := uint32(binary.BigEndian.Uint16(record[offset:]))
+			if length > col.Size {
+				return nil, fmt.Errorf("record of %d bytes is corrupt: %s is %d bytes, at most %d", len(record), col.Name, length, col.Size)
+			}
+			size = VarLengthSize + length
+		}
+		if uint32(len(record)) < offset+size {
+			return nil, fmt.Errorf("record of %d bytes is corrupt: %s is cut short", len(record), col.Name)
+		}
+		value := record[offset : offset+size]
+		offset += size
+
+		switch col.Type {
+		case ColumnTypeInt:
+			row[i] = binary.BigEndian.Uint32(value)
+		case ColumnTypeText:
+			row[i] = string(value[VarLengthSize:])
+		case ColumnTypeReal:
+			row[i] = math.Float64frombits(binary.BigEndian.Uint64(value))
+		case ColumnTypeInteger:
+			row[i] = int64(binary.BigEndian.Uint64(value))
+		case ColumnTypeBoolean:
+			row[i] = value[0] != 0
+		case ColumnTypeBlob:
+			row[i] = append([]byte{}, value[VarLengthSize:]...)
+		case ColumnTypeTimestamp:
+			row[i] = time.UnixMicro(int64(binary.BigEndian.Uint64(value))).UTC()
+		}
+	}
+	if offset != uint32(len(record)) {
+		return nil, fmt.Errorf("record of %d bytes is corrupt: %d bytes past the row", len(record), uint32(len(record))-offset)
+	}
+	if row[0] == nil {
+		return nil, fmt.Errorf("record of %d bytes is corrupt: the primary key is NULL", len(record))
+	}
+	return row, nil
+}
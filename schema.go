@@ -0,0 +1,333 @@
+package scratchdb
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ColumnType is the type of a column, it decides the Go type of its values
+// and their encoding
+type ColumnType uint8
+
+const (
+	// ColumnTypeInt is a uint32, stored in 4 bytes
+	ColumnTypeInt ColumnType = iota + 1
+	// ColumnTypeText is a string of up to Size bytes, stored after its length
+	ColumnTypeText
+	// ColumnTypeReal is a float64, stored as its IEEE 754 bits in 8 bytes
+	ColumnTypeReal
+	// ColumnTypeInteger is an int64, stored in 8 bytes
+	ColumnTypeInteger
+	// ColumnTypeBoolean is a bool, stored in 1 byte
+	ColumnTypeBoolean
+	// ColumnTypeBlob is a []byte of up to Size bytes, stored after its length
+	ColumnTypeBlob
+	// ColumnTypeTimestamp is a time.Time in UTC with microsecond precision,
+	// stored as microseconds since the Unix epoch in 8 bytes
+	ColumnTypeTimestamp
+)
+
+var columnTypeNames = map[ColumnType]string{
+	ColumnTypeInt:       "int",
+	ColumnTypeText:      "text",
+	ColumnTypeReal:      "real",
+	ColumnTypeInteger:   "integer",
+	ColumnTypeBoolean:   "boolean",
+	ColumnTypeBlob:      "blob",
+	ColumnTypeTimestamp: "timestamp",
+}
+
+func (t ColumnType) String() string {
+	return columnTypeNames[t]
+}
+
+// isNumber reports whether sum and avg can add the values of the type
+func (t ColumnType) isNumber() bool {
+	return t == ColumnTypeInt || t == ColumnTypeInteger || t == ColumnTypeReal
+}
+
+// The serialized widths of the column types, text and blob values take their
+// length and their bytes
+const (
+	IntSize       uint32 = 4
+	IntegerSize   uint32 = 8
+	RealSize      uint32 = 8
+	BooleanSize   uint32 = 1
+	TimestampSize uint32 = 8
+	// VarLengthSize is the width of the length before the bytes of a text or blob value
+	VarLengthSize uint32 = 2
+)
+
+// timestampLayouts are the formats a timestamp value may be written in, a
+// time without a zone is in UTC
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// Column is a column of a table. Size is the serialized width of its values,
+// or for text and blob columns the most bytes a value holds.
+type Column struct {
+	Name string
+	Type ColumnType
+	Size uint32
+	// NotNull rejects NULL values, the primary key is never NULL
+	NotNull bool
+	// NotEmpty rejects empty text and blob values
+	NotEmpty bool
+	// CheckEmail rejects text values that are not an email address
+	CheckEmail bool
+}
+
+// String returns the column definition as written in `create table`
+func (c Column) String() string {
+	def := c.Name + " " + c.Type.String()
+	switch c.Type {
+	case ColumnTypeText:
+		def = fmt.Sprintf("%s text(%d)", c.Name, c.Size)
+	case ColumnTypeBlob:
+		def = fmt.Sprintf("%s blob(%d)", c.Name, c.Size)
+	}
+	if c.NotNull {
+		def += " not null"
+	}
+	if c.NotEmpty {
+		def += " not empty"
+	}
+	if c.CheckEmail {
+		def += " check email"
+	}
+	return def
+}
+
+// isVariable reports whether the values of the column are stored at their
+// own length rather than at Size
+func (c Column) isVariable() bool {
+	return c.Type == ColumnTypeText || c.Type == ColumnTypeBlob
+}
+
+// Schema defines a table. Its first column is an int primary key, the rows
+// of the table are stored in a B+tree keyed by it.
+type Schema struct {
+	Name    string
+	Columns []Column
+}
+
+// String returns the `create table` statement defining the table
+func (s Schema) String() string {
+	cols := make([]string, len(s.Columns))
+	for i, col := range s.Columns {
+		cols[i] = col.String()
+	}
+	return fmt.Sprintf("create table %s (%s)", s.Name, strings.Join(cols, ", "))
+}
+
+// defaultSchema is the table statements run against when no table was created
+func defaultSchema() Schema {
+	return Schema{
+		Name: "users",
+		Columns: []Column{
+			{Name: "id", Type: ColumnTypeInt, Size: IDSize},
+			{Name: "username", Type: ColumnTypeText, Size: UsernameSize, NotEmpty: true},
+			{Name: "email", Type: ColumnTypeText, Size: EmailSize, NotEmpty: true, CheckEmail: true},
+		},
+	}
+}
+
+// RowSize returns the largest serialized size of a row, with text and blob
+// values of their full size
+func (s *Schema) RowSize() uint32 {
+	size := nullBitmapSize(len(s.Columns))
+	for _, col := range s.Columns {
+		if col.isVariable() {
+			size += VarLengthSize
+		}
+		size += col.Size
+	}
+	return size
+}
+
+// validate checks the schema can be stored in pages of pageSize, it returns
+// an ErrInvalidSchema error
+func (s *Schema) validate(pageSize uint32) error {
+	if !isIdentifier(s.Name) {
+		return fmt.Errorf("%w: invalid table name %q", ErrInvalidSchema, s.Name)
+	}
+	if len(s.Columns) == 0 {
+		return fmt.Errorf("%w: table %s has no columns", ErrInvalidSchema, s.Name)
+	}
+	if s.Columns[0].Type != ColumnTypeInt {
+		return fmt.Errorf("%w: the first column %s must be an int primary key", ErrInvalidSchema, s.Columns[0].Name)
+	}
+
+	seen := map[string]bool{}
+	for _, col := range s.Columns {
+		if seen[col.Name] {
+			return fmt.Errorf("%w: duplicate column %s", ErrInvalidSchema, col.Name)
+		}
+		seen[col.Name] = true
+		if !isIdentifier(col.Name) {
+			return fmt.Errorf("%w: invalid column name %q", ErrInvalidSchema, col.Name)
+		}
+		if _, ok := columnTypeNames[col.Type]; !ok {
+			return fmt.Errorf("%w: column %s has an unknown type %d", ErrInvalidSchema, col.Name, col.Type)
+		}
+		if col.Size == 0 {
+			return fmt.Errorf("%w: column %s has no size", ErrInvalidSchema, col.Name)
+		}
+		if col.NotEmpty && !col.isVariable() {
+			return fmt.Errorf("%w: column %s is %s, only text and blob columns can be not empty", ErrInvalidSchema, col.Name, col.Type)
+		}
+		if col.CheckEmail && col.Type != ColumnTypeText {
+			return fmt.Errorf("%w: column %s is %s, only text columns can check email", ErrInvalidSchema, col.Name, col.Type)
+		}
+	}
+
+	// a leaf must hold at least two rows to be split in two
+	if maxRowSize := LeafNodeMaxRecordSize(pageSize); s.RowSize() > maxRowSize {
+		return fmt.Errorf("%w: row size %d is larger than %d", ErrInvalidSchema, s.RowSize(), maxRowSize)
+	}
+	return nil
+}
+
+// isIdentifier reports whether name can be used as a table or column name: up to
+// maxNameLength letters, digits and underscores, not starting with a digit so
+// names and ids can't be confused
+func isIdentifier(name string) bool {
+	if name == "" || len(name) > maxNameLength {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_', 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z':
+		case i > 0 && '0' <= r && r <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// columnIndex returns the position of the column, ok is false when there is no such column
+func (s *Schema) columnIndex(name string) (index int, ok bool) {
+	for i, col := range s.Columns {
+		if col.Name == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// parseValue converts the value to the type of the column, it returns
+// ErrTypeMismatch when the value is not of that type. Blobs are written in
+// hex, optionally prefixed by 0x.
+func parseValue(col Column, value string) (interface{}, error) {
+	mismatch := fmt.Errorf("%w: %s is %s, got %q", ErrTypeMismatch, col.Name, col.Type, value)
+	switch col.Type {
+	case ColumnTypeInt:
+		v, res := parseInt(value)
+		switch res {
+		case PrepareResultSuccess:
+			return v, nil
+		case PrepareResultSyntaxError:
+			return nil, mismatch
+		default:
+			return nil, fmt.Errorf("%w: %s %s", prepareResultError(res), col.Name, value)
+		}
+	case ColumnTypeInteger:
+		v, err := strconv.ParseInt(value, 10, 64)
+		if errors.Is(err, strconv.ErrRange) {
+			return nil, fmt.Errorf("%w: %s %s", ErrNumberOutOfRange, col.Name, value)
+		} else if err != nil {
+			return nil, mismatch
+		}
+		return v, nil
+	case ColumnTypeReal:
+		v, err := strconv.ParseFloat(value, 64)
+		if errors.Is(err, strconv.ErrRange) {
+			return nil, fmt.Errorf("%w: %s %s", ErrNumberOutOfRange, col.Name, value)
+		} else if err != nil || math.IsNaN(v) {
+			return nil, mismatch
+		}
+		if v == 0 {
+			// -0 equals 0, keep a single encoding of it for indexes and groups
+			v = 0
+		}
+		return v, nil
+	case ColumnTypeBoolean:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, mismatch
+		}
+		return v, nil
+	case ColumnTypeBlob:
+		v, err := hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(value, "0x"), "0X"))
+		if err != nil {
+			return nil, mismatch
+		}
+		return v, nil
+	case ColumnTypeTimestamp:
+		for _, layout := range timestampLayouts {
+			if v, err := time.Parse(layout, value); err == nil {
+				return v.UTC().Truncate(time.Microsecond), nil
+			}
+		}
+		return nil, mismatch
+	default:
+		return value, nil
+	}
+}
+
+// bindRow converts the values of an insert or update to a row of the table
+// and validates it, see Row.Validate
+func (s *Schema) bindRow(values []Value) (Row, error) {
+	if len(values) != len(s.Columns) {
+		return nil, fmt.Errorf("%w: table %s has %d columns, got %d values", ErrSyntax, s.Name, len(s.Columns), len(values))
+	}
+
+	row := make(Row, len(values))
+	for i, col := range s.Columns {
+		if values[i].Null {
+			continue
+		}
+		value, err := parseValue(col, values[i].Text)
+		if err != nil {
+			return nil, err
+		}
+		row[i] = value
+	}
+	if err := row.Validate(s); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+// checkRow returns the row as it is stored in the table, timestamps in UTC
+// to the microsecond and -0 as 0 like parseValue returns them. It fails when
+// the row is not valid, see Row.Validate.
+func (s *Schema) checkRow(row Row) (Row, error) {
+	if err := row.Validate(s); err != nil {
+		return nil, err
+	}
+	checked := make(Row, len(row))
+	for i, value := range row {
+		switch v := value.(type) {
+		case float64:
+			if v == 0 {
+				value = float64(0)
+			}
+		case time.Time:
+			value = v.UTC().Truncate(time.Microsecond)
+		}
+		checked[i] = value
+	}
+	return checked, nil
+}
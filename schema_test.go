@@ -0,0 +1,54 @@
+package scratchdb
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBindRowStringTooLong(t *testing.T) {
+	users := defaultSchema()
+	tests := []struct {
+		name     string
+		username string
+		email    string
+		wantErr  error
+	}{
+		{"username of 32 bytes", strings.Repeat("u", 32), "a@b.c", nil},
+		{"username of 33 bytes", strings.Repeat("u", 33), "a@b.c", ErrStringTooLong},
+		{"email of 255 bytes", "u", strings.Repeat("e", 251) + "@b.c", nil},
+		{"email of 256 bytes", "u", strings.Repeat("e", 252) + "@b.c", ErrStringTooLong},
+		// sizes are in bytes, not characters
+		{"username of 16 two byte characters", strings.Repeat("é", 16), "a@b.c", nil},
+		{"username of 17 two byte characters", strings.Repeat("é", 17), "a@b.c", ErrStringTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row, err := users.bindRow([]Value{{Text: "1"}, {Text: tt.username}, {Text: tt.email}})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("bindRow error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (row[1] != tt.username || row[2] != tt.email) {
+				t.Errorf("bindRow = %#v, want the values unchanged", row)
+			}
+			if err != nil && row != nil {
+				t.Errorf("bindRow returned the row %#v with its error", row)
+			}
+		})
+	}
+}
+
+func TestInsertStringTooLong(t *testing.T) {
+	db, _ := openTestDB(t, Options{})
+	defer db.Close()
+	mustExec(t, db, "insert 1 "+strings.Repeat("u", 32)+" "+strings.Repeat("e", 251)+"@b.c")
+	if err := db.Exec("insert 2 " + strings.Repeat("u", 33) + " a@b.c"); !errors.Is(err, ErrStringTooLong) {
+		t.Errorf("insert of a 33 byte username: %v, want ErrStringTooLong", err)
+	}
+	if err := db.Exec("update 1 u " + strings.Repeat("e", 252) + "@b.c"); !errors.Is(err, ErrStringTooLong) {
+		t.Errorf("update to a 256 byte email: %v, want ErrStringTooLong", err)
+	}
+	if keys := selectKeys(t, db, "select"); len(keys) != 1 {
+		t.Errorf("selected keys %v, want only the valid row", keys)
+	}
+}
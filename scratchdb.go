@@ -1 +1,318 @@
+// Package scratchdb is a tiny SQLite clone that can be embedded in Go programs.
+//
+//	db, err := scratchdb.Open("scratch.db")
+//	if err != nil { ... }
+//	defer db.Close()
+//
+//	err = db.Exec("insert 1 john john@example.com")
+//	rows, err := db.Query("select")
 package scratchdb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnrecognizedStatement = errors.New("unrecognized statement")
+	ErrSyntax                = errors.New("syntax error")
+	ErrNegativeNumber        = errors.New("number must not be negative")
+	ErrNumberOutOfRange      = errors.New("number is out of range")
+	ErrTableFull             = errors.New("table full")
+	ErrDuplicateKey          = errors.New("duplicate key")
+	ErrStringTooLong         = errors.New("string is too long")
+	ErrNotNull               = errors.New("column can't be null")
+	ErrEmptyValue            = errors.New("column can't be empty")
+	ErrInvalidEmail          = errors.New("invalid email address")
+	ErrTypeMismatch          = errors.New("value does not match the column type")
+	ErrTableExists           = errors.New("table already exists")
+	ErrIndexExists           = errors.New("index already exists")
+	ErrNoSuchTable           = errors.New("no such table")
+	ErrInvalidSchema         = errors.New("invalid schema")
+	ErrNoSuchColumn          = errors.New("no such column")
+	ErrNotADatabase          = errors.New("not a scratchdb file")
+	ErrUnsupportedVersion    = errors.New("unsupported file format version")
+	ErrInvalidPageSize       = errors.New("invalid page size")
+	ErrReadOnly              = errors.New("database is read-only")
+	ErrCancelled             = errors.New("query cancelled")
+	ErrMemoryLimit           = errors.New("memory limit exceeded")
+	ErrTxInProgress          = errors.New("a transaction is already in progress")
+	ErrNoTx                  = errors.New("no transaction in progress")
+	ErrTxDone                = errors.New("transaction has already been committed or rolled back")
+	ErrStaleCursor           = errors.New("cursor is stale, the database changed")
+	ErrLocked                = errors.New("database is locked")
+	ErrChecksum              = errors.New("page checksum mismatch")
+)
+
+// DB is an open database file, safe for concurrent use. Statements that only
+// read the database, selects, run together while statements changing it run
+// one at a time and alone.
+type DB struct {
+	// lock is held shared by the statements reading the database and
+	// exclusively by those changing it. It locks the whole database rather
+	// than tables, as statements changing different tables still share the
+	// cache, the freelist and the file header.
+	lock  sync.RWMutex
+	pager *Pager
+	// tx is the open transaction, nil when every statement is committed on its own
+	tx *Tx
+	// flusher is nil when Options.FlushInterval is not set
+	flusher *flusher
+	// lockFile holds the lock of the database file, nil when it is read-only
+	lockFile *os.File
+}
+
+// Options configure how a database is opened, the zero value uses the defaults
+type Options struct {
+	// CacheSize is the number of pages kept in memory, DefaultCacheSize when 0.
+	// Pages changed by an uncommitted transaction are kept even past it.
+	CacheSize int
+	// FlushInterval is how often committed changes are written from the
+	// write-ahead log to the database file in the background, 0 disables it.
+	// The log is also flushed when it grows too large and on Close.
+	FlushInterval time.Duration
+	// PageSize is the page size of a new database, a power of two between
+	// MinPageSize and MaxPageSize, DefaultPageSize when 0. An existing database
+	// keeps the page size it was created with, opening it with another one
+	// fails with ErrInvalidPageSize.
+	PageSize uint32
+	// ReadOnly opens the database without changing the files, statements that
+	// change it fail with ErrReadOnly. The database must exist. Read-only
+	// opens don't take the lock of the file, so they can inspect a database
+	// another process has open.
+	ReadOnly bool
+	// MemoryLimit is how many bytes of rows a select may hold to sort them,
+	// DefaultMemoryLimit when 0. A larger sort fails with ErrMemoryLimit.
+	MemoryLimit int64
+	// Logger receives the log of the pager and the statements, nothing is
+	// logged when it is nil
+	Logger *Logger
+	// ArchiveDir is the directory the write-ahead log is copied to before
+	// each checkpoint, nothing is archived when it is empty. A backup and the
+	// logs archived after it are restored to any commit with Restore.
+	ArchiveDir string
+}
+
+// Open opens the database file at path, creating it when it doesn't exist.
+// The file is locked until Close, opening it again before fails with
+// ErrLocked naming the process holding it.
+func Open(path string) (*DB, error) {
+	return OpenWithOptions(path, Options{})
+}
+
+// OpenWithOptions is like Open with the given options
+func OpenWithOptions(path string, opts Options) (*DB, error) {
+	if opts.CacheSize <= 0 {
+		opts.CacheSize = DefaultCacheSize
+	}
+	if opts.MemoryLimit <= 0 {
+		opts.MemoryLimit = DefaultMemoryLimit
+	}
+
+	var lockFile *os.File
+	if !opts.ReadOnly {
+		var err error
+		if lockFile, err = lockDB(path); err != nil {
+			return nil, err
+		}
+	}
+	pager, err := openPager(path, opts)
+	if err != nil {
+		closeLock(lockFile)
+		return nil, err
+	}
+	if err := initializeDB(pager); err != nil {
+		pager.closeFiles()
+		closeLock(lockFile)
+		return nil, err
+	}
+
+	db := &DB{pager: pager, lockFile: lockFile}
+	if opts.FlushInterval > 0 && !opts.ReadOnly {
+		db.flusher = startFlusher(pager, opts.FlushInterval)
+	}
+	return db, nil
+}
+
+// initializeDB writes the header and the empty catalog of a new database
+// file, and checks the header of an existing one, upgrading the file when it
+// has an older format
+func initializeDB(pager *Pager) error {
+	if pager.numPages != 0 {
+		if err := checkFileHeader(pager); err != errUpgrade {
+			return err
+		}
+		return upgradeDB(pager)
+	}
+	if pager.readOnly {
+		return ErrNotADatabase
+	}
+
+	if err := initializeFileHeader(pager); err != nil {
+		return err
+	}
+	if err := writeCatalog(pager, nil); err != nil {
+		return err
+	}
+	return pager.commit()
+}
+
+// Close writes the changes in the write-ahead log to the file and closes it,
+// an open transaction is rolled back
+func (db *DB) Close() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	var flushErr error
+	if db.flusher != nil {
+		flushErr = db.flusher.close()
+	}
+	err := db.pager.close()
+	closeLock(db.lockFile)
+	if err != nil {
+		return err
+	}
+	return flushErr
+}
+
+// RowCount returns the number of rows in all tables, it is kept in the
+// file header so no table is scanned
+func (db *DB) RowCount() (uint64, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	return rowCount(db.pager)
+}
+
+// Flush writes the committed changes in the write-ahead log to the database file.
+// Changes of an open transaction are not written.
+func (db *DB) Flush() error {
+	return db.pager.checkpoint()
+}
+
+// PageSize returns the size of the pages of the database file
+func (db *DB) PageSize() uint32 {
+	return db.pager.pageSize
+}
+
+// Exec executes a statement and discards any resulting rows
+func (db *DB) Exec(sql string) error {
+	return db.ExecContext(context.Background(), sql)
+}
+
+// ExecContext is like Exec, the statement is aborted with ErrCancelled when ctx is done
+func (db *DB) ExecContext(ctx context.Context, sql string) error {
+	_, err := db.QueryContext(ctx, sql)
+	return err
+}
+
+// Query executes a statement and returns the selected rows
+func (db *DB) Query(sql string) ([]Row, error) {
+	return db.QueryContext(context.Background(), sql)
+}
+
+// QueryContext is like Query, the statement is aborted with ErrCancelled when ctx is done
+func (db *DB) QueryContext(ctx context.Context, sql string) ([]Row, error) {
+	rs, err := db.QueryResult(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	return rs.Rows, nil
+}
+
+// QueryResult is like QueryContext, the rows are returned with the columns
+// of their table and the statistics of the statement
+func (db *DB) QueryResult(ctx context.Context, sql string) (*ResultSet, error) {
+	return db.query(ctx, sql, nil)
+}
+
+// query runs the statement under the lock, in tx when it is not nil, which
+// fails with ErrTxDone once tx is over
+func (db *DB) query(ctx context.Context, sql string, tx *Tx) (*ResultSet, error) {
+	start := time.Now()
+	db.pager.log.Debugf("query: %s", sql)
+	stmt, err := Prepare(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	readsOnly := stmt.Kind.ReadsOnly()
+	if readsOnly {
+		db.lock.RLock()
+		defer db.lock.RUnlock()
+	} else {
+		db.lock.Lock()
+		defer db.lock.Unlock()
+		db.pager.resetStats()
+	}
+	if tx != nil && db.tx != tx {
+		return nil, ErrTxDone
+	}
+
+	pagesRead := db.pager.readCount()
+	rs := &ResultSet{}
+	switch stmt.Kind {
+	case StatementKindBegin:
+		_, err = db.begin()
+	case StatementKindCommit:
+		err = ErrNoTx
+		if db.tx != nil {
+			err = db.tx.commit()
+		}
+	case StatementKindRollback:
+		err = ErrNoTx
+		if db.tx != nil {
+			err = db.tx.rollback()
+		}
+	case StatementKindVacuum:
+		err = db.vacuum(ctx)
+	default:
+		rs, err = db.execute(ctx, stmt)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rs.Kind = stmt.Kind
+	rs.Duration = time.Since(start)
+	rs.PagesRead = db.pager.readCount() - pagesRead
+	if !readsOnly {
+		rs.PagesWritten = uint64(len(db.pager.pagesChanged))
+	}
+	return rs, nil
+}
+
+// execute runs the statement atomically. Outside a transaction the statement
+// is committed to the write-ahead log when it succeeds, inside a transaction
+// only its own changes are undone when it fails.
+func (db *DB) execute(ctx context.Context, stmt Statement) (*ResultSet, error) {
+	pager := db.pager
+	if stmt.Kind.ReadsOnly() {
+		// there is nothing to commit or undo, and other statements reading
+		// the database may be running
+		return executeStatement(ctx, stmt, pager)
+	}
+	if db.tx != nil {
+		pager.beginStatement()
+		rs, err := executeStatement(ctx, stmt, pager)
+		if err != nil {
+			pager.rollbackStatement()
+			return nil, err
+		}
+		pager.endStatement()
+		return rs, nil
+	}
+
+	rs, err := executeStatement(ctx, stmt, pager)
+	if err != nil {
+		pager.rollback()
+		return nil, err
+	}
+	if err := pager.commit(); err != nil {
+		pager.rollback()
+		return nil, err
+	}
+	return rs, nil
+}
@@ -0,0 +1,173 @@
+package scratchdb
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// copyTestdata copies the file of testdata to a temporary directory, so the
+// test can change it
+func copyTestdata(t testing.TB, name string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := copyFile(filepath.Join("testdata", name), path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// checkGoldenFile checks the database written by testdata/v8.sql, the same
+// statements as testdata/v7.sql
+func checkGoldenFile(t *testing.T, db *DB) {
+	t.Helper()
+	tables, err := db.Tables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var defs []string
+	for _, table := range tables {
+		defs = append(defs, table.String())
+	}
+	wantDefs := []string{
+		"create table users (id int, username text(32), email text(255))",
+		"create table events (id int, kind text(16) not null, amount integer, ratio real, ok boolean, payload blob(8), at timestamp)",
+	}
+	if !reflect.DeepEqual(defs, wantDefs) {
+		t.Errorf("tables %q, want %q", defs, wantDefs)
+	}
+	indexes, err := db.Indexes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantIndexes := []Index{{Name: "users_email_idx", Table: "users", Column: "email"}, {Name: "events_kind", Table: "events", Column: "kind"}}
+	if !reflect.DeepEqual(indexes, wantIndexes) {
+		t.Errorf("indexes %+v, want %+v", indexes, wantIndexes)
+	}
+
+	rows, err := db.Query("select from events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRows := []Row{
+		{uint32(1), "login", int64(-5), 0.5, true, []byte{0xde, 0xad, 0xbe, 0xef}, time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)},
+		{uint32(2), "logout", nil, nil, false, nil, time.Date(2024, 3, 1, 12, 30, 0, 123456000, time.UTC)},
+		{uint32(3), "quoted 'kind'", int64(9000000000), -1.25, true, []byte{}, time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	if !reflect.DeepEqual(rows, wantRows) {
+		t.Errorf("events %v, want %v", rows, wantRows)
+	}
+
+	rows, err = db.Query("select from users where id < 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRows = []Row{{uint32(1), "alice", "alice@example.com"}, {uint32(2), "bob", "bob@example.com"}}
+	if !reflect.DeepEqual(rows, wantRows) {
+		t.Errorf("users %v, want %v", rows, wantRows)
+	}
+	if keys := selectKeys(t, db, "select from users where id >= 5 and id <= 6"); len(keys) != 0 {
+		t.Errorf("deleted rows %v are selected", keys)
+	}
+	if n, err := db.RowCount(); err != nil || n != 203 {
+		t.Errorf("RowCount = %d, %v, want 203", n, err)
+	}
+
+	rs, err := db.QueryResult(context.Background(), "explain select from users where email = 'alice@example.com'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan := rs.Rows[0][0].(string); !strings.HasPrefix(plan, "index scan users_email_idx") {
+		t.Errorf("plan %q does not scan the index", plan)
+	}
+	if keys := selectKeys(t, db, "select from users where email = 'alice@example.com'"); !reflect.DeepEqual(keys, []uint32{1}) {
+		t.Errorf("select through the index = %v, want [1]", keys)
+	}
+	if keys := selectKeys(t, db, "select from events where kind = 'logout'"); !reflect.DeepEqual(keys, []uint32{2}) {
+		t.Errorf("select through the index = %v, want [2]", keys)
+	}
+
+	if _, corrupt, err := db.Verify(); err != nil || len(corrupt) != 0 {
+		t.Errorf("Verify: corrupt pages %v, %v", corrupt, err)
+	}
+	mustExec(t, db, "insert into events 4 signup 1 1 true 0x00 2024-03-03")
+	if keys := selectKeys(t, db, "select from events where kind = 'signup'"); !reflect.DeepEqual(keys, []uint32{4}) {
+		t.Errorf("select of a new row = %v, want [4]", keys)
+	}
+}
+
+// TestGoldenFileV8 opens testdata/v8.db, written by testdata/v8.sql, to catch
+// changes that can't read the files of format version 8
+func TestGoldenFileV8(t *testing.T) {
+	db, err := Open(copyTestdata(t, "v8.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if pages, _, err := db.Verify(); err != nil || pages != 30 {
+		t.Errorf("Verify = %d pages, %v, want 30 pages", pages, err)
+	}
+	checkGoldenFile(t, db)
+}
+
+// TestGoldenFileV7 opens testdata/v7.db, written by testdata/v7.sql before
+// indexes held the whole value, which upgrades it to format version 8
+func TestGoldenFileV7(t *testing.T) {
+	path := copyTestdata(t, "v7.db")
+	if _, err := OpenWithOptions(path, Options{ReadOnly: true}); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("read-only open error = %v, want ErrUnsupportedVersion", err)
+	}
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	checkGoldenFile(t, db)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = OpenWithOptions(path, Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("reopen the upgraded file: %v", err)
+	}
+	defer db.Close()
+	page, err := db.pager.getPage(headerPageNum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version := binary.BigEndian.Uint32(page[FileVersionOffset:]); version != FileFormatVersion {
+		t.Errorf("upgraded file has version %d, want %d", version, FileFormatVersion)
+	}
+	if keys := selectKeys(t, db, "select from events where kind = 'signup'"); !reflect.DeepEqual(keys, []uint32{4}) {
+		t.Errorf("select after reopening = %v, want [4]", keys)
+	}
+}
+
+func BenchmarkInsert(b *testing.B) {
+	for _, bm := range []struct {
+		name string
+		key  func(i int) int
+	}{
+		{"sequential", func(i int) int { return i + 1 }},
+		// the keys of a multiplicative hash are spread over the whole tree
+		{"random", func(i int) int { return int(uint32(i+1) * 2654435761) }},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			db, _ := openTestDB(b, Options{})
+			defer db.Close()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				key := strconv.Itoa(bm.key(i))
+				if err := db.Exec("insert " + key + " user" + key + " user" + key + "@example.com"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
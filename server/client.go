@@ -0,0 +1,52 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/fahmifan/scratchdb"
+)
+
+// Client is a connection to a server, it runs one statement at a time
+type Client struct {
+	conn net.Conn
+}
+
+// Dial connects to the server at addr, a host:port
+func Dial(addr string) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn}, nil
+}
+
+// Query executes the statement on the server and returns its result. A
+// statement the server rejects returns an error with its message, the
+// client stays usable.
+func (c *Client) Query(sql string) (*scratchdb.ResultSet, error) {
+	if len(sql)+1 > MaxQuerySize {
+		return nil, fmt.Errorf("query is larger than %d bytes", MaxQuerySize)
+	}
+	if err := writeFrame(c.conn, MessageQuery, []byte(sql)); err != nil {
+		return nil, err
+	}
+	typ, payload, err := readFrame(c.conn, MaxResultSize)
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case MessageResult:
+		return decodeResult(payload)
+	case MessageError:
+		return nil, errors.New(string(payload))
+	default:
+		return nil, fmt.Errorf("unexpected message %q", typ)
+	}
+}
+
+// Close disconnects from the server, an open transaction is rolled back
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/fahmifan/scratchdb"
+)
+
+// queryRequest is the body of POST /query
+type queryRequest struct {
+	SQL string `json:"sql"`
+}
+
+// queryResponse is the body answering POST /query, the rows are arrays of
+// values in column order. Blobs are base64 strings and timestamps RFC 3339
+// strings.
+type queryResponse struct {
+	Kind         string           `json:"kind"`
+	Columns      []responseColumn `json:"columns"`
+	Rows         []scratchdb.Row  `json:"rows"`
+	RowsAffected uint64           `json:"rows_affected"`
+	DurationMs   float64          `json:"duration_ms"`
+}
+
+type responseColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Handler returns the HTTP API of the server. POST /query runs the statement
+// of a {"sql": "..."} body and answers with its result as JSON, GET /healthz
+// answers 200 while the server is open. Every request runs one statement
+// like a session of its own, so transactions can't span requests and begin,
+// commit and rollback are rejected.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/query", s.handleQuery)
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	return mux
+}
+
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "use POST"})
+		return
+	}
+	var req queryRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxQuerySize)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("invalid body: %v", err)})
+		return
+	}
+	stmt, err := scratchdb.Prepare(req.SQL)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	switch stmt.Kind {
+	case scratchdb.StatementKindBegin, scratchdb.StatementKindCommit, scratchdb.StatementKindRollback:
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("%s: transactions can't span requests", stmt.Kind)})
+		return
+	}
+
+	release, err := s.acquire(r.Context(), stmt.Kind.ReadsOnly())
+	if err != nil {
+		// the request ran out of time waiting for the database, or was
+		// cancelled or the server closed
+		status := http.StatusServiceUnavailable
+		if errors.Is(err, context.DeadlineExceeded) {
+			status = http.StatusRequestTimeout
+		}
+		writeJSON(w, status, errorResponse{Error: err.Error()})
+		return
+	}
+	rs, err := s.db.QueryResult(r.Context(), req.SQL)
+	release()
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, scratchdb.ErrReadOnly) {
+			status = http.StatusForbidden
+		}
+		writeJSON(w, status, errorResponse{Error: err.Error()})
+		return
+	}
+
+	resp := queryResponse{
+		Kind:         rs.Kind.String(),
+		Columns:      make([]responseColumn, len(rs.Columns)),
+		Rows:         rs.Rows,
+		RowsAffected: rs.RowsAffected,
+		DurationMs:   float64(rs.Duration.Microseconds()) / 1000,
+	}
+	for i, col := range rs.Columns {
+		resp.Columns[i] = responseColumn{Name: col.Name, Type: col.Type.String()}
+	}
+	if resp.Rows == nil {
+		resp.Rows = []scratchdb.Row{}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "closed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(body)
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/fahmifan/scratchdb"
+)
+
+// The protocol exchanges frames: a 4 byte length of the rest of the frame,
+// a message type byte and the payload. The client sends a query and the
+// server answers with its result or an error, one at a time.
+const (
+	// MessageQuery is a statement, the payload is its text
+	MessageQuery byte = 'Q'
+	// MessageResult is the result of a statement: the statement kind (4
+	// bytes), rows affected (8), duration in nanoseconds (8), pages read (8)
+	// and written (8), the number of columns (2) and each column's name,
+	// type (1) and size (4), then the number of rows (4) and their values
+	MessageResult byte = 'R'
+	// MessageError is a failed statement, the payload is the error message
+	MessageError byte = 'E'
+
+	// MaxQuerySize is the largest query frame the server reads
+	MaxQuerySize = 1 << 20
+	// MaxResultSize is the largest result frame the client reads
+	MaxResultSize = 1 << 30
+)
+
+// The values of a result are a type tag followed by the value: 4 bytes for
+// uint32, 8 for uint64, int64, float64 and timestamps in microseconds since
+// the epoch, 1 for bool, and a 4 byte length followed by the bytes of text
+// and blobs. NULL is the tag alone.
+const (
+	tagNull byte = iota
+	tagUint32
+	tagUint64
+	tagInt64
+	tagFloat64
+	tagBool
+	tagString
+	tagBytes
+	tagTime
+)
+
+// errFrameTooLarge is returned for a frame larger than its limit, the
+// connection can't be read any further
+var errFrameTooLarge = errors.New("frame too large")
+
+// writeFrame writes a frame of the message type with the payload
+func writeFrame(w io.Writer, typ byte, payload []byte) error {
+	buf := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(1+len(payload)))
+	buf[4] = typ
+	_, err := w.Write(append(buf, payload...))
+	return err
+}
+
+// readFrame reads a frame no larger than max, it returns io.EOF when the
+// connection is closed between frames
+func readFrame(r io.Reader, max uint32) (typ byte, payload []byte, err error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size == 0 || size > max {
+		return 0, nil, fmt.Errorf("%w: %d bytes", errFrameTooLarge, size)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, nil, err
+	}
+	return buf[0], buf[1:], nil
+}
+
+// encodeResult returns the payload of a result message
+func encodeResult(rs *scratchdb.ResultSet) []byte {
+	var buf []byte
+	buf = appendUint32(buf, uint32(rs.Kind))
+	buf = appendUint64(buf, rs.RowsAffected)
+	buf = appendUint64(buf, uint64(rs.Duration))
+	buf = appendUint64(buf, rs.PagesRead)
+	buf = appendUint64(buf, rs.PagesWritten)
+	buf = append(buf, byte(len(rs.Columns)>>8), byte(len(rs.Columns)))
+	for _, col := range rs.Columns {
+		buf = appendBytes(buf, []byte(col.Name))
+		buf = append(buf, byte(col.Type))
+		buf = appendUint32(buf, col.Size)
+	}
+	buf = appendUint32(buf, uint32(len(rs.Rows)))
+	for _, row := range rs.Rows {
+		for _, value := range row {
+			buf = appendValue(buf, value)
+		}
+	}
+	return buf
+}
+
+func appendValue(buf []byte, value interface{}) []byte {
+	switch v := value.(type) {
+	case uint32:
+		return appendUint32(append(buf, tagUint32), v)
+	case uint64:
+		return appendUint64(append(buf, tagUint64), v)
+	case int64:
+		return appendUint64(append(buf, tagInt64), uint64(v))
+	case float64:
+		return appendUint64(append(buf, tagFloat64), math.Float64bits(v))
+	case bool:
+		if v {
+			return append(buf, tagBool, 1)
+		}
+		return append(buf, tagBool, 0)
+	case string:
+		return appendBytes(append(buf, tagString), []byte(v))
+	case []byte:
+		return appendBytes(append(buf, tagBytes), v)
+	case time.Time:
+		return appendUint64(append(buf, tagTime), uint64(v.UnixMicro()))
+	}
+	return append(buf, tagNull)
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	return appendUint32(appendUint32(buf, uint32(v>>32)), uint32(v))
+}
+
+func appendBytes(buf []byte, b []byte) []byte {
+	return append(appendUint32(buf, uint32(len(b))), b...)
+}
+
+// decodeResult decodes the payload of a result message
+func decodeResult(payload []byte) (*scratchdb.ResultSet, error) {
+	d := &decoder{buf: payload, ok: true}
+	rs := &scratchdb.ResultSet{
+		Kind:         scratchdb.StatementKind(d.uint32()),
+		RowsAffected: d.uint64(),
+		Duration:     time.Duration(d.uint64()),
+		PagesRead:    d.uint64(),
+		PagesWritten: d.uint64(),
+	}
+	numColumns := int(d.uint16())
+	for i := 0; i < numColumns && d.ok; i++ {
+		col := scratchdb.Column{Name: string(d.bytes())}
+		col.Type = scratchdb.ColumnType(d.uint8())
+		col.Size = d.uint32()
+		rs.Columns = append(rs.Columns, col)
+	}
+	numRows := d.uint32()
+	for i := uint32(0); i < numRows && d.ok; i++ {
+		row := make(scratchdb.Row, numColumns)
+		for j := range row {
+			row[j] = d.value()
+		}
+		rs.Rows = append(rs.Rows, row)
+	}
+	if !d.ok || len(d.buf) > 0 {
+		return nil, fmt.Errorf("malformed result message")
+	}
+	return rs, nil
+}
+
+// decoder reads the fields of a message, ok turns false once a read goes
+// past its end and every later read returns zero values
+type decoder struct {
+	buf []byte
+	ok  bool
+}
+
+func (d *decoder) next(n int) []byte {
+	if !d.ok || len(d.buf) < n {
+		d.ok = false
+		return make([]byte, n)
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) uint8() byte {
+	return d.next(1)[0]
+}
+
+func (d *decoder) uint16() uint16 {
+	return binary.BigEndian.Uint16(d.next(2))
+}
+
+func (d *decoder) uint32() uint32 {
+	return binary.BigEndian.Uint32(d.next(4))
+}
+
+func (d *decoder) uint64() uint64 {
+	return binary.BigEndian.Uint64(d.next(8))
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uint32()
+	if uint32(len(d.buf)) < n {
+		d.ok = false
+		return nil
+	}
+	return append([]byte(nil), d.next(int(n))...)
+}
+
+func (d *decoder) value() interface{} {
+	switch tag := d.uint8(); tag {
+	case tagNull:
+		return nil
+	case tagUint32:
+		return d.uint32()
+	case tagUint64:
+		return d.uint64()
+	case tagInt64:
+		return int64(d.uint64())
+	case tagFloat64:
+		return math.Float64frombits(d.uint64())
+	case tagBool:
+		return d.uint8() != 0
+	case tagString:
+		return string(d.bytes())
+	case tagBytes:
+		return d.bytes()
+	case tagTime:
+		return time.UnixMicro(int64(d.uint64())).UTC()
+	default:
+		d.ok = false
+		return nil
+	}
+}
@@ -0,0 +1,336 @@
+// Package server serves a scratchdb database to clients over TCP, with a
+// small length-prefixed protocol, and is the client of that protocol.
+//
+// Every client connection is a session running one statement at a time.
+// Statements reading only run together, a statement changing the database
+// runs alone, and a session inside a transaction holds the database until it
+// commits or rolls back, the other sessions wait for it. A session that
+// disconnects inside a transaction, or leaves it idle for TxIdleTimeout,
+// rolls it back.
+//
+// Handler serves the same database over HTTP, a JSON API running one
+// statement per request.
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/fahmifan/scratchdb"
+)
+
+// Server executes the statements of its clients against a database
+type Server struct {
+	db  *scratchdb.DB
+	log *scratchdb.Logger
+	// sem is held by the session running a statement changing the database
+	// or inside a transaction, or by the statements reading only together
+	sem chan struct{}
+	// readMu guards readers, the number of statements reading only running
+	readMu  chan struct{}
+	readers int
+
+	// TxIdleTimeout is how long a session may stay inside a transaction
+	// without running a statement, the transaction is then rolled back and
+	// the next statement of the session fails with ErrTxTimeout. 0 leaves it
+	// open. It is DefaultTxIdleTimeout unless set before Serve.
+	TxIdleTimeout time.Duration
+
+	// mu guards listeners and conns, which are closed on Close
+	mu        sync.Mutex
+	listeners map[net.Listener]bool
+	conns     map[net.Conn]bool
+	closed    bool
+	// ctx is cancelled on Close to abort the running statements
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New returns a server of the database, log may be nil
+func New(db *scratchdb.DB, log *scratchdb.Logger) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		db:        db,
+		log:       log,
+		sem:       make(chan struct{}, 1),
+		readMu:    make(chan struct{}, 1),
+		listeners: map[net.Listener]bool{},
+		conns:     map[net.Conn]bool{},
+		ctx:       ctx,
+		cancel:    cancel,
+
+		TxIdleTimeout: DefaultTxIdleTimeout,
+	}
+}
+
+// DefaultTxIdleTimeout is the TxIdleTimeout of a new server
+const DefaultTxIdleTimeout = time.Minute
+
+var (
+	// ErrServerClosed is returned by Serve after Close
+	ErrServerClosed = errors.New("server closed")
+	// ErrTxTimeout is returned for the statement following a transaction
+	// rolled back after TxIdleTimeout
+	ErrTxTimeout = errors.New("transaction rolled back after being idle")
+)
+
+// acquire holds the database for a statement outside a transaction, shared
+// with the other statements reading only when readsOnly, alone otherwise. It
+// fails once ctx is done or the server closed, the returned function
+// releases the database.
+func (s *Server) acquire(ctx context.Context, readsOnly bool) (func(), error) {
+	if !readsOnly {
+		if err := s.take(ctx, s.sem); err != nil {
+			return nil, err
+		}
+		return func() { <-s.sem }, nil
+	}
+
+	if err := s.take(ctx, s.readMu); err != nil {
+		return nil, err
+	}
+	defer func() { <-s.readMu }()
+	// the first statement reading only holds the database for the others
+	if s.readers == 0 {
+		if err := s.take(ctx, s.sem); err != nil {
+			return nil, err
+		}
+	}
+	s.readers++
+	return s.releaseRead, nil
+}
+
+// releaseRead releases the database held by a statement reading only, the
+// last one running lets the statements changing it run
+func (s *Server) releaseRead() {
+	s.readMu <- struct{}{}
+	s.readers--
+	if s.readers == 0 {
+		<-s.sem
+	}
+	<-s.readMu
+}
+
+// take sends to the channel of capacity 1 once it is empty, so it is held
+// until it is received from
+func (s *Server) take(ctx context.Context, ch chan struct{}) error {
+	select {
+	case ch <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		if s.ctx.Err() != nil {
+			return ErrServerClosed
+		}
+		return ctx.Err()
+	case <-s.ctx.Done():
+		return ErrServerClosed
+	}
+}
+
+// Serve accepts connections on l and serves each in its own goroutine until
+// Close is called
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[l] = true
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = true
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops accepting connections, aborts the running statements and
+// disconnects the clients, it returns once their sessions ended. The
+// database is left open.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.cancel()
+	s.wg.Wait()
+	return nil
+}
+
+// serveConn runs the session of the connection until the client disconnects
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	s.log.Infof("client %s connected", conn.RemoteAddr())
+	sess := &session{srv: s}
+	defer sess.end()
+	for {
+		typ, payload, err := readFrame(conn, MaxQuerySize)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				s.log.Warnf("client %s: %v", conn.RemoteAddr(), err)
+			}
+			s.log.Infof("client %s disconnected", conn.RemoteAddr())
+			return
+		}
+		if typ != MessageQuery {
+			s.log.Warnf("client %s: unexpected message %q", conn.RemoteAddr(), typ)
+			return
+		}
+
+		rs, err := sess.execute(string(payload))
+		if err != nil {
+			err = writeFrame(conn, MessageError, []byte(err.Error()))
+		} else {
+			err = writeFrame(conn, MessageResult, encodeResult(rs))
+		}
+		if err != nil {
+			s.log.Warnf("client %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// session is the state of a client connection
+type session struct {
+	srv *Server
+	// mu guards the transaction of the session, the idle timer rolls it back
+	// from its own goroutine
+	mu sync.Mutex
+	// inTx is true while the session holds the database in a transaction
+	inTx bool
+	// release releases the database held by the transaction
+	release func()
+	// idle is the timer rolling back the transaction, nil while a statement
+	// runs or outside a transaction
+	idle *time.Timer
+	// timedOut is set once the idle timer rolled back the transaction, until
+	// the next statement fails with ErrTxTimeout
+	timedOut bool
+}
+
+// execute runs the statement. Outside a transaction a statement reading only
+// waits for no session to be changing the database or inside a transaction,
+// any other statement for no session to be running one either.
+func (sess *session) execute(sql string) (*scratchdb.ResultSet, error) {
+	stmt, err := scratchdb.Prepare(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.timedOut {
+		sess.timedOut = false
+		return nil, ErrTxTimeout
+	}
+	if sess.inTx {
+		sess.stopIdleTimer()
+	} else {
+		release, err := sess.srv.acquire(sess.srv.ctx, stmt.Kind.ReadsOnly())
+		if err != nil {
+			return nil, err
+		}
+		sess.release = release
+	}
+
+	rs, err := sess.srv.db.QueryResult(sess.srv.ctx, sql)
+	switch {
+	case stmt.Kind == scratchdb.StatementKindBegin && err == nil:
+		sess.inTx = true
+	case stmt.Kind == scratchdb.StatementKindCommit, stmt.Kind == scratchdb.StatementKindRollback:
+		// the transaction is over even when its commit fails
+		if !errors.Is(err, scratchdb.ErrNoTx) {
+			sess.inTx = false
+		}
+	}
+	if sess.inTx {
+		sess.startIdleTimer()
+	} else {
+		sess.release()
+		sess.release = nil
+	}
+	return rs, err
+}
+
+// startIdleTimer starts the timer rolling back the transaction after
+// TxIdleTimeout
+func (sess *session) startIdleTimer() {
+	timeout := sess.srv.TxIdleTimeout
+	if timeout <= 0 {
+		return
+	}
+	var t *time.Timer
+	t = time.AfterFunc(timeout, func() {
+		sess.mu.Lock()
+		defer sess.mu.Unlock()
+		// a statement started since the timer fired
+		if sess.idle != t {
+			return
+		}
+		sess.srv.log.Warnf("rolling back a transaction idle for %s", timeout)
+		sess.rollback()
+		sess.timedOut = true
+	})
+	sess.idle = t
+}
+
+func (sess *session) stopIdleTimer() {
+	if sess.idle != nil {
+		sess.idle.Stop()
+		sess.idle = nil
+	}
+}
+
+// rollback rolls back the transaction of the session and releases the database
+func (sess *session) rollback() {
+	sess.stopIdleTimer()
+	sess.srv.db.Exec("rollback")
+	sess.inTx = false
+	sess.release()
+	sess.release = nil
+}
+
+// end rolls back the transaction of a disconnected session
+func (sess *session) end() {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.inTx {
+		sess.rollback()
+	}
+}
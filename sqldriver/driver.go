@@ -0,0 +1,449 @@
+// Package sqldriver registers scratchdb as a database/sql driver named
+// "scratchdb", the data source name is the path of the database file:
+//
+//	import _ "github.com/fahmifan/scratchdb/sqldriver"
+//
+//	db, err := sql.Open("scratchdb", "scratch.db")
+//	_, err = db.Exec("insert into users ? ? ?", 1, "john", "john@example.com")
+//	rows, err := db.Query("select from users where id > ?", 10)
+//
+// A path ending in ?mode=ro opens the file read-only, like
+// scratchdb.Options.ReadOnly: statements changing it fail with
+// scratchdb.ErrReadOnly and other processes may have it open.
+//
+// Arguments replace the ? placeholders outside quoted strings, written as
+// literals of their value. The connections to a file share one open database
+// and run one statement at a time, a transaction holds the database until it
+// is committed or rolled back.
+package sqldriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fahmifan/scratchdb"
+)
+
+func init() {
+	sql.Register("scratchdb", &Driver{})
+}
+
+// Driver opens connections to database files
+type Driver struct{}
+
+var (
+	// mu guards handles
+	mu sync.Mutex
+	// handles are the open databases by handle key
+	handles = map[string]*handle{}
+)
+
+// handle is a database shared by the connections to its file
+type handle struct {
+	// key is the absolute path of the file, with ?mode=ro when it is read-only
+	key string
+	db  *scratchdb.DB
+	// conns is the number of open connections
+	conns int
+	// sem is held by the connection running a statement or a transaction
+	sem chan struct{}
+}
+
+// Open returns a connection to the database file at name, the database is
+// opened by the first connection and closed with the last one
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	path, readOnly, err := parseDSN(name)
+	if err != nil {
+		return nil, err
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return nil, err
+	}
+	// a file opened read-only gets a handle of its own, which does not see
+	// the changes the read-write handle makes after it is opened
+	key := path
+	if readOnly {
+		key += "?mode=ro"
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	h, ok := handles[key]
+	if !ok {
+		db, err := scratchdb.OpenWithOptions(path, scratchdb.Options{ReadOnly: readOnly})
+		if err != nil {
+			return nil, err
+		}
+		h = &handle{key: key, db: db, sem: make(chan struct{}, 1)}
+		handles[key] = h
+	}
+	h.conns++
+	return &conn{h: h}, nil
+}
+
+// parseDSN splits the data source name into the path of the file and its
+// mode, ro or rw
+func parseDSN(name string) (path string, readOnly bool, err error) {
+	i := strings.LastIndexByte(name, '?')
+	if i < 0 {
+		return name, false, nil
+	}
+	params, err := url.ParseQuery(name[i+1:])
+	if err != nil {
+		return "", false, fmt.Errorf("invalid data source name %q: %w", name, err)
+	}
+	for key, values := range params {
+		if key != "mode" {
+			return "", false, fmt.Errorf("invalid data source name %q: unknown parameter %s", name, key)
+		}
+		switch mode := values[len(values)-1]; mode {
+		case "ro":
+			readOnly = true
+		case "rw":
+			readOnly = false
+		default:
+			return "", false, fmt.Errorf("invalid data source name %q: mode is ro or rw, got %q", name, mode)
+		}
+	}
+	return name[:i], readOnly, nil
+}
+
+// release closes the database once no connection is left
+func (h *handle) release() error {
+	mu.Lock()
+	defer mu.Unlock()
+	h.conns--
+	if h.conns > 0 {
+		return nil
+	}
+	delete(handles, h.key)
+	return h.db.Close()
+}
+
+type conn struct {
+	h *handle
+	// tx is the open transaction, the connection holds the semaphore while it is set
+	tx     *tx
+	closed bool
+}
+
+var (
+	_ driver.ConnBeginTx        = (*conn)(nil)
+	_ driver.ExecerContext      = (*conn)(nil)
+	_ driver.QueryerContext     = (*conn)(nil)
+	_ driver.ConnPrepareContext = (*conn)(nil)
+)
+
+// acquire waits for the database outside a transaction, or until ctx is done
+func (c *conn) acquire(ctx context.Context) error {
+	if c.tx != nil {
+		return nil
+	}
+	select {
+	case c.h.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release lets the other connections run statements outside a transaction
+func (c *conn) release() {
+	if c.tx == nil {
+		<-c.h.sem
+	}
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext only checks the placeholders, the statement is parsed each
+// time it runs as the arguments are part of its text
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	n, err := numPlaceholders(query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{c: c, query: query, numInput: n}, nil
+}
+
+// Close rolls back the open transaction
+func (c *conn) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if c.tx != nil {
+		c.tx.Rollback()
+	}
+	return c.h.release()
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx starts a transaction, it is serializable as no other statement runs until it ends
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	level := sql.IsolationLevel(opts.Isolation)
+	if level != sql.LevelDefault && level != sql.LevelSerializable {
+		return nil, fmt.Errorf("isolation level %s is not supported", level)
+	}
+	if c.tx != nil {
+		return nil, scratchdb.ErrTxInProgress
+	}
+	if err := c.acquire(ctx); err != nil {
+		return nil, err
+	}
+	dbTx, err := c.h.db.Begin()
+	if err != nil {
+		c.release()
+		return nil, err
+	}
+	c.tx = &tx{c: c, tx: dbTx}
+	return c.tx, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	rs, err := c.query(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return result{rowsAffected: int64(rs.RowsAffected)}, nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rs, err := c.query(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{rs: rs}, nil
+}
+
+// query binds the arguments and runs the statement. Transactions are
+// started and ended through BeginTx and the driver.Tx only.
+func (c *conn) query(ctx context.Context, query string, args []driver.NamedValue) (*scratchdb.ResultSet, error) {
+	text, err := bind(query, args)
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := scratchdb.Prepare(text)
+	if err != nil {
+		return nil, err
+	}
+	switch stmt.Kind {
+	case scratchdb.StatementKindBegin, scratchdb.StatementKindCommit, scratchdb.StatementKindRollback:
+		return nil, fmt.Errorf("%s: use the transactions of database/sql", stmt.Kind)
+	}
+
+	if err := c.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer c.release()
+	return c.h.db.QueryResult(ctx, text)
+}
+
+type tx struct {
+	c  *conn
+	tx *scratchdb.Tx
+}
+
+func (t *tx) Commit() error {
+	defer t.end()
+	return t.tx.Commit()
+}
+
+func (t *tx) Rollback() error {
+	defer t.end()
+	return t.tx.Rollback()
+}
+
+// end releases the database held by the transaction
+func (t *tx) end() {
+	if t.c.tx == t {
+		t.c.tx = nil
+		t.c.release()
+	}
+}
+
+type stmt struct {
+	c        *conn
+	query    string
+	numInput int
+}
+
+var (
+	_ driver.StmtExecContext  = (*stmt)(nil)
+	_ driver.StmtQueryContext = (*stmt)(nil)
+)
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return s.numInput
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.c.ExecContext(ctx, s.query, args)
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.c.QueryContext(ctx, s.query, args)
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return named
+}
+
+type result struct {
+	rowsAffected int64
+}
+
+// LastInsertId is not supported, the primary key of a row is always given by the insert
+func (r result) LastInsertId() (int64, error) {
+	return 0, errors.New("LastInsertId is not supported")
+}
+
+func (r result) RowsAffected() (int64, error) {
+	return r.rowsAffected, nil
+}
+
+// rows iterates over the rows of a result set, they are all selected before the first
+type rows struct {
+	rs   *scratchdb.ResultSet
+	next int
+}
+
+var _ driver.RowsColumnTypeDatabaseTypeName = (*rows)(nil)
+
+func (r *rows) Columns() []string {
+	names := make([]string, len(r.rs.Columns))
+	for i, col := range r.rs.Columns {
+		names[i] = col.Name
+	}
+	return names
+}
+
+// ColumnTypeDatabaseTypeName returns the type of the column in upper case, e.g. TEXT
+func (r *rows) ColumnTypeDatabaseTypeName(index int) string {
+	return strings.ToUpper(r.rs.Columns[index].Type.String())
+}
+
+func (r *rows) Close() error {
+	r.next = len(r.rs.Rows)
+	return nil
+}
+
+// Next converts the values of the next row to driver values, int columns
+// are int64 like integer columns
+func (r *rows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rs.Rows) {
+		return io.EOF
+	}
+	row := r.rs.Rows[r.next]
+	r.next++
+	for i, value := range row {
+		switch v := value.(type) {
+		case uint32:
+			dest[i] = int64(v)
+		case uint64:
+			dest[i] = int64(v)
+		default:
+			dest[i] = value
+		}
+	}
+	return nil
+}
+
+// bind replaces the ? placeholders of the query with the literals of the arguments
+func bind(query string, args []driver.NamedValue) (string, error) {
+	var b strings.Builder
+	n := 0
+	err := scanPlaceholders(query, func() error {
+		if n >= len(args) {
+			return fmt.Errorf("the query has more placeholders than the %d arguments", len(args))
+		}
+		arg := args[n]
+		if arg.Name != "" {
+			return fmt.Errorf("named argument %s is not supported", arg.Name)
+		}
+		b.WriteString(literal(arg.Value))
+		n++
+		return nil
+	}, &b)
+	if err != nil {
+		return "", err
+	}
+	if n != len(args) {
+		return "", fmt.Errorf("the query has %d placeholders, got %d arguments", n, len(args))
+	}
+	return b.String(), nil
+}
+
+// literal writes a driver value as scratchdb.Literal does, times in UTC
+func literal(value driver.Value) string {
+	if t, ok := value.(time.Time); ok {
+		value = t.UTC()
+	}
+	return scratchdb.Literal(value)
+}
+
+// numPlaceholders returns the number of ? placeholders in the query
+func numPlaceholders(query string) (int, error) {
+	n := 0
+	err := scanPlaceholders(query, func() error {
+		n++
+		return nil
+	}, io.Discard)
+	return n, err
+}
+
+// scanPlaceholders writes the query to wr, calling placeholder instead of
+// writing a ? outside of quoted strings
+func scanPlaceholders(query string, placeholder func() error, wr io.Writer) error {
+	var quote byte
+	start := 0
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?':
+			io.WriteString(wr, query[start:i])
+			if err := placeholder(); err != nil {
+				return err
+			}
+			start = i + 1
+		}
+	}
+	io.WriteString(wr, query[start:])
+	return nil
+}
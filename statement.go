@@ -0,0 +1,252 @@
+package scratchdb
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type PrepareResult uint32
+
+const (
+	PrepareStatementUnrecognized PrepareResult = iota + 1
+	PrepareResultSyntaxError
+	PrepareResultSuccess
+	PrepareResultNegativeNumber
+	PrepareResultNumberOutOfRange
+)
+
+// prepareResultError returns the error reported to the user for a failed result
+func prepareResultError(res PrepareResult) error {
+	switch res {
+	case PrepareResultSuccess:
+		return nil
+	case PrepareResultSyntaxError:
+		return ErrSyntax
+	case PrepareResultNegativeNumber:
+		return ErrNegativeNumber
+	case PrepareResultNumberOutOfRange:
+		return ErrNumberOutOfRange
+	default:
+		return ErrUnrecognizedStatement
+	}
+}
+
+// parseInt parses the value of an int column, ints are unsigned 32 bit
+// numbers so negative numbers and numbers past 4294967295 are rejected
+func parseInt(s string) (uint32, PrepareResult) {
+	value, err := strconv.ParseInt(s, 10, 64)
+	switch {
+	case errors.Is(err, strconv.ErrRange) && strings.HasPrefix(s, "-"):
+		return 0, PrepareResultNegativeNumber
+	case errors.Is(err, strconv.ErrRange):
+		return 0, PrepareResultNumberOutOfRange
+	case err != nil:
+		return 0, PrepareResultSyntaxError
+	case value < 0:
+		return 0, PrepareResultNegativeNumber
+	case value > math.MaxUint32:
+		return 0, PrepareResultNumberOutOfRange
+	}
+	return uint32(value), PrepareResultSuccess
+}
+
+type Statement struct {
+	Kind StatementKind
+	// Table is the name of the table the statement runs against, the default
+	// table when empty
+	Table string
+	// Values are the values of an insert, or the new values of an update, in
+	// column order. They are converted to the column types when executed.
+	Values []Value
+	// Rows are the values of each row of a multi-row insert, `insert (1, a),
+	// (2, b)`, Values is empty then
+	Rows [][]Value
+	// NumRandomRows is the number of rows to generate for `insert random N`
+	NumRandomRows uint32
+	// Columns is the select list, all the columns of the table when empty
+	Columns []SelectColumn
+	// Where are the conditions a selected or deleted row must all match
+	Where []Condition
+	// GroupBy are the columns whose values the selected rows are grouped by,
+	// the aggregates of the select list are computed for each group
+	GroupBy []string
+	// Having are the conditions a group must all match, on an aggregate or a
+	// column of the group by
+	Having []Condition
+	// OrderBy is the column the selected rows are sorted by, they come in
+	// key order when it is empty
+	OrderBy string
+	// Desc sorts the rows in descending order
+	Desc bool
+	// Limit is the most rows a select returns when HasLimit is set, after
+	// skipping the first Offset rows
+	Limit    uint32
+	HasLimit bool
+	Offset   uint32
+	// Schema is the table defined by `create table`
+	Schema Schema
+	// Index is the index defined by `create index`
+	Index Index
+}
+
+// Value is a value of an insert or update as written in the statement
+type Value struct {
+	Text string
+	// Null is set for the NULL literal, Text is empty
+	Null bool
+}
+
+type Operator uint32
+
+const (
+	OperatorEqual Operator = iota + 1
+	OperatorLess
+	OperatorLessEqual
+	OperatorGreater
+	OperatorGreaterEqual
+	// OperatorLike matches text with a pattern, e.g. `email like '%@gmail.com'`
+	OperatorLike
+	// OperatorIsNull and OperatorIsNotNull check whether the column has a
+	// value, their conditions have none
+	OperatorIsNull
+	OperatorIsNotNull
+)
+
+var operators = map[string]Operator{
+	"=":  OperatorEqual,
+	"<":  OperatorLess,
+	"<=": OperatorLessEqual,
+	">":  OperatorGreater,
+	">=": OperatorGreaterEqual,
+}
+
+func (o Operator) String() string {
+	switch o {
+	case OperatorLike:
+		return "like"
+	case OperatorIsNull:
+		return "is null"
+	case OperatorIsNotNull:
+		return "is not null"
+	}
+	for text, op := range operators {
+		if op == o {
+			return text
+		}
+	}
+	return "unknown"
+}
+
+// isGrouped reports whether the select returns groups rather than rows, it
+// has aggregates, a group by or a having
+func (s *Statement) isGrouped() bool {
+	if len(s.GroupBy) > 0 || len(s.Having) > 0 {
+		return true
+	}
+	for _, col := range s.Columns {
+		if col.Func != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// hasValue reports whether the conditions of the operator compare a value
+func (o Operator) hasValue() bool {
+	return o != OperatorIsNull && o != OperatorIsNotNull
+}
+
+// match reports whether the operator holds between the value and the value
+// of the condition. A NULL value only matches is null, it is neither equal
+// to nor different from any value.
+func (o Operator) match(value, target interface{}) bool {
+	switch {
+	case o == OperatorIsNull:
+		return value == nil
+	case o == OperatorIsNotNull:
+		return value != nil
+	case value == nil:
+		return false
+	case o == OperatorLike:
+		return matchLike(value.(string), target.(string))
+	}
+	cmp := compareValues(value, target)
+	switch o {
+	case OperatorEqual:
+		return cmp == 0
+	case OperatorLess:
+		return cmp < 0
+	case OperatorLessEqual:
+		return cmp <= 0
+	case OperatorGreater:
+		return cmp > 0
+	case OperatorGreaterEqual:
+		return cmp >= 0
+	}
+	return false
+}
+
+// Condition compares a column with Value, e.g. `id > 10` or `email = 'a@b.c'`,
+// or checks whether it is NULL, e.g. `email is null`
+type Condition struct {
+	// Column is the name of the compared column, the primary key when empty
+	Column string
+	// Func is the aggregate of the column a having condition compares, e.g. count(*) > 1
+	Func AggregateFunc
+	Op   Operator
+	// Value is converted to the column type when executed, like the values of
+	// an insert. It is empty for is null and is not null.
+	Value string
+}
+
+type StatementKind uint32
+
+const (
+	StatementKindUnknown StatementKind = iota + 1
+	StatementKindInsert
+	StatementKindSelect
+	StatementKindInsertRandom
+	StatementKindDelete
+	StatementKindUpdate
+	StatementKindBegin
+	StatementKindCommit
+	StatementKindRollback
+	StatementKindCreateTable
+	StatementKindCreateIndex
+	StatementKindVacuum
+	// StatementKindExplain describes how the select of the statement would
+	// run instead of running it, the other fields are those of the select
+	StatementKindExplain
+	StatementKindAnalyze
+)
+
+var statementKindNames = map[StatementKind]string{
+	StatementKindInsert:       "insert",
+	StatementKindSelect:       "select",
+	StatementKindInsertRandom: "insert random",
+	StatementKindDelete:       "delete",
+	StatementKindUpdate:       "update",
+	StatementKindBegin:        "begin",
+	StatementKindCommit:       "commit",
+	StatementKindRollback:     "rollback",
+	StatementKindCreateTable:  "create table",
+	StatementKindCreateIndex:  "create index",
+	StatementKindVacuum:       "vacuum",
+	StatementKindExplain:      "explain",
+	StatementKindAnalyze:      "analyze",
+}
+
+func (k StatementKind) String() string {
+	if name, ok := statementKindNames[k]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ReadsOnly reports whether statements of the kind never change the
+// database, they can run together
+func (k StatementKind) ReadsOnly() bool {
+	return k == StatementKindSelect || k == StatementKindExplain
+}
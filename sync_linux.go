@@ -0,0 +1,17 @@
+package scratchdb
+
+import (
+	"os"
+	"syscall"
+)
+
+// syncFile makes the data written to the file durable with fdatasync, which
+// skips the metadata a read doesn't need, like the modification time
+func syncFile(file *os.File) error {
+	for {
+		err := syscall.Fdatasync(int(file.Fd()))
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
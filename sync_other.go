@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package scratchdb
+
+import "os"
+
+// syncFile makes the data written to the file durable. File.Sync is
+// fcntl(F_FULLFSYNC) on macOS, a plain fsync there leaves the data in the
+// drive's cache, and FlushFileBuffers on Windows.
+func syncFile(file *os.File) error {
+	return file.Sync()
+}
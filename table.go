@@ -0,0 +1,105 @@
+package scratchdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// column sizes of the default users table, the longest username and email in bytes
+	IDSize              = IntSize
+	UsernameSize uint32 = 32
+	EmailSize    uint32 = 255
+	// DefaultPageSize is the page size of new databases unless Options.PageSize is set
+	DefaultPageSize uint32 = 4096 // 4KB
+	// MinPageSize is the smallest page a leaf of the default users table fits in twice
+	MinPageSize uint32 = 1024
+	MaxPageSize uint32 = 65536
+	// TableMaxPages is the number of addressable pages, the largest page number marks an invalid page
+	TableMaxPages = invalidPageNum
+)
+
+// Table is a B+tree of rows keyed by their primary key
+type Table struct {
+	schema      Schema
+	rootPageNum uint32
+	pager       *Pager
+	// indexes are kept up to date with every row inserted, updated or deleted
+	indexes []*index
+	// stats are gathered by analyze, nil until the table is analyzed
+	stats *tableStats
+}
+
+// tableFind returns the position of the given key,
+// if the key is not present, the position where it should be inserted
+func tableFind(table *Table, key []byte) (*Cursor, error) {
+	root, err := table.pager.getNode(table.rootPageNum)
+	if err != nil {
+		return nil, err
+	}
+
+	if root.nodeType() == NodeLeaf {
+		return leafNodeFind(table, table.rootPageNum, key)
+	}
+	return internalNodeFind(table, table.rootPageNum, key)
+}
+
+// tableKey returns the key of the row with the primary key in the table
+func tableKey(key uint32) []byte {
+	b := make([]byte, TableKeySize)
+	binary.BigEndian.PutUint64(b, uint64(key))
+	return b
+}
+
+// findRow returns the row with the primary key
+func findRow(table *Table, key uint32) (Row, error) {
+	c, err := tableFind(table, tableKey(key))
+	if err != nil {
+		return nil, err
+	}
+	n, err := table.pager.getNode(c.pageNum)
+	if err != nil {
+		return nil, err
+	}
+	if c.cellNum >= n.leafNumCells() || !bytes.Equal(n.leafKey(c.cellNum), tableKey(key)) {
+		return nil, fmt.Errorf("no row with key %d", key)
+	}
+	return deserializeRow(&table.schema, n.leafRecord(c.cellNum))
+}
+
+// checkCapacity returns ErrTableFull when splitting a leaf could run out of pages,
+// a split takes at most one new page per level of the tree plus one for a new root
+func (t *Table) checkCapacity() error {
+	n, err := t.pager.getNode(t.rootPageNum)
+	if err != nil {
+		return err
+	}
+
+	depth := uint32(1)
+	for n.nodeType() == NodeInternal {
+		n, err = t.pager.getNode(n.internalChild(0))
+		if err != nil {
+			return err
+		}
+		depth++
+	}
+
+	if depth+1 > TableMaxPages-t.pager.numPages {
+		return ErrTableFull
+	}
+	return nil
+}
+
+// maxKey returns the largest row ID in the table, 0 when the table is empty
+func (t *Table) maxKey() (uint32, error) {
+	root, err := t.pager.getNode(t.rootPageNum)
+	if err != nil {
+		return 0, err
+	}
+	key, err := getNodeMaxKey(t.pager, root)
+	if err != nil || key == nil {
+		return 0, err
+	}
+	return uint32(binary.BigEndian.Uint64(key)), nil
+}
@@ -0,0 +1,11 @@
+insert 1 alice alice@example.com
+insert 2 bob null
+insert random 200
+create table events (id int, kind text(16) not null, amount integer, ratio real, ok boolean, payload blob(8), at timestamp)
+insert into events (1, login, -5, 0.5, true, 0xdeadbeef, '2024-03-01T10:00:00+02:00'), (2, logout, null, null, false, null, '2024-03-01 12:30:00.123456')
+insert into events 3 'quoted \'kind\'' 9000000000 -1.25 1 0x 2024-03-02
+create index on users(email)
+create index events_kind on events(kind)
+delete 5
+delete 6
+update 2 bob bob@example.com
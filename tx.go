@@ -0,0 +1,88 @@
+package scratchdb
+
+import "context"
+
+// Tx is a transaction, its statements are committed to the database together
+// or not at all. A database has at most one transaction at a time, and while
+// it is open the statements executed on the DB run inside it too, the same
+// as after a `begin` statement.
+type Tx struct {
+	db *DB
+}
+
+// Begin starts a transaction
+func (db *DB) Begin() (*Tx, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	return db.begin()
+}
+
+func (db *DB) begin() (*Tx, error) {
+	if db.tx != nil {
+		return nil, ErrTxInProgress
+	}
+	db.tx = &Tx{db: db}
+	return db.tx, nil
+}
+
+// Exec executes a statement in the transaction and discards any resulting rows
+func (tx *Tx) Exec(sql string) error {
+	return tx.ExecContext(context.Background(), sql)
+}
+
+// ExecContext is like Exec, the statement is aborted with ErrCancelled when ctx is done
+func (tx *Tx) ExecContext(ctx context.Context, sql string) error {
+	_, err := tx.QueryContext(ctx, sql)
+	return err
+}
+
+// Query executes a statement in the transaction and returns the selected rows
+func (tx *Tx) Query(sql string) ([]Row, error) {
+	return tx.QueryContext(context.Background(), sql)
+}
+
+// QueryContext is like Query, the statement is aborted with ErrCancelled when ctx is done
+func (tx *Tx) QueryContext(ctx context.Context, sql string) ([]Row, error) {
+	rs, err := tx.db.query(ctx, sql, tx)
+	if err != nil {
+		return nil, err
+	}
+	return rs.Rows, nil
+}
+
+// Commit makes the changes of the transaction durable
+func (tx *Tx) Commit() error {
+	tx.db.lock.Lock()
+	defer tx.db.lock.Unlock()
+	return tx.commit()
+}
+
+func (tx *Tx) commit() error {
+	if tx.db.tx != tx {
+		return ErrTxDone
+	}
+	tx.db.tx = nil
+
+	pager := tx.db.pager
+	if err := pager.commit(); err != nil {
+		pager.rollback()
+		return err
+	}
+	return nil
+}
+
+// Rollback discards the changes of the transaction
+func (tx *Tx) Rollback() error {
+	tx.db.lock.Lock()
+	defer tx.db.lock.Unlock()
+	return tx.rollback()
+}
+
+func (tx *Tx) rollback() error {
+	if tx.db.tx != tx {
+		return ErrTxDone
+	}
+	tx.db.tx = nil
+	tx.db.pager.rollback()
+	return nil
+}
@@ -0,0 +1,186 @@
+package scratchdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// upgradableVersion is the format version Open upgrades to FileFormatVersion.
+// Its nodes lack the key size of the common node header, its tables have the
+// same 8 byte keys, and its indexes hold keys of an older encoding, so the
+// upgrade copies the rows and builds the indexes again.
+const upgradableVersion uint32 = 7
+
+// errUpgrade is returned by checkFileHeader for a file of upgradableVersion
+var errUpgrade = errors.New("file format needs an upgrade")
+
+// node layout of upgradableVersion, the common node header ends before the
+// key size and every key is 8 bytes
+const (
+	v7KeySize               = TableKeySize
+	v7InternalNumKeysOffset = InternalNodeNumKeysOffset - KeySizeSize
+	v7InternalHeaderSize    = InternalNodeHeaderSize - KeySizeSize
+	v7LeafNumCellsOffset    = LeafNodeNumCellsOffset - KeySizeSize
+	v7LeafNextLeafOffset    = LeafNodeNextLeafOffset - KeySizeSize
+	v7LeafHeaderSize        = LeafNodeHeaderSize - KeySizeSize
+)
+
+// upgradeDB rewrites a file of upgradableVersion in the current format like
+// a vacuum: the tables are copied into a new file, their indexes built again,
+// and the new file is renamed over the old one. A crash during the upgrade
+// leaves the old file as it was. An index too wide for the page size is
+// dropped with a warning.
+func upgradeDB(pager *Pager) error {
+	if pager.readOnly {
+		return fmt.Errorf("%w: %d, open it read-write to upgrade it to %d", ErrUnsupportedVersion, upgradableVersion, FileFormatVersion)
+	}
+	if err := pager.checkpoint(); err != nil {
+		return err
+	}
+
+	path := vacuumPath(pager.file.Name())
+	if err := removeVacuumFiles(path); err != nil {
+		return err
+	}
+	newPager, err := openPager(path, Options{
+		CacheSize:   pager.cache.capacity,
+		PageSize:    pager.pageSize,
+		MemoryLimit: pager.memoryLimit,
+		Logger:      pager.log,
+	})
+	if err != nil {
+		return err
+	}
+	if err := upgradeDatabase(pager, newPager); err != nil {
+		newPager.closeFiles()
+		removeVacuumFiles(path)
+		return fmt.Errorf("upgrade: %w", err)
+	}
+	if err := newPager.close(); err != nil {
+		removeVacuumFiles(path)
+		return fmt.Errorf("upgrade: %w", err)
+	}
+	if err := pager.replaceFile(path); err != nil {
+		return fmt.Errorf("upgrade: %w", err)
+	}
+	pager.log.Infof("upgrade from format version %d to %d: %d pages", upgradableVersion, FileFormatVersion, pager.numPages)
+	return nil
+}
+
+// upgradeDatabase writes the tables and indexes of the database of
+// upgradableVersion in pager to the empty database in dst
+func upgradeDatabase(pager, dst *Pager) error {
+	if err := initializeDB(dst); err != nil {
+		return err
+	}
+	src, err := pager.getPage(headerPageNum)
+	if err != nil {
+		return err
+	}
+	header, err := dst.getDirtyNode(headerPageNum)
+	if err != nil {
+		return err
+	}
+	copy(header[FileLSNOffset:FileLSNOffset+FileLSNSize], src[FileLSNOffset:])
+	tables, err := readCatalog(pager)
+	if err != nil {
+		return err
+	}
+
+	var newTables []*Table
+	for _, table := range tables {
+		newTable, err := createTable(dst, table.schema)
+		if err != nil {
+			return err
+		}
+		if err := v7Records(pager, table.rootPageNum, func(record []byte) error {
+			row, err := deserializeRow(&table.schema, record)
+			if err != nil {
+				return err
+			}
+			if err := insertRow(newTable, row); err != nil {
+				return err
+			}
+			if len(dst.dirty) >= vacuumCommitPages {
+				return dst.commit()
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		newTable.stats = table.stats
+		newTables = append(newTables, newTable)
+	}
+	if err := writeCatalog(dst, newTables); err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		for _, idx := range table.indexes {
+			err := createIndex(dst, idx.Index)
+			if errors.Is(err, ErrInvalidSchema) {
+				pager.log.Warnf("upgrade: drop index %s: %v", idx.Name, err)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := dst.commit(); err != nil {
+				return err
+			}
+		}
+	}
+	return dst.commit()
+}
+
+// v7Records calls fn with the record of every row of the tree of
+// upgradableVersion rooted at rootPageNum, in key order
+func v7Records(pager *Pager, rootPageNum uint32, fn func(record []byte) error) error {
+	pageNum := rootPageNum
+	for {
+		n, err := pager.getNode(pageNum)
+		if err != nil {
+			return err
+		}
+		if n.nodeType() == NodeLeaf {
+			break
+		}
+		if binary.BigEndian.Uint32(n[v7InternalNumKeysOffset:]) == 0 {
+			return fmt.Errorf("internal node %d has no keys", pageNum)
+		}
+		// the first cell starts with the leftmost child
+		pageNum = binary.BigEndian.Uint32(n[v7InternalHeaderSize:])
+	}
+
+	for pageNum != 0 {
+		n, err := pager.getNode(pageNum)
+		if err != nil {
+			return err
+		}
+		if n.nodeType() != NodeLeaf {
+			return fmt.Errorf("page %d is not a leaf", pageNum)
+		}
+		numCells := binary.BigEndian.Uint32(n[v7LeafNumCellsOffset:])
+		for i := uint32(0); i < numCells; i++ {
+			pointer := v7LeafHeaderSize + i*LeafNodeCellPointerSize
+			if pointer+LeafNodeCellPointerSize > uint32(len(n)) {
+				return fmt.Errorf("leaf %d has too many cells", pageNum)
+			}
+			offset := uint32(binary.BigEndian.Uint16(n[pointer:])) + v7KeySize
+			if offset+LeafNodeRecordSizeSize > uint32(len(n)) {
+				return fmt.Errorf("leaf %d has a cell out of bounds", pageNum)
+			}
+			recordSize := uint32(binary.BigEndian.Uint16(n[offset:]))
+			offset += LeafNodeRecordSizeSize
+			if offset+recordSize > uint32(len(n)) {
+				return fmt.Errorf("leaf %d has a cell out of bounds", pageNum)
+			}
+			if err := fn(n[offset : offset+recordSize]); err != nil {
+				return err
+			}
+		}
+		pageNum = binary.BigEndian.Uint32(n[v7LeafNextLeafOffset:])
+	}
+	return nil
+}
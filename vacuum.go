@@ -0,0 +1,187 @@
+package scratchdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// vacuumCommitPages is how many dirty pages the copy of a vacuum collects
+// before committing them, so the new database is never held in memory
+const vacuumCommitPages = 256
+
+// vacuumPath returns the path of the new database file a vacuum writes
+func vacuumPath(dbPath string) string {
+	return dbPath + "-vacuum"
+}
+
+// Vacuum rebuilds the database file without the pages left unused by deleted
+// rows. The tables and indexes are copied in key order into a new file next
+// to it, so their leaves are full, and the new file is renamed over the old
+// one. A crash during a vacuum leaves the old file as it was.
+//
+// It fails with ErrTxInProgress inside a transaction.
+func (db *DB) Vacuum() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	return db.vacuum(context.Background())
+}
+
+func (db *DB) vacuum(ctx context.Context) error {
+	pager := db.pager
+	if pager.readOnly {
+		return ErrReadOnly
+	}
+	if db.tx != nil {
+		return ErrTxInProgress
+	}
+	if err := pager.checkpoint(); err != nil {
+		return err
+	}
+
+	path := vacuumPath(pager.file.Name())
+	if err := removeVacuumFiles(path); err != nil {
+		return err
+	}
+	newPager, err := openPager(path, Options{
+		CacheSize:   pager.cache.capacity,
+		PageSize:    pager.pageSize,
+		MemoryLimit: pager.memoryLimit,
+		Logger:      pager.log,
+	})
+	if err != nil {
+		return err
+	}
+	if err := copyDatabase(ctx, pager, newPager); err != nil {
+		newPager.closeFiles()
+		removeVacuumFiles(path)
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	if err := newPager.close(); err != nil {
+		removeVacuumFiles(path)
+		return fmt.Errorf("vacuum: %w", err)
+	}
+
+	oldPages := pager.numPages
+	if err := pager.replaceFile(path); err != nil {
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	pager.log.Infof("vacuum: %d pages, down from %d", pager.numPages, oldPages)
+	return nil
+}
+
+// removeVacuumFiles removes the files of a vacuum at path, which a crash may
+// have left behind
+func removeVacuumFiles(path string) error {
+	for _, name := range []string{path, walPath(path)} {
+		if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("vacuum: %w", err)
+		}
+	}
+	return nil
+}
+
+// copyDatabase writes the tables and indexes of the database in pager to the
+// empty database in dst, the rows through the normal insert path
+func copyDatabase(ctx context.Context, pager, dst *Pager) error {
+	if err := initializeDB(dst); err != nil {
+		return err
+	}
+	// the commits of the copy follow those of the database, leaving a gap
+	// in the LSNs of archived logs as the copy can't be replayed
+	src, err := pager.getPage(headerPageNum)
+	if err != nil {
+		return err
+	}
+	header, err := dst.getDirtyNode(headerPageNum)
+	if err != nil {
+		return err
+	}
+	copy(header[FileLSNOffset:FileLSNOffset+FileLSNSize], src[FileLSNOffset:])
+	tables, err := readCatalog(pager)
+	if err != nil {
+		return err
+	}
+
+	var newTables []*Table
+	for _, table := range tables {
+		newTable, err := createTable(dst, table.schema)
+		if err != nil {
+			return err
+		}
+		if err := copyTree(ctx, table, newTable, func(c *Cursor) error {
+			row, err := c.Value()
+			if err != nil {
+				return err
+			}
+			return insertRow(newTable, row)
+		}); err != nil {
+			return err
+		}
+
+		for _, idx := range table.indexes {
+			rootPageNum, err := dst.allocatePage()
+			if err != nil {
+				return err
+			}
+			newIdx := newIndex(dst, idx.Index, &table.schema, idx.column, rootPageNum)
+			root, err := dst.getDirtyNode(newIdx.tree.rootPageNum)
+			if err != nil {
+				return err
+			}
+			initializeLeafNode(root, newIdx.keySize())
+			root.setRoot(true)
+
+			// the keys of the old index are already in order
+			if err := copyTree(ctx, idx.tree, newIdx.tree, func(c *Cursor) error {
+				key, err := c.key()
+				if err != nil {
+					return err
+				}
+				newCursor, err := tableFind(newIdx.tree, key)
+				if err != nil {
+					return err
+				}
+				return leafNodeInsert(newCursor, key, nil)
+			}); err != nil {
+				return err
+			}
+			newIdx.stats = idx.stats
+			newTable.indexes = append(newTable.indexes, newIdx)
+		}
+		newTable.stats = table.stats
+		newTables = append(newTables, newTable)
+	}
+
+	if err := writeCatalog(dst, newTables); err != nil {
+		return err
+	}
+	return dst.commit()
+}
+
+// copyTree calls insert for every cell of the tree in key order, committing
+// the pages of dst as they pile up
+func copyTree(ctx context.Context, src, dst *Table, insert func(c *Cursor) error) error {
+	c, err := tableSeek(src, nil)
+	if err != nil {
+		return err
+	}
+	for !c.End() {
+		if ctx.Err() != nil {
+			return ErrCancelled
+		}
+		if err := insert(c); err != nil {
+			return err
+		}
+		if len(dst.pager.dirty) >= vacuumCommitPages {
+			if err := dst.pager.commit(); err != nil {
+				return err
+			}
+		}
+		if err := c.Advance(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package scratchdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+)
+
+// WAL is a write-ahead log of page images kept next to the database file.
+//
+// Committing appends every page changed by the statement as a frame, the last
+// frame is marked as the commit, and the log is fsynced. Reads look up the
+// latest committed frame of a page before falling back to the database file,
+// which is only written when the log is checkpointed. Frames after the last
+// commit, or with a bad checksum, are ignored when the log is replayed on
+// open, so a crash mid-commit loses the statement and nothing else.
+type WAL struct {
+	// file is nil for a read-only database without a log
+	file     *os.File
+	pageSize uint32
+	readOnly bool
+	// frames maps a page number to the offset of its latest committed frame
+	frames map[uint32]int64
+	// size is the offset past the last committed frame
+	size int64
+	// numPages is the number of pages in the database as of the last commit,
+	// 0 when the log holds no commit
+	numPages uint32
+}
+
+const (
+	WALMagic      uint32 = 0x7363776c // "scwl"
+	WALHeaderSize uint32 = 8          // magic + page size
+	// a frame is the page number, the number of pages in the database for
+	// commit frames (0 otherwise), a crc32 checksum, followed by the page
+	WALFrameHeaderSize uint32 = 12
+	// WALCheckpointFrames is the log size in frames that triggers a checkpoint after a commit
+	WALCheckpointFrames = 1000
+)
+
+func walPath(dbPath string) string {
+	return dbPath + "-wal"
+}
+
+// walPageSize returns the page size in the header of the log at path,
+// ok is false when there is no log with a valid header
+func walPageSize(path string) (pageSize uint32, ok bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+
+	header := make([]byte, WALHeaderSize)
+	if _, err := file.ReadAt(header, 0); err != nil || binary.BigEndian.Uint32(header) != WALMagic {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(header[4:]), true
+}
+
+// openWAL opens the log at path and replays its committed frames, creating
+// the log when it doesn't exist. A read-only log is never written, and is
+// empty when the file doesn't exist.
+func openWAL(path string, pageSize uint32, readOnly bool) (*WAL, error) {
+	w := &WAL{pageSize: pageSize, readOnly: readOnly, frames: map[uint32]int64{}, size: int64(WALHeaderSize)}
+	flag := os.O_RDWR | os.O_CREATE
+	if readOnly {
+		flag = os.O_RDONLY
+	}
+	file, err := os.OpenFile(path, flag, 0600)
+	if readOnly && errors.Is(err, os.ErrNotExist) {
+		return w, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open wal file: %w", err)
+	}
+
+	w.file = file
+	if err := w.replay(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// replay reads the frames up to the last valid commit into the index and
+// truncates anything after it, so new frames are never followed by stale ones
+func (w *WAL) replay() error {
+	header := make([]byte, WALHeaderSize)
+	_, err := w.file.ReadAt(header, 0)
+	if errors.Is(err, io.EOF) && w.readOnly {
+		return nil
+	}
+	if errors.Is(err, io.EOF) {
+		return w.reset()
+	}
+	if err != nil {
+		return fmt.Errorf("read wal header: %w", err)
+	}
+	if binary.BigEndian.Uint32(header) != WALMagic {
+		return fmt.Errorf("not a scratchdb wal file")
+	}
+	if pageSize := binary.BigEndian.Uint32(header[4:]); pageSize != w.pageSize {
+		return fmt.Errorf("wal page size %d does not match %d", pageSize, w.pageSize)
+	}
+
+	w.size = int64(WALHeaderSize)
+	pending := map[uint32]int64{}
+	frame := make([]byte, w.frameSize())
+	for offset := w.size; ; offset += w.frameSize() {
+		if _, err := w.file.ReadAt(frame, offset); err != nil {
+			// a short read is a frame torn by a crash
+			break
+		}
+		pageNum, commitNumPages, ok := decodeFrameHeader(frame)
+		if !ok {
+			break
+		}
+
+		pending[pageNum] = offset
+		if commitNumPages == 0 {
+			continue
+		}
+		for pageNum, offset := range pending {
+			w.frames[pageNum] = offset
+		}
+		pending = map[uint32]int64{}
+		w.size = offset + w.frameSize()
+		w.numPages = commitNumPages
+	}
+
+	if w.readOnly {
+		return nil
+	}
+	if err := w.file.Truncate(w.size); err != nil {
+		return fmt.Errorf("truncate wal: %w", err)
+	}
+	return nil
+}
+
+// frameSize returns the size of a frame, its header followed by a page
+func (w *WAL) frameSize() int64 {
+	return int64(WALFrameHeaderSize) + int64(w.pageSize)
+}
+
+func decodeFrameHeader(frame []byte) (pageNum uint32, commitNumPages uint32, ok bool) {
+	pageNum = binary.BigEndian.Uint32(frame)
+	commitNumPages = binary.BigEndian.Uint32(frame[4:])
+	checksum := binary.BigEndian.Uint32(frame[8:])
+	return pageNum, commitNumPages, checksum == frameChecksum(frame)
+}
+
+// frameChecksum covers the page number, commit size and page of the frame
+func frameChecksum(frame []byte) uint32 {
+	sum := crc32.ChecksumIEEE(frame[:8])
+	return crc32.Update(sum, crc32.IEEETable, frame[WALFrameHeaderSize:])
+}
+
+// readPage reads the latest committed image of the page into page,
+// ok is false when the log has no frame for it
+func (w *WAL) readPage(pageNum uint32, page []byte) (ok bool, err error) {
+	offset, ok := w.frames[pageNum]
+	if !ok {
+		return false, nil
+	}
+	if _, err := w.file.ReadAt(page, offset+int64(WALFrameHeaderSize)); err != nil {
+		return false, fmt.Errorf("read wal frame of page %d: %w", pageNum, err)
+	}
+	return true, nil
+}
+
+// commit appends the pages as frames, the last one marked as the commit,
+// and fsyncs the log
+func (w *WAL) commit(pages map[uint32][]byte, numPages uint32) error {
+	pageNums := make([]uint32, 0, len(pages))
+	for pageNum := range pages {
+		pageNums = append(pageNums, pageNum)
+	}
+	sort.Slice(pageNums, func(i, j int) bool { return pageNums[i] < pageNums[j] })
+
+	frameSize := int(w.frameSize())
+	buf := make([]byte, len(pageNums)*frameSize)
+	for i, pageNum := range pageNums {
+		frame := buf[i*frameSize : (i+1)*frameSize]
+		binary.BigEndian.PutUint32(frame, pageNum)
+		if i == len(pageNums)-1 {
+			binary.BigEndian.PutUint32(frame[4:], numPages)
+		}
+		copy(frame[WALFrameHeaderSize:], pages[pageNum])
+		binary.BigEndian.PutUint32(frame[8:], frameChecksum(frame))
+	}
+
+	if _, err := w.file.WriteAt(buf, w.size); err != nil {
+		return fmt.Errorf("write wal: %w", err)
+	}
+	if err := syncFile(w.file); err != nil {
+		return fmt.Errorf("sync wal: %w", err)
+	}
+
+	for i, pageNum := range pageNums {
+		w.frames[pageNum] = w.size + int64(i*frameSize)
+	}
+	w.size += int64(len(buf))
+	w.numPages = numPages
+	return nil
+}
+
+// numFrames returns the number of frames in the log
+func (w *WAL) numFrames() int64 {
+	return (w.size - int64(WALHeaderSize)) / w.frameSize()
+}
+
+// reset empties the log, its pages must have been written to the database file
+func (w *WAL) reset() error {
+	header := make([]byte, WALHeaderSize)
+	binary.BigEndian.PutUint32(header, WALMagic)
+	binary.BigEndian.PutUint32(header[4:], w.pageSize)
+	if err := w.file.Truncate(0); err != nil {
+		return fmt.Errorf("truncate wal: %w", err)
+	}
+	if _, err := w.file.WriteAt(header, 0); err != nil {
+		return fmt.Errorf("write wal header: %w", err)
+	}
+	if err := syncFile(w.file); err != nil {
+		return fmt.Errorf("sync wal: %w", err)
+	}
+
+	w.frames = map[uint32]int64{}
+	w.size = int64(WALHeaderSize)
+	w.numPages = 0
+	return nil
+}